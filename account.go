@@ -3,29 +3,40 @@ package libbtc
 import (
 	"context"
 	"crypto/ecdsa"
+	"encoding/hex"
 	"time"
 
-	"github.com/btcsuite/btcd/chaincfg"
-
 	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/psbt"
 )
 
 type account struct {
-	PrivKey *btcec.PrivateKey
+	signer      Signer
+	addressType AddressType
+	compressed  bool
+	reserved    *reservations
 	Client
 }
 
+// AccountOption configures an Account.
+type AccountOption func(*account)
+
 // Account is an Bitcoin external account that can sign and submit transactions
 // to the Bitcoin blockchain. An Account is an abstraction over the Bitcoin
 // blockchain.
 type Account interface {
 	Client
 	Address() (btcutil.Address, error)
+	SegWitAddress() (*btcutil.AddressWitnessPubKeyHash, error)
+	ContractAddress(contract []byte) (btcutil.Address, error)
+	ContractP2WSHAddress(contract []byte) (btcutil.Address, error)
+	ExtractSecret(ctx context.Context, contractAddress string, secretHash [32]byte) ([]byte, error)
 	SerializedPublicKey() ([]byte, error)
-	Transfer(ctx context.Context, to string, value, fee int64, sendAll bool) (string, error)
+	Transfer(ctx context.Context, to string, value, fee int64, sendAll bool, opts ...SendOption) (string, error)
 	SendTransaction(
 		ctx context.Context,
 		script []byte,
@@ -34,20 +45,122 @@ type Account interface {
 		preCond func(*wire.MsgTx) bool,
 		f func(*txscript.ScriptBuilder),
 		postCond func(*wire.MsgTx) bool,
-	) error
+		opts ...SendOption,
+	) (string, error)
+	SendTransactionWithFeeRate(
+		ctx context.Context,
+		contract []byte,
+		feeRate int64,
+		preCond func(*wire.MsgTx) bool,
+		f func(*txscript.ScriptBuilder),
+		postCond func(*wire.MsgTx) bool,
+		opts ...SendOption,
+	) (string, error)
+	RecoverFromScript(ctx context.Context, redeemScript []byte, to string, feeRate int64, extraWitness func(*txscript.ScriptBuilder)) (string, error)
+	SendWithDeadline(ctx context.Context, outputs map[string]int64, deadline time.Time) (string, error)
+	ClassifyTransaction(ctx context.Context, tx Transaction, myAddresses map[string]bool) (map[string]int64, map[string]int64, error)
+	CancelTransaction(ctx context.Context, txid string, feeRate int64) (string, error)
+	IsReplaceable(ctx context.Context, txhash string) (bool, error)
+	ReleaseReservations()
+	EstimateConfirmationTime(ctx context.Context, feeRate int64) (time.Duration, error)
+	BumpFee(ctx context.Context, txhash string, newFeeRate int64) (string, error)
+	BumpWithChild(ctx context.Context, parentTxid string, vout uint32, feeRate int64) (string, error)
+	RedeemAndConsolidate(ctx context.Context, contract []byte, extraInputs int, to string, feeRate int64, f func(*txscript.ScriptBuilder), opts ...SendOption) (string, error)
+	AddSignature(msgTx *wire.MsgTx, inputIdx int, subscript []byte) error
+	SignMultisigInput(msgTx *wire.MsgTx, inputIdx int, redeemScript []byte) ([]byte, error)
+	Consolidate(ctx context.Context, feeRate int64, maxInputs int) (string, error)
+	EstimateConsolidationBenefit(ctx context.Context, maxInputs int, feeRate int64) (int64, int64, bool, error)
+	ListSpendable(ctx context.Context, minConf int64) ([]SpendableUTXO, error)
+	VerifyRefundTx(rawRefund []byte, contract []byte, expectedRefundAddress string, expectedLocktime, minRefundValue int64) error
+	ParseTransaction(raw []byte) (Transaction, error)
+	PublishRawHex(ctx context.Context, hexTx string) (string, error)
+	SignTransaction(msgTx *wire.MsgTx, inputs []UnspentOutput, contract []byte, f func(*txscript.ScriptBuilder), opts ...SendOption) error
+	BuildAndSign(ctx context.Context, outputs map[string]int64, absoluteFee int64, opts ...SendOption) ([]byte, string, error)
+	SendMany(ctx context.Context, outputs map[string]int64, fee int64, opts ...SendOption) (string, error)
+	Sweep(ctx context.Context, to string, feeRate int64) (string, error)
+	SendWithData(ctx context.Context, to string, value int64, data []byte, fee int64, opts ...SendOption) (string, error)
+	BuildPSBT(ctx context.Context, outputs map[string]int64, feeRate int64) (*psbt.Packet, error)
+	FinalizeAndSubmitPSBT(ctx context.Context, p *psbt.Packet) (string, error)
+}
+
+// SpendableUTXO is an unspent output of an Account, with the details needed
+// to display it to a user.
+type SpendableUTXO struct {
+	TransactionHash string
+	Vout            uint32
+	Value           int64
+	Confirmations   int64
+	Address         string
+	ScriptType      string
+}
+
+// Compressed serializes the public key of the account compressed, or
+// uncompressed, when signing and deriving legacy addresses. By default, the
+// public key is compressed on every network. Changing the encoding changes the
+// legacy address of the account, so funds sent to the address derived with
+// one encoding cannot be spent by an account using the other. SegWit
+// addresses always use the compressed public key.
+func Compressed(compressed bool) AccountOption {
+	return func(account *account) {
+		account.compressed = compressed
+	}
 }
 
 // NewAccount returns a user account for the provided private key which is
 // connected to a Bitcoin client.
-func NewAccount(client Client, privateKey *ecdsa.PrivateKey) Account {
-	return &account{
-		(*btcec.PrivateKey)(privateKey),
-		client,
+func NewAccount(client Client, privateKey *ecdsa.PrivateKey, opts ...AccountOption) Account {
+	return NewAccountWithSigner(client, newKeySigner((*btcec.PrivateKey)(privateKey)), opts...)
+}
+
+// NewAccountFromWIF returns a user account for the private key encoded in
+// Wallet Import Format, which is connected to a Bitcoin client. The key must
+// be encoded for the network of the client, and its public key is serialized
+// compressed if, and only if, the WIF says so.
+func NewAccountFromWIF(client Client, wif string, opts ...AccountOption) (Account, error) {
+	decoded, err := btcutil.DecodeWIF(wif)
+	if err != nil {
+		return nil, err
 	}
+	if !decoded.IsForNet(client.NetworkParams()) {
+		return nil, NewErrNetworkMismatch(client.NetworkParams().Name)
+	}
+	account := NewAccountWithSigner(client, newKeySigner(decoded.PrivKey), opts...).(*account)
+	account.compressed = decoded.CompressPubKey
+	return account, nil
 }
 
-// Address returns the address of the given private key
+// NewAccountWithSigner returns a user account that signs using the provided
+// Signer, instead of holding its private key, which is connected to a
+// Bitcoin client.
+func NewAccountWithSigner(client Client, signer Signer, opts ...AccountOption) Account {
+	account := &account{
+		signer:     signer,
+		compressed: true,
+		reserved:   newReservations(),
+		Client:     client,
+	}
+	for _, opt := range opts {
+		opt(account)
+	}
+	return account
+}
+
+// Address returns the address of the given private key, of the AddressType
+// of the account.
 func (account *account) Address() (btcutil.Address, error) {
+	switch account.addressType {
+	case AddressTypeLegacy:
+	case AddressTypeNativeSegWit:
+		return account.SegWitAddress()
+	case AddressTypeP2SHSegWit:
+		script, err := account.nestedSegWitScript()
+		if err != nil {
+			return nil, err
+		}
+		return btcutil.NewAddressScriptHash(script, account.NetworkParams())
+	default:
+		return nil, NewErrUnsupportedAddressType(account.addressType)
+	}
 	pubKeyBytes, err := account.SerializedPublicKey()
 	if err != nil {
 		return nil, err
@@ -56,18 +169,28 @@ func (account *account) Address() (btcutil.Address, error) {
 	if err != nil {
 		return nil, err
 	}
-	addrString := pubKey.EncodeAddress()
-	return btcutil.DecodeAddress(addrString, account.NetworkParams())
+	return pubKey.AddressPubKeyHash(), nil
 }
 
 // Transfer bitcoins to the given address
-func (account *account) Transfer(ctx context.Context, to string, value, fee int64, sendAll bool) (string, error) {
+func (account *account) Transfer(ctx context.Context, to string, value, fee int64, sendAll bool, opts ...SendOption) (string, error) {
+	if warn := newSendOptions(opts).reuse; warn != nil {
+		used, err := account.HasBeenUsed(ctx, to)
+		if err != nil {
+			return "", err
+		}
+		if used {
+			warn(to)
+		}
+	}
+
 	if sendAll {
 		me, err := account.Address()
 		if err != nil {
 			return "", err
 		}
-		balance, err := account.Balance(ctx, me.EncodeAddress(), 0)
+		// Only the outputs that can fund the transaction are sent.
+		balance, err := account.Balance(ctx, me.EncodeAddress(), newSendOptions(opts).confirmations())
 		if err != nil {
 			return "", err
 		}
@@ -78,8 +201,7 @@ func (account *account) Transfer(ctx context.Context, to string, value, fee int6
 	if err != nil {
 		return "", err
 	}
-	var txHash string
-	return txHash, account.SendTransaction(
+	return account.SendTransaction(
 		ctx,
 		nil,
 		fee,
@@ -93,10 +215,8 @@ func (account *account) Transfer(ctx context.Context, to string, value, fee int6
 			return true
 		},
 		nil,
-		func(tx *wire.MsgTx) bool {
-			txHash = tx.TxHash().String()
-			return true
-		},
+		nil,
+		opts...,
 	)
 }
 
@@ -110,6 +230,17 @@ func (account *account) Transfer(ctx context.Context, to string, value, fee int6
 // to be used with non empty contracts, to modify the signature script. preCond
 // is executed in the starting of the process, if it returns false
 // SendTransaction returns ErrPreConditionCheckFailed and stops the process.
+// opts can be used to further configure how the transaction is built. The
+// hash of the published transaction is returned. If the locktime of the
+// transaction is set, by WithLockTime or by preCond, its inputs are made
+// non-final, without which OP_CHECKLOCKTIMEVERIFY always fails. Once
+// published, postCond is checked every DefaultPollInterval (or the interval
+// given by WithPollInterval) until it returns true, and an error wrapping
+// ErrConfirmationTimeout is returned if the context is done, or the timeout
+// given by WithConfirmationTimeout passes, first. Meanwhile, the transaction
+// is published again every DefaultRebroadcastInterval, up to
+// DefaultMaxRebroadcasts times, after which an error wrapping
+// ErrRebroadcastLimit is returned.
 func (account *account) SendTransaction(
 	ctx context.Context,
 	contract []byte,
@@ -118,11 +249,13 @@ func (account *account) SendTransaction(
 	preCond func(*wire.MsgTx) bool,
 	f func(*txscript.ScriptBuilder),
 	postCond func(*wire.MsgTx) bool,
-) error {
+	opts ...SendOption,
+) (string, error) {
 	// Current Bitcoin Transaction Version (2).
-	tx := account.newTx(ctx, wire.NewMsgTx(2))
+	tx := account.newTx(ctx, wire.NewMsgTx(2), newSendOptions(opts))
+	defer tx.release()
 	if preCond != nil && !preCond(tx.msgTx) {
-		return ErrPreConditionCheckFailed
+		return "", ErrPreConditionCheckFailed
 	}
 
 	var address btcutil.Address
@@ -130,53 +263,317 @@ func (account *account) SendTransaction(
 	if contract == nil {
 		address, err = account.Address()
 		if err != nil {
-			return err
+			return "", err
 		}
 	} else {
-		address, err = btcutil.NewAddressScriptHash(contract, account.NetworkParams())
+		address, err = contractAddress(contract, tx.opts.witness, account.NetworkParams())
 		if err != nil {
-			return err
+			return "", err
 		}
 	}
 
 	if err := tx.fund(address, fee); err != nil {
-		return err
+		return "", err
+	}
+	tx.runPostFund()
+
+	if err := tx.checkFee(); err != nil {
+		return "", err
+	}
+
+	if tx.opts.bip69 {
+		tx.sort()
 	}
 
 	if err := tx.sign(f, updateTxIn, contract); err != nil {
-		return err
+		return "", err
+	}
+
+	if err := tx.verify(); err != nil {
+		return "", err
+	}
+	tx.result()
+	return tx.submitUntil(postCond)
+}
+
+// SendTransactionWithFeeRate builds, signs, verifies and publishes a
+// transaction in the same way as SendTransaction, but instead of an absolute
+// fee it pays feeRate SAT per byte of the signed transaction.
+func (account *account) SendTransactionWithFeeRate(
+	ctx context.Context,
+	contract []byte,
+	feeRate int64,
+	preCond func(*wire.MsgTx) bool,
+	f func(*txscript.ScriptBuilder),
+	postCond func(*wire.MsgTx) bool,
+	opts ...SendOption,
+) (string, error) {
+	// Current Bitcoin Transaction Version (2).
+	tx := account.newTx(ctx, wire.NewMsgTx(2), newSendOptions(opts))
+	defer tx.release()
+	if preCond != nil && !preCond(tx.msgTx) {
+		return "", ErrPreConditionCheckFailed
+	}
+
+	var address btcutil.Address
+	var err error
+	if contract == nil {
+		address, err = account.Address()
+		if err != nil {
+			return "", err
+		}
+	} else {
+		address, err = contractAddress(contract, tx.opts.witness, account.NetworkParams())
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if err := tx.fundWithFeeRate(address, feeRate, f, contract); err != nil {
+		return "", err
+	}
+
+	if err := tx.checkFee(); err != nil {
+		return "", err
+	}
+
+	if tx.opts.bip69 {
+		tx.sort()
+		if err := tx.sign(f, nil, contract); err != nil {
+			return "", err
+		}
 	}
 
 	if err := tx.verify(); err != nil {
+		return "", err
+	}
+	tx.result()
+	return tx.submitUntil(postCond)
+}
+
+// SignTransaction signs every input of the transaction, without making any
+// network requests. The inputs are the outputs spent by the inputs of the
+// transaction, in the same order. If contract is provided, inputs spending
+// from the contract are signed against it, and f is used to add data to their
+// signature scripts, as in SendTransaction. The signed transaction is
+// verified against the inputs. opts can be used to choose the sighash type
+// using WithSigHashType.
+func (account *account) SignTransaction(msgTx *wire.MsgTx, inputs []UnspentOutput, contract []byte, f func(*txscript.ScriptBuilder), opts ...SendOption) error {
+	if len(inputs) != len(msgTx.TxIn) {
+		return NewErrInputCount(len(msgTx.TxIn), len(inputs))
+	}
+	tx := account.newTx(context.Background(), msgTx, newSendOptions(opts))
+	for i, input := range inputs {
+		outPoint := msgTx.TxIn[i].PreviousOutPoint
+		if hex.EncodeToString(outPoint.Hash[:]) != input.TransactionHash || outPoint.Index != input.TransactionOutputNumber {
+			return NewErrInputMismatch(i)
+		}
+		script, err := hex.DecodeString(input.ScriptPubKey)
+		if err != nil {
+			return err
+		}
+		tx.receiveValues = append(tx.receiveValues, input.Amount)
+		tx.prevScripts = append(tx.prevScripts, script)
+	}
+	if err := tx.sign(f, nil, contract); err != nil {
 		return err
 	}
+	return tx.verify()
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return ErrPostConditionCheckFailed
-		default:
-			if err := tx.submit(); err != nil {
-				return err
-			}
-			for i := 0; i < 60; i++ {
-				if postCond == nil || postCond(tx.msgTx) {
-					return nil
-				}
-				time.Sleep(5 * time.Second)
+// BuildAndSign builds, signs and verifies a transaction paying the given
+// outputs (a map from address to value) with the given fee, but does not
+// publish it. It returns the serialized transaction and its hash, so that the
+// transaction can be published elsewhere using PublishTransaction. The outputs
+// it spends stay reserved until ReleaseReservations is called.
+func (account *account) BuildAndSign(ctx context.Context, outputs map[string]int64, absoluteFee int64, opts ...SendOption) ([]byte, string, error) {
+	tx, err := account.buildTx(ctx, outputs, absoluteFee, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	stx, err := tx.serialize()
+	if err != nil {
+		tx.release()
+		return nil, "", err
+	}
+	return stx, tx.result().TxHash, nil
+}
+
+// SendMany builds, signs, verifies and publishes a single transaction paying
+// the given outputs (a map from address to value) with the given fee. It
+// returns the hash of the published transaction.
+func (account *account) SendMany(ctx context.Context, outputs map[string]int64, fee int64, opts ...SendOption) (string, error) {
+	tx, err := account.buildTx(ctx, outputs, fee, opts)
+	if err != nil {
+		return "", err
+	}
+	defer tx.release()
+	tx.result()
+	return tx.submitUntil(nil)
+}
+
+// buildTx builds, signs and verifies a transaction paying the given outputs
+// from the account with the given fee. The outputs funding the transaction
+// stay reserved unless an error is returned.
+func (account *account) buildTx(ctx context.Context, outputs map[string]int64, fee int64, opts []SendOption) (_ *tx, err error) {
+	// Current Bitcoin Transaction Version (2).
+	tx := account.newTx(ctx, wire.NewMsgTx(2), newSendOptions(opts))
+	defer func() {
+		if err != nil {
+			tx.release()
+		}
+	}()
+	if err := tx.addOutputs(outputs); err != nil {
+		return nil, err
+	}
+	if err := tx.fund(nil, fee); err != nil {
+		return nil, err
+	}
+	tx.runPostFund()
+	if err := tx.checkFee(); err != nil {
+		return nil, err
+	}
+	if tx.opts.bip69 {
+		tx.sort()
+	}
+	if err := tx.sign(nil, nil, nil); err != nil {
+		return nil, err
+	}
+	if err := tx.verify(); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// RecoverFromScript spends all the confirmed unspent outputs of the P2SH
+// address of the given redeem script to the given address, and returns the
+// transaction hash. The fee is computed from feeRate (in SAT per byte) and
+// the size of the signed transaction. extraWitness is used to add any
+// additional data required by the redeem script to the signature script,
+// this can be nil.
+func (account *account) RecoverFromScript(ctx context.Context, redeemScript []byte, to string, feeRate int64, extraWitness func(*txscript.ScriptBuilder)) (string, error) {
+	address, err := btcutil.NewAddressScriptHash(redeemScript, account.NetworkParams())
+	if err != nil {
+		return "", err
+	}
+	toAddress, err := btcutil.DecodeAddress(to, account.NetworkParams())
+	if err != nil {
+		return "", err
+	}
+	P2PKHScript, err := txscript.PayToAddrScript(toAddress)
+	if err != nil {
+		return "", err
+	}
+
+	// Current Bitcoin Transaction Version (2).
+	tx := account.newTx(ctx, wire.NewMsgTx(2), sendOptions{})
+	defer tx.release()
+	balance, err := account.Balance(ctx, address.EncodeAddress(), tx.opts.confirmations())
+	if err != nil {
+		return "", err
+	}
+	tx.msgTx.AddTxOut(wire.NewTxOut(balance, P2PKHScript))
+	if err := tx.fund(address, 0); err != nil {
+		return "", err
+	}
+
+	if err := tx.deductFee(0, feeRate, 0, extraWitness, redeemScript); err != nil {
+		return "", err
+	}
+
+	if err := tx.verify(); err != nil {
+		return "", err
+	}
+	if err := tx.submit(); err != nil {
+		return "", err
+	}
+	return tx.msgTx.TxHash().String(), nil
+}
+
+// ClassifyTransaction returns the values received by, and sent from, each of
+// the given addresses in the transaction. Outputs are matched by decoding
+// their scripts, so every standard script type is supported. An output paying
+// to more than one of the addresses is credited to the first one.
+func (account *account) ClassifyTransaction(ctx context.Context, tx Transaction, myAddresses map[string]bool) (map[string]int64, map[string]int64, error) {
+	received := map[string]int64{}
+	sent := map[string]int64{}
+	for _, output := range tx.Outputs {
+		addresses, err := output.Addresses(account.NetworkParams())
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, address := range addresses {
+			if myAddresses[address] {
+				received[address] = received[address] + int64(output.Value)
+				break
 			}
 		}
 	}
+	for _, input := range tx.Inputs {
+		if myAddresses[input.PrevOut.Address] {
+			sent[input.PrevOut.Address] = sent[input.PrevOut.Address] + int64(input.PrevOut.Value)
+		}
+	}
+	return received, sent, nil
+}
+
+// ListSpendable returns the unspent outputs of the account with at least
+// minConf confirmations.
+func (account *account) ListSpendable(ctx context.Context, minConf int64) ([]SpendableUTXO, error) {
+	me, err := account.Address()
+	if err != nil {
+		return nil, err
+	}
+	utxos, err := account.GetUnspentOutputs(ctx, me.EncodeAddress(), 0, minConf)
+	if err != nil {
+		return nil, err
+	}
+	spendable := make([]SpendableUTXO, 0, len(utxos.Outputs))
+	for _, utxo := range utxos.Outputs {
+		if utxo.Confirmations < minConf {
+			continue
+		}
+		hashBytes, err := hex.DecodeString(utxo.TransactionHash)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := chainhash.NewHash(hashBytes)
+		if err != nil {
+			return nil, err
+		}
+		script, err := hex.DecodeString(utxo.ScriptPubKey)
+		if err != nil {
+			return nil, err
+		}
+		class, addrs, _, err := txscript.ExtractPkScriptAddrs(script, account.NetworkParams())
+		if err != nil {
+			return nil, err
+		}
+		address := ""
+		if len(addrs) > 0 {
+			address = addrs[0].EncodeAddress()
+		}
+		spendable = append(spendable, SpendableUTXO{
+			TransactionHash: hash.String(),
+			Vout:            utxo.TransactionOutputNumber,
+			Value:           utxo.Amount,
+			Confirmations:   utxo.Confirmations,
+			Address:         address,
+			ScriptType:      class.String(),
+		})
+	}
+	return spendable, nil
 }
 
+// SerializedPublicKey returns the public key of the account, serialized
+// compressed or uncompressed as configured by the Compressed option.
 func (account *account) SerializedPublicKey() ([]byte, error) {
-	pubKey := account.PrivKey.PubKey()
-	switch account.NetworkParams() {
-	case &chaincfg.MainNetParams:
-		return pubKey.SerializeCompressed(), nil
-	case &chaincfg.TestNet3Params:
+	pubKey, err := btcec.ParsePubKey(account.signer.PublicKey(), btcec.S256())
+	if err != nil {
+		return nil, err
+	}
+	if !account.compressed {
 		return pubKey.SerializeUncompressed(), nil
-	default:
-		return nil, NewErrUnsupportedNetwork(account.NetworkParams().Name)
 	}
+	return pubKey.SerializeCompressed(), nil
 }
@@ -0,0 +1,98 @@
+package libbtc
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcutil"
+)
+
+// BuildPaymentURI returns a BIP21 payment URI requesting the amount to be
+// paid to the address. The amount, label and message are optional, and are
+// omitted when zero or empty.
+func BuildPaymentURI(address string, amount btcutil.Amount, label, message string) string {
+	params := []string{}
+	if amount > 0 {
+		params = append(params, "amount="+strconv.FormatFloat(amount.ToBTC(), 'f', -1, 64))
+	}
+	if label != "" {
+		params = append(params, "label="+escapeURIParam(label))
+	}
+	if message != "" {
+		params = append(params, "message="+escapeURIParam(message))
+	}
+	if len(params) == 0 {
+		return "bitcoin:" + address
+	}
+	return "bitcoin:" + address + "?" + strings.Join(params, "&")
+}
+
+// ParsePaymentURI parses a BIP21 payment URI and returns the address, the
+// amount in SAT and the label. An error is returned if the URI is not a
+// bitcoin URI, or if it has a required parameter (prefixed with "req-") that
+// is not understood.
+func ParsePaymentURI(uri string) (string, int64, string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", 0, "", err
+	}
+	if !strings.EqualFold(u.Scheme, "bitcoin") || u.Opaque == "" {
+		return "", 0, "", NewErrInvalidPaymentURI(uri)
+	}
+	params, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return "", 0, "", err
+	}
+	for key := range params {
+		if strings.HasPrefix(key, "req-") {
+			return "", 0, "", NewErrInvalidPaymentURI(uri)
+		}
+	}
+
+	var amount int64
+	if value := params.Get("amount"); value != "" {
+		amount, err = parseBTC(value)
+		if err != nil {
+			return "", 0, "", err
+		}
+	}
+	return u.Opaque, amount, params.Get("label"), nil
+}
+
+// escapeURIParam escapes a URI parameter value, encoding spaces as %20
+// rather than "+".
+func escapeURIParam(value string) string {
+	return strings.Replace(url.QueryEscape(value), "+", "%20", -1)
+}
+
+// btcAmountPattern matches the decimal amounts of BTC accepted by parseBTC:
+// digits, optionally followed by a point and up to 8 decimal places. Signs,
+// exponents and empty parts are rejected.
+var btcAmountPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]{1,8})?$`)
+
+// parseBTC parses a decimal amount of BTC into SAT without the rounding
+// errors of floating point arithmetic.
+func parseBTC(value string) (int64, error) {
+	if !btcAmountPattern.MatchString(value) {
+		return 0, NewErrInvalidAmount(value)
+	}
+	parts := strings.SplitN(value, ".", 2)
+	whole, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || whole > btcutil.MaxSatoshi/btcutil.SatoshiPerBitcoin {
+		return 0, NewErrInvalidAmount(value)
+	}
+	var fraction int64
+	if len(parts) == 2 {
+		fraction, err = strconv.ParseInt(parts[1]+strings.Repeat("0", 8-len(parts[1])), 10, 64)
+		if err != nil {
+			return 0, NewErrInvalidAmount(value)
+		}
+	}
+	amount := whole*btcutil.SatoshiPerBitcoin + fraction
+	if amount > btcutil.MaxSatoshi {
+		return 0, NewErrInvalidAmount(value)
+	}
+	return amount, nil
+}
@@ -0,0 +1,655 @@
+package libbtc
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+const (
+	// blockCypherUnspentLimit is the maximum number of unspent outputs of an
+	// address returned by a single request to BlockCypher.
+	blockCypherUnspentLimit = 2000
+
+	// blockCypherTxLimit is the maximum number of transactions of an address
+	// returned by a single request to BlockCypher.
+	blockCypherTxLimit = 50
+
+	// blockCypherIOLimit is the maximum number of inputs and outputs of a
+	// transaction returned by BlockCypher. By default, it only returns 20.
+	blockCypherIOLimit = 1000
+
+	// blockCypherRateLimitDelay is the time waited once BlockCypher reports
+	// that no requests remain, unless it says how long to wait.
+	blockCypherRateLimitDelay = time.Second
+)
+
+type blockCypherClient struct {
+	// base is used for its URL, HTTP client, rate limit and retry policy.
+	base   client
+	token  string
+	Params *chaincfg.Params
+
+	mu          *sync.Mutex
+	pausedUntil time.Time
+}
+
+type blockCypherTxRef struct {
+	TxHash        string `json:"tx_hash"`
+	BlockHeight   int64  `json:"block_height"`
+	TxInputN      int64  `json:"tx_input_n"`
+	TxOutputN     int64  `json:"tx_output_n"`
+	Value         int64  `json:"value"`
+	Confirmations int64  `json:"confirmations"`
+	Script        string `json:"script"`
+}
+
+type blockCypherAddress struct {
+	Address           string             `json:"address"`
+	TotalReceived     int64              `json:"total_received"`
+	TotalSent         int64              `json:"total_sent"`
+	FinalBalance      int64              `json:"final_balance"`
+	FinalNTx          int64              `json:"final_n_tx"`
+	TxRefs            []blockCypherTxRef `json:"txrefs"`
+	UnconfirmedTxRefs []blockCypherTxRef `json:"unconfirmed_txrefs"`
+	Txs               []blockCypherTx    `json:"txs"`
+	HasMore           bool               `json:"hasMore"`
+}
+
+type blockCypherTx struct {
+	Hash          string `json:"hash"`
+	BlockHeight   int64  `json:"block_height"`
+	Size          int64  `json:"size"`
+	Ver           int32  `json:"ver"`
+	Confirmations int64  `json:"confirmations"`
+	Hex           string `json:"hex"`
+	Inputs        []struct {
+		PrevHash    string   `json:"prev_hash"`
+		OutputIndex int64    `json:"output_index"`
+		OutputValue uint64   `json:"output_value"`
+		Script      string   `json:"script"`
+		Sequence    uint32   `json:"sequence"`
+		Addresses   []string `json:"addresses"`
+	} `json:"inputs"`
+	Outputs []struct {
+		Value     uint64   `json:"value"`
+		Script    string   `json:"script"`
+		Addresses []string `json:"addresses"`
+	} `json:"outputs"`
+}
+
+type blockCypherBlock struct {
+	Hash      string    `json:"hash"`
+	Height    int64     `json:"height"`
+	Ver       int32     `json:"ver"`
+	PrevBlock string    `json:"prev_block"`
+	MrklRoot  string    `json:"mrkl_root"`
+	Time      time.Time `json:"time"`
+	Bits      int64     `json:"bits"`
+	Nonce     int64     `json:"nonce"`
+}
+
+type blockCypherChain struct {
+	Height         int64 `json:"height"`
+	HighFeePerKB   int64 `json:"high_fee_per_kb"`
+	MediumFeePerKB int64 `json:"medium_fee_per_kb"`
+	LowFeePerKB    int64 `json:"low_fee_per_kb"`
+}
+
+// NewBlockCypherClient returns a Client that talks to the BlockCypher v1 API
+// of the network (mainnet or testnet). The token is optional, but BlockCypher
+// only allows a few requests per hour without one. The URL of the API can be
+// changed using WithURL. It panics if the network is not supported, and no
+// URL is given.
+func NewBlockCypherClient(token string, params *chaincfg.Params, opts ...ClientOption) Client {
+	c := &blockCypherClient{
+		base:   client{height: new(heightCache), httpClient: &http.Client{Timeout: DefaultHTTPTimeout}},
+		token:  token,
+		Params: params,
+		mu:     new(sync.Mutex),
+	}
+	switch params.Net {
+	case chaincfg.MainNetParams.Net:
+		c.base.URL = "https://api.blockcypher.com/v1/btc/main"
+	case chaincfg.TestNet3Params.Net:
+		c.base.URL = "https://api.blockcypher.com/v1/btc/test3"
+	}
+	for _, opt := range opts {
+		opt(&c.base)
+	}
+	if c.base.URL == "" {
+		panic(NewErrUnsupportedNetwork(params.Name))
+	}
+	policy := c.base.retryPolicy
+	if policy == nil {
+		policy = DefaultRetryPolicy
+	}
+	c.base.retryPolicy = blockCypherRetryPolicy(policy)
+	return c
+}
+
+// blockCypherRetryPolicy retries requests using the policy, but when
+// BlockCypher rate limits a request, waits for at least as long as it asks
+// to.
+func blockCypherRetryPolicy(policy RetryPolicy) RetryPolicy {
+	return func(attempt int, err error, resp *http.Response) (bool, time.Duration) {
+		retry, delay := policy(attempt, err, resp)
+		if retry && resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+			if wait := blockCypherRetryAfter(resp); delay < wait {
+				delay = wait
+			}
+		}
+		return retry, delay
+	}
+}
+
+// blockCypherRetryAfter returns the time to wait before making another
+// request, given by the Retry-After header of the response.
+func blockCypherRetryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return blockCypherRateLimitDelay
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func (client *blockCypherClient) NetworkParams() *chaincfg.Params {
+	return client.Params
+}
+
+func (client *blockCypherClient) GetUnspentOutputs(ctx context.Context, address string, limit, confirmations int64) (Unspent, error) {
+	return client.GetUnspentOutputsPage(ctx, address, 0, limit, confirmations)
+}
+
+// GetUnspentOutputsPage returns the unspent outputs of the address. All
+// unspent outputs are fetched, so the page is taken after filtering them by
+// their confirmations.
+func (client *blockCypherClient) GetUnspentOutputsPage(ctx context.Context, address string, offset, limit, confirmations int64) (Unspent, error) {
+	utxos := Unspent{}
+	query := url.Values{}
+	query.Set("unspentOnly", "true")
+	query.Set("includeScript", "true")
+	query.Set("limit", strconv.Itoa(blockCypherUnspentLimit))
+	seen := map[string]bool{}
+	for {
+		info := blockCypherAddress{}
+		if err := client.getJSON(ctx, fmt.Sprintf("/addrs/%s", address), query, &info); err != nil {
+			return utxos, err
+		}
+		added := false
+		lowest := int64(0)
+		txRefs := append(info.UnconfirmedTxRefs, info.TxRefs...)
+		for _, txRef := range txRefs {
+			if txRef.BlockHeight > 0 && (lowest == 0 || txRef.BlockHeight < lowest) {
+				lowest = txRef.BlockHeight
+			}
+			outpoint := fmt.Sprintf("%s:%d", txRef.TxHash, txRef.TxOutputN)
+			if seen[outpoint] {
+				continue
+			}
+			seen[outpoint] = true
+			added = true
+			if txRef.Confirmations < confirmations {
+				continue
+			}
+			if offset > 0 {
+				offset--
+				continue
+			}
+			if limit > 0 && int64(len(utxos.Outputs)) >= limit {
+				return utxos, nil
+			}
+			// Unspent outputs identify their transaction by its hash in
+			// little-endian byte order.
+			hash, err := chainhash.NewHashFromStr(txRef.TxHash)
+			if err != nil {
+				return utxos, err
+			}
+			utxos.Outputs = append(utxos.Outputs, UnspentOutput{
+				TransactionHash:         hex.EncodeToString(hash[:]),
+				TransactionOutputNumber: uint32(txRef.TxOutputN),
+				ScriptPubKey:            txRef.Script,
+				Amount:                  txRef.Value,
+				Confirmations:           txRef.Confirmations,
+			})
+		}
+		if !info.HasMore || !added {
+			return utxos, nil
+		}
+		before, err := client.before(ctx, lowest)
+		if err != nil {
+			return utxos, err
+		}
+		query.Set("before", before)
+	}
+}
+
+// before returns the before parameter requesting the page that follows a page
+// whose lowest block height is given. Entries in the same block as the lowest
+// entry might not have been returned yet, so the block is requested again, and
+// the entries that were already returned are skipped. Pages stop once a
+// request returns no new entries, so that a block with more entries than fit
+// in a page cannot be requested forever. Unconfirmed entries have no block
+// height to page by, so a page of only unconfirmed entries is followed by the
+// confirmed entries from the latest block.
+func (client *blockCypherClient) before(ctx context.Context, lowest int64) (string, error) {
+	if lowest <= 0 {
+		height, err := client.GetBlockHeight(ctx)
+		if err != nil {
+			return "", err
+		}
+		lowest = height
+	}
+	return strconv.FormatInt(lowest+1, 10), nil
+}
+
+func (client *blockCypherClient) GetRawTransaction(ctx context.Context, txhash string) (Transaction, error) {
+	tx, err := client.transaction(ctx, txhash, false)
+	if err != nil {
+		return Transaction{}, err
+	}
+	return tx.transaction(), nil
+}
+
+func (client *blockCypherClient) GetSerializedTransaction(ctx context.Context, txhash string) ([]byte, error) {
+	tx, err := client.transaction(ctx, txhash, true)
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(tx.Hex)
+}
+
+func (client *blockCypherClient) transaction(ctx context.Context, txhash string, includeHex bool) (blockCypherTx, error) {
+	tx := blockCypherTx{}
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(blockCypherIOLimit))
+	if includeHex {
+		query.Set("includeHex", "true")
+	}
+	return tx, client.getJSON(ctx, fmt.Sprintf("/txs/%s", txhash), query, &tx)
+}
+
+// transaction converts the BlockCypher transaction into a Transaction.
+func (tx blockCypherTx) transaction() Transaction {
+	transaction := Transaction{
+		TransactionHash: tx.Hash,
+		Version:         uint8(tx.Ver),
+		VinSize:         uint32(len(tx.Inputs)),
+		VoutSize:        uint32(len(tx.Outputs)),
+		Size:            tx.Size,
+	}
+	// Unconfirmed transactions have a block height of -1.
+	if tx.BlockHeight > 0 {
+		transaction.BlockHeight = tx.BlockHeight
+	}
+	for _, input := range tx.Inputs {
+		in := Input{
+			PrevOut: PreviousOut{
+				TransactionHash: input.PrevHash,
+				Value:           input.OutputValue,
+				VoutNumber:      uint32(input.OutputIndex),
+			},
+			Script:   input.Script,
+			Sequence: input.Sequence,
+		}
+		if len(input.Addresses) > 0 {
+			in.PrevOut.Address = input.Addresses[0]
+		}
+		transaction.Inputs = append(transaction.Inputs, in)
+	}
+	for _, output := range tx.Outputs {
+		out := Output{
+			Value:           output.Value,
+			TransactionHash: tx.Hash,
+			Script:          output.Script,
+		}
+		if len(output.Addresses) > 0 {
+			out.Address = output.Addresses[0]
+		}
+		transaction.Outputs = append(transaction.Outputs, out)
+	}
+	return transaction
+}
+
+// GetRawAddressInformation returns the information of the address, with all
+// of its transactions, newest first.
+func (client *blockCypherClient) GetRawAddressInformation(ctx context.Context, addr string) (SingleAddress, error) {
+	addressInfo, err := client.addressBalance(ctx, addr)
+	if err != nil {
+		return addressInfo, err
+	}
+	seen := map[string]bool{}
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(blockCypherTxLimit))
+	query.Set("txlimit", strconv.Itoa(blockCypherIOLimit))
+	for {
+		address := blockCypherAddress{}
+		if err := client.getJSON(ctx, fmt.Sprintf("/addrs/%s/full", addr), query, &address); err != nil {
+			return addressInfo, err
+		}
+		added := false
+		lowest := int64(0)
+		for _, tx := range address.Txs {
+			if tx.BlockHeight > 0 && (lowest == 0 || tx.BlockHeight < lowest) {
+				lowest = tx.BlockHeight
+			}
+			if seen[tx.Hash] {
+				continue
+			}
+			seen[tx.Hash] = true
+			added = true
+			addressInfo.Transactions = append(addressInfo.Transactions, tx.transaction())
+		}
+		if !address.HasMore || !added {
+			return addressInfo, nil
+		}
+		before, err := client.before(ctx, lowest)
+		if err != nil {
+			return addressInfo, err
+		}
+		query.Set("before", before)
+	}
+}
+
+// GetAddressTransactions returns up to limit transactions of the address,
+// newest first, skipping the first offset transactions.
+func (client *blockCypherClient) GetAddressTransactions(ctx context.Context, addr string, offset, limit int) ([]Transaction, error) {
+	addressInfo, err := client.GetRawAddressInformation(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return pageTransactions(addressInfo.Transactions, offset, limit), nil
+}
+
+// addressBalance returns the information of the address, without its
+// transactions. BlockCypher only counts confirmed transactions in the
+// received and sent values, but unconfirmed ones in the balance.
+func (client *blockCypherClient) addressBalance(ctx context.Context, addr string) (SingleAddress, error) {
+	address := blockCypherAddress{}
+	if err := client.getJSON(ctx, fmt.Sprintf("/addrs/%s/balance", addr), nil, &address); err != nil {
+		return SingleAddress{}, err
+	}
+	return SingleAddress{
+		Address:          address.Address,
+		TransactionCount: address.FinalNTx,
+		Received:         address.TotalReceived,
+		Sent:             address.TotalSent,
+		Balance:          address.FinalBalance,
+	}, nil
+}
+
+func (client *blockCypherClient) PublishTransaction(ctx context.Context, signedTransaction []byte) error {
+	body, err := json.Marshal(struct {
+		Tx string `json:"tx"`
+	}{hex.EncodeToString(signedTransaction)})
+	if err != nil {
+		return err
+	}
+	_, err = client.do(ctx, "POST", "/txs/push", nil, body, func(resp *http.Response, respBytes []byte) error {
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+			return NewErrBitcoinSubmitTx(string(respBytes))
+		}
+		return nil
+	})
+	return err
+}
+
+func (client *blockCypherClient) Balance(ctx context.Context, address string, confirmations int64) (int64, error) {
+	unspent, err := client.GetUnspentOutputs(ctx, address, 0, confirmations)
+	if err != nil {
+		return 0, err
+	}
+	balance := int64(0)
+	for _, utxo := range unspent.Outputs {
+		balance = balance + utxo.Amount
+	}
+	return balance, nil
+}
+
+func (client *blockCypherClient) BalanceDetails(ctx context.Context, address string) (int64, int64, error) {
+	return balanceDetails(ctx, client, address)
+}
+
+func (client *blockCypherClient) ScriptSpent(ctx context.Context, address string) (bool, error) {
+	rawAddress, err := client.addressBalance(ctx, address)
+	if err != nil {
+		return false, err
+	}
+	return rawAddress.Sent > 0, nil
+}
+
+func (client *blockCypherClient) HasBeenUsed(ctx context.Context, address string) (bool, error) {
+	rawAddress, err := client.addressBalance(ctx, address)
+	if err != nil {
+		return false, err
+	}
+	return rawAddress.TransactionCount > 0, nil
+}
+
+func (client *blockCypherClient) BalanceDelta(ctx context.Context, address string, fromHeight, toHeight int64) (int64, error) {
+	rawAddress, err := client.GetRawAddressInformation(ctx, address)
+	if err != nil {
+		return 0, err
+	}
+	delta := int64(0)
+	for _, tx := range rawAddress.Transactions {
+		// Unconfirmed transactions have no block height.
+		if tx.BlockHeight == 0 || tx.BlockHeight < fromHeight || tx.BlockHeight > toHeight {
+			continue
+		}
+		txDelta, err := transactionDelta(tx, address, client.Params)
+		if err != nil {
+			return 0, err
+		}
+		delta = delta + txDelta
+	}
+	return delta, nil
+}
+
+func (client *blockCypherClient) ScriptFunded(ctx context.Context, address string, value int64) (bool, int64, error) {
+	rawAddress, err := client.addressBalance(ctx, address)
+	if err != nil {
+		return false, 0, err
+	}
+	return rawAddress.Received >= value, rawAddress.Received, nil
+}
+
+func (client *blockCypherClient) ScriptRedeemed(ctx context.Context, address string, value int64) (bool, int64, error) {
+	rawAddress, err := client.addressBalance(ctx, address)
+	if err != nil {
+		return false, 0, err
+	}
+	return rawAddress.Received >= value && rawAddress.Balance == 0, rawAddress.Balance, nil
+}
+
+// GetScriptFromSpentP2SH waits for the address to be spent from, and returns
+// the signature script of the first input spending from it.
+func (client *blockCypherClient) GetScriptFromSpentP2SH(ctx context.Context, address string) ([]byte, error) {
+	if err := waitForSpend(ctx, client, address); err != nil {
+		return nil, err
+	}
+	return spendingScript(ctx, client, address)
+}
+
+func (client *blockCypherClient) Confirmations(ctx context.Context, txHash string) (int64, error) {
+	tx, err := client.transaction(ctx, txHash, false)
+	if err != nil {
+		return 0, err
+	}
+	return tx.Confirmations, nil
+}
+
+func (client *blockCypherClient) GetTransactionStatus(ctx context.Context, txHash string) (TxStatus, error) {
+	return transactionStatus(ctx, client, txHash)
+}
+
+func (client *blockCypherClient) WaitForConfirmations(ctx context.Context, txHash string, n int64) error {
+	return waitForConfirmations(ctx, client, txHash, n)
+}
+
+func (client *blockCypherClient) GetBlockHeight(ctx context.Context) (int64, error) {
+	return client.base.height.get(func() (int64, error) {
+		chain := blockCypherChain{}
+		if err := client.getJSON(ctx, "", nil, &chain); err != nil {
+			return 0, err
+		}
+		return chain.Height, nil
+	})
+}
+
+// EstimateFeeRate returns the fee rate estimated by BlockCypher. A target of
+// up to 2 blocks uses its high fee, a target of up to 6 blocks uses its
+// medium fee, and longer targets use its low fee.
+func (client *blockCypherClient) EstimateFeeRate(ctx context.Context, targetBlocks int) (int64, error) {
+	chain := blockCypherChain{}
+	if err := client.getJSON(ctx, "", nil, &chain); err != nil {
+		return 0, err
+	}
+	feePerKB := chain.LowFeePerKB
+	switch {
+	case targetBlocks <= 2:
+		feePerKB = chain.HighFeePerKB
+	case targetBlocks <= 6:
+		feePerKB = chain.MediumFeePerKB
+	}
+	return minFeeRate(int64(math.Ceil(float64(feePerKB) / 1000))), nil
+}
+
+func (client *blockCypherClient) GetBlockHeader(ctx context.Context, hash string) (BlockHeader, error) {
+	header, err := client.blockHeader(ctx, hash)
+	if err != nil {
+		return header, err
+	}
+	// The block is on the main chain if it is the block at its height.
+	mainChain, err := client.blockHeader(ctx, strconv.FormatInt(header.Height, 10))
+	if err != nil {
+		return header, err
+	}
+	header.MainChain = mainChain.BlockHash == header.BlockHash
+	return header, nil
+}
+
+func (client *blockCypherClient) GetBlockHeaderByHeight(ctx context.Context, height int64) (BlockHeader, error) {
+	header, err := client.blockHeader(ctx, strconv.FormatInt(height, 10))
+	if err != nil {
+		return header, err
+	}
+	header.MainChain = true
+	return header, nil
+}
+
+// blockHeader returns the header of the block with the given hash or height.
+func (client *blockCypherClient) blockHeader(ctx context.Context, hashOrHeight string) (BlockHeader, error) {
+	block := blockCypherBlock{}
+	// The transactions of the block are not needed.
+	query := url.Values{}
+	query.Set("limit", "1")
+	if err := client.getJSON(ctx, fmt.Sprintf("/blocks/%s", hashOrHeight), query, &block); err != nil {
+		return BlockHeader{}, err
+	}
+	return BlockHeader{
+		BlockHash:         block.Hash,
+		Version:           uint8(block.Ver),
+		PreviousBlockHash: block.PrevBlock,
+		MerkleRoot:        block.MrklRoot,
+		Time:              block.Time.Unix(),
+		Bits:              block.Bits,
+		Nonce:             block.Nonce,
+		Height:            block.Height,
+	}, nil
+}
+
+func (client *blockCypherClient) FormatTransactionView(msg, txhash string) string {
+	return formatTransactionView(client.Params, msg, txhash)
+}
+
+func (client *blockCypherClient) getJSON(ctx context.Context, path string, query url.Values, v interface{}) error {
+	respBytes, err := client.do(ctx, "GET", path, query, nil, func(resp *http.Response, respBytes []byte) error {
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return NewErrUnexpectedStatus(resp.StatusCode, string(respBytes))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(respBytes, v)
+}
+
+// do makes a request to the path of the API, with the token of the client,
+// and retries it until check accepts the response. Once BlockCypher reports
+// that no requests remain, the next request is delayed.
+func (client *blockCypherClient) do(ctx context.Context, method, path string, query url.Values, body []byte, check func(*http.Response, []byte) error) ([]byte, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	if client.token != "" {
+		query.Set("token", client.token)
+	}
+	u := client.base.URL + path
+	if len(query) > 0 {
+		u = u + "?" + query.Encode()
+	}
+	var respBytes []byte
+	err := client.base.backoff(ctx, func() (*http.Response, error) {
+		if err := client.pause(ctx); err != nil {
+			return nil, err
+		}
+		var reqBody io.Reader
+		if body != nil {
+			reqBody = bytes.NewReader(body)
+		}
+		r, err := http.NewRequest(method, u, reqBody)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := client.base.httpClient.Do(r.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.Header.Get("X-Ratelimit-Remaining") == "0" {
+			client.mu.Lock()
+			client.pausedUntil = time.Now().Add(blockCypherRetryAfter(resp))
+			client.mu.Unlock()
+		}
+		respBytes, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return resp, err
+		}
+		return resp, check(resp, respBytes)
+	})
+	return respBytes, err
+}
+
+// pause waits until BlockCypher accepts requests again.
+func (client *blockCypherClient) pause(ctx context.Context) error {
+	client.mu.Lock()
+	wait := time.Until(client.pausedUntil)
+	client.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ErrTimedOut
+	case <-time.After(wait):
+		return nil
+	}
+}
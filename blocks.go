@@ -0,0 +1,99 @@
+package libbtc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// BlockClient is a Client that can fetch whole blocks, with their
+// transactions. The clients returned by NewBlockchainInfoClient and
+// NewClientWithParams are BlockClients, and can be converted using a type
+// assertion.
+type BlockClient interface {
+	Client
+
+	// GetBlock returns the block with the given hash.
+	GetBlock(ctx context.Context, hash string) (Block, error)
+
+	// GetBlockByHeight returns the block at the given height on the main
+	// chain.
+	GetBlockByHeight(ctx context.Context, height int64) (Block, error)
+
+	// ScanBlocks calls fn with each block on the main chain from height from
+	// to height to (inclusive), in order. It stops at the first error
+	// returned by fn, and returns it.
+	ScanBlocks(ctx context.Context, from, to int64, fn func(Block) error) error
+}
+
+// GetBlock returns the block with the given hash, using the rawblock endpoint
+// of blockchain.info.
+func (client *client) GetBlock(ctx context.Context, hash string) (Block, error) {
+	block := Block{}
+	err := client.backoff(ctx, func() (*http.Response, error) {
+		resp, err := client.get(ctx, fmt.Sprintf("%s/rawblock/%s", client.URL, hash))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		blockBytes, err := readBody(resp)
+		if err != nil {
+			return resp, err
+		}
+		return resp, json.Unmarshal(blockBytes, &block)
+	})
+	return block, err
+}
+
+// ScanBlocks fetches the blocks from height from to height to (inclusive)
+// one at a time, using the block-height endpoint of blockchain.info, and
+// calls fn with each of them. Requests are retried with the backoff of the
+// client, and ErrTimedOut is returned once the context is done. Scanning is
+// best-effort: every block is fetched with all of its transactions, so long
+// ranges are slow and likely to be rate limited, and blocks near the tip can
+// be reorganised while they are scanned.
+func (client *client) ScanBlocks(ctx context.Context, from, to int64, fn func(Block) error) error {
+	for height := from; height <= to; height++ {
+		select {
+		case <-ctx.Done():
+			return ErrTimedOut
+		default:
+		}
+		block, err := client.GetBlockByHeight(ctx, height)
+		if err != nil {
+			return err
+		}
+		if err := fn(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetBlockByHeight returns the block at the given height on the main chain,
+// using the block-height endpoint of blockchain.info.
+func (client *client) GetBlockByHeight(ctx context.Context, height int64) (Block, error) {
+	blocks := Blocks{}
+	err := client.backoff(ctx, func() (*http.Response, error) {
+		resp, err := client.get(ctx, fmt.Sprintf("%s/block-height/%d?format=json", client.URL, height))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		blocksBytes, err := readBody(resp)
+		if err != nil {
+			return resp, err
+		}
+		return resp, json.Unmarshal(blocksBytes, &blocks)
+	})
+	if err != nil {
+		return Block{}, err
+	}
+	for _, block := range blocks.Blocks {
+		if block.MainChain {
+			return block, nil
+		}
+	}
+	return Block{}, NewErrBlockNotFound(fmt.Sprintf("%d", height))
+}
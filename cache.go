@@ -0,0 +1,335 @@
+package libbtc
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// CacheStore is a key value store used by a cached Client to persist the data
+// it fetches. Implementations can be backed by anything from an in-memory map
+// to a database, which allows the cache to survive restarts.
+type CacheStore interface {
+	// Get should return the value stored against the key, and false if there
+	// is no such value.
+	Get(key string) ([]byte, bool, error)
+
+	// Set should store the value against the key, replacing any existing
+	// value.
+	Set(key string, value []byte) error
+
+	// Delete should remove the value stored against the key, if any.
+	Delete(key string) error
+}
+
+type memoryCacheStore struct {
+	mu     *sync.RWMutex
+	values map[string][]byte
+}
+
+// NewMemoryCacheStore returns a CacheStore that keeps its values in memory.
+func NewMemoryCacheStore() CacheStore {
+	return &memoryCacheStore{
+		mu:     new(sync.RWMutex),
+		values: map[string][]byte{},
+	}
+}
+
+func (store *memoryCacheStore) Get(key string) ([]byte, bool, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	value, ok := store.values[key]
+	return value, ok, nil
+}
+
+func (store *memoryCacheStore) Set(key string, value []byte) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.values[key] = value
+	return nil
+}
+
+func (store *memoryCacheStore) Delete(key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.values, key)
+	return nil
+}
+
+// CachedClient is a Client that serves unspent outputs, balances and
+// confirmed raw transactions from a CacheStore, and only calls the underlying
+// Client when the cached value is missing or older than the TTL. Publishing a
+// transaction through it invalidates the addresses it spends from and pays
+// to.
+type CachedClient interface {
+	Client
+
+	// InvalidateAddress removes the cached unspent outputs of the address.
+	// This should be called after spending from the address.
+	InvalidateAddress(address string) error
+
+	// InvalidateTransaction removes the cached transaction.
+	InvalidateTransaction(txhash string) error
+}
+
+type cachedClient struct {
+	Client
+	store CacheStore
+	ttl   time.Duration
+}
+
+// cachedUnspent is the unspent outputs of an address as they are cached.
+// The block height of each output is stored instead of its confirmations,
+// which are recomputed from the latest block height whenever the outputs are
+// loaded. Height is the latest block height when the outputs were fetched,
+// so that outputs that were unconfirmed at the time are fetched again once a
+// block has been mined.
+type cachedUnspent struct {
+	Height  int64                 `json:"height"`
+	Outputs []cachedUnspentOutput `json:"outputs"`
+}
+
+type cachedUnspentOutput struct {
+	UnspentOutput
+	BlockHeight int64 `json:"block_height"`
+}
+
+type cacheEntry struct {
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+// NewCachedClient returns a CachedClient that wraps the given client and
+// persists its unspent outputs and raw transactions in the store for the
+// given TTL. Every unspent output of an address is fetched at once, and
+// pages, balances and confirmation filters are served from the cached set,
+// so that funding a transaction does not call the underlying client either.
+func NewCachedClient(client Client, store CacheStore, ttl time.Duration) CachedClient {
+	return &cachedClient{
+		Client: client,
+		store:  store,
+		ttl:    ttl,
+	}
+}
+
+func (client *cachedClient) GetUnspentOutputs(ctx context.Context, address string, limit, confirmations int64) (Unspent, error) {
+	return client.GetUnspentOutputsPage(ctx, address, 0, limit, confirmations)
+}
+
+func (client *cachedClient) GetUnspentOutputsPage(ctx context.Context, address string, offset, limit, confirmations int64) (Unspent, error) {
+	utxos := Unspent{}
+	all, err := client.unspent(ctx, address)
+	if err != nil {
+		return utxos, err
+	}
+	for _, utxo := range all.Outputs {
+		if utxo.Confirmations < confirmations {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if limit > 0 && int64(len(utxos.Outputs)) >= limit {
+			break
+		}
+		utxos.Outputs = append(utxos.Outputs, utxo)
+	}
+	return utxos, nil
+}
+
+func (client *cachedClient) Balance(ctx context.Context, address string, confirmations int64) (int64, error) {
+	utxos, err := client.GetUnspentOutputs(ctx, address, 0, confirmations)
+	if err != nil {
+		return 0, err
+	}
+	balance := int64(0)
+	for _, utxo := range utxos.Outputs {
+		balance = balance + utxo.Amount
+	}
+	return balance, nil
+}
+
+func (client *cachedClient) BalanceDetails(ctx context.Context, address string) (int64, int64, error) {
+	return balanceDetails(ctx, client, address)
+}
+
+// unspent returns every unspent output of the address, including unconfirmed
+// outputs, from the store if it is cached.
+func (client *cachedClient) unspent(ctx context.Context, address string) (Unspent, error) {
+	utxos := Unspent{}
+	height, err := client.Client.GetBlockHeight(ctx)
+	if err != nil {
+		return utxos, err
+	}
+	key := unspentCacheKey(address)
+	entry, ok, err := client.load(key)
+	if err != nil {
+		return utxos, err
+	}
+	if ok {
+		cached := cachedUnspent{}
+		if err := json.Unmarshal(entry.Data, &cached); err != nil {
+			return utxos, err
+		}
+		if utxos, ok := cached.unspent(height); ok {
+			return utxos, nil
+		}
+	}
+
+	utxos, err = client.Client.GetUnspentOutputs(ctx, address, 0, 0)
+	if err != nil {
+		return utxos, err
+	}
+	cached := cachedUnspent{
+		Height:  height,
+		Outputs: make([]cachedUnspentOutput, len(utxos.Outputs)),
+	}
+	for i, utxo := range utxos.Outputs {
+		cached.Outputs[i] = cachedUnspentOutput{UnspentOutput: utxo}
+		if utxo.Confirmations > 0 {
+			cached.Outputs[i].BlockHeight = height - utxo.Confirmations + 1
+		}
+	}
+	return utxos, client.save(key, cached)
+}
+
+// unspent returns the cached unspent outputs with their confirmations at the
+// given block height, and false if an output that was unconfirmed when it was
+// cached could have been confirmed since.
+func (cached cachedUnspent) unspent(height int64) (Unspent, bool) {
+	utxos := Unspent{Outputs: make([]UnspentOutput, len(cached.Outputs))}
+	for i, utxo := range cached.Outputs {
+		if utxo.BlockHeight <= 0 && height != cached.Height {
+			return Unspent{}, false
+		}
+		utxos.Outputs[i] = utxo.UnspentOutput
+		utxos.Outputs[i].Confirmations = 0
+		if utxo.BlockHeight > 0 {
+			utxos.Outputs[i].Confirmations = height - utxo.BlockHeight + 1
+		}
+	}
+	return utxos, true
+}
+
+// GetRawTransaction returns the transaction from the store if it is cached.
+// Only confirmed transactions are cached, because unconfirmed transactions
+// can still be replaced, dropped or confirmed.
+func (client *cachedClient) GetRawTransaction(ctx context.Context, txhash string) (Transaction, error) {
+	transaction := Transaction{}
+	key := transactionCacheKey(txhash)
+	entry, ok, err := client.load(key)
+	if err != nil {
+		return transaction, err
+	}
+	if ok {
+		return transaction, json.Unmarshal(entry.Data, &transaction)
+	}
+
+	transaction, err = client.Client.GetRawTransaction(ctx, txhash)
+	if err != nil || transaction.BlockHeight <= 0 {
+		return transaction, err
+	}
+	return transaction, client.save(key, transaction)
+}
+
+// PublishTransaction publishes the transaction using the underlying client,
+// and then invalidates the transaction and the unspent outputs of every
+// address it spends from or pays to.
+func (client *cachedClient) PublishTransaction(ctx context.Context, signedTransaction []byte) error {
+	if err := client.Client.PublishTransaction(ctx, signedTransaction); err != nil {
+		return err
+	}
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	if err := msgTx.Deserialize(bytes.NewReader(signedTransaction)); err != nil {
+		return err
+	}
+	if err := client.InvalidateTransaction(msgTx.TxHash().String()); err != nil {
+		return err
+	}
+	addresses := map[string]struct{}{}
+	for _, txIn := range msgTx.TxIn {
+		// The transaction has already been published, so an address that
+		// cannot be looked up is left to expire rather than failing the
+		// publication.
+		prev, err := client.GetRawTransaction(ctx, txIn.PreviousOutPoint.Hash.String())
+		if err != nil || int(txIn.PreviousOutPoint.Index) >= len(prev.Outputs) {
+			continue
+		}
+		addrs, err := prev.Outputs[txIn.PreviousOutPoint.Index].Addresses(client.NetworkParams())
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			addresses[addr] = struct{}{}
+		}
+	}
+	for _, txOut := range msgTx.TxOut {
+		addrs, err := Output{Script: hex.EncodeToString(txOut.PkScript)}.Addresses(client.NetworkParams())
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			addresses[addr] = struct{}{}
+		}
+	}
+	for address := range addresses {
+		if err := client.InvalidateAddress(address); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (client *cachedClient) InvalidateAddress(address string) error {
+	return client.store.Delete(unspentCacheKey(address))
+}
+
+func (client *cachedClient) InvalidateTransaction(txhash string) error {
+	return client.store.Delete(transactionCacheKey(txhash))
+}
+
+// load returns the entry stored against the key, and false if there is no
+// entry or it has expired.
+func (client *cachedClient) load(key string) (cacheEntry, bool, error) {
+	entry := cacheEntry{}
+	data, ok, err := client.store.Get(key)
+	if err != nil || !ok {
+		return entry, false, err
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, false, err
+	}
+	if time.Since(entry.Time) > client.ttl {
+		return entry, false, nil
+	}
+	return entry, true, nil
+}
+
+func (client *cachedClient) save(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	entryBytes, err := json.Marshal(cacheEntry{
+		Time: time.Now(),
+		Data: data,
+	})
+	if err != nil {
+		return err
+	}
+	return client.store.Set(key, entryBytes)
+}
+
+func unspentCacheKey(address string) string {
+	return "unspent/" + address
+}
+
+func transactionCacheKey(txhash string) string {
+	return "rawtx/" + txhash
+}
@@ -0,0 +1,90 @@
+package libbtc
+
+import (
+	"sync"
+)
+
+// ChangeOutput is the value of change sent to an address.
+type ChangeOutput struct {
+	Address string
+	Value   int64
+}
+
+// ChangeAddressSelector decides where the change of a transaction is sent.
+type ChangeAddressSelector interface {
+	// SelectChange should split the change across one or more addresses.
+	// The values of the outputs must add up to the change.
+	SelectChange(change int64) ([]ChangeOutput, error)
+}
+
+type roundRobinChange struct {
+	mu        *sync.Mutex
+	addresses []string
+	next      int
+}
+
+// RoundRobinChange returns a ChangeAddressSelector that sends all of the
+// change of each transaction to one of the addresses, cycling through the
+// addresses from one transaction to the next.
+func RoundRobinChange(addresses ...string) ChangeAddressSelector {
+	return &roundRobinChange{
+		mu:        new(sync.Mutex),
+		addresses: addresses,
+	}
+}
+
+func (selector *roundRobinChange) SelectChange(change int64) ([]ChangeOutput, error) {
+	if len(selector.addresses) == 0 {
+		return nil, ErrNoChangeAddresses
+	}
+	selector.mu.Lock()
+	defer selector.mu.Unlock()
+	address := selector.addresses[selector.next]
+	selector.next = (selector.next + 1) % len(selector.addresses)
+	return []ChangeOutput{{Address: address, Value: change}}, nil
+}
+
+type weightedChange struct {
+	addresses []string
+	weights   []int64
+}
+
+// WeightedChange returns a ChangeAddressSelector that splits the change of
+// each transaction across all of the addresses in proportion to their
+// weights. Any remainder from the division is sent to the first address.
+func WeightedChange(addresses []string, weights []int64) ChangeAddressSelector {
+	return &weightedChange{
+		addresses: addresses,
+		weights:   weights,
+	}
+}
+
+func (selector *weightedChange) SelectChange(change int64) ([]ChangeOutput, error) {
+	if len(selector.addresses) == 0 || len(selector.addresses) != len(selector.weights) {
+		return nil, ErrNoChangeAddresses
+	}
+	var total int64
+	for _, weight := range selector.weights {
+		total = total + weight
+	}
+	if total <= 0 {
+		return nil, ErrNoChangeAddresses
+	}
+	outputs := make([]ChangeOutput, 0, len(selector.addresses))
+	remainder := change
+	for i, address := range selector.addresses {
+		value := change * selector.weights[i] / total
+		remainder = remainder - value
+		outputs = append(outputs, ChangeOutput{Address: address, Value: value})
+	}
+	outputs[0].Value = outputs[0].Value + remainder
+
+	// Drop the addresses that received nothing.
+	nonEmpty := outputs[:0]
+	for _, output := range outputs {
+		if output.Value > 0 {
+			nonEmpty = append(nonEmpty, output)
+		}
+	}
+	return nonEmpty, nil
+}
@@ -4,33 +4,59 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"golang.org/x/time/rate"
 )
 
 type PreviousOut struct {
 	TransactionHash  string `json:"hash"`
 	Value            uint64 `json:"value"`
 	TransactionIndex uint64 `json:"tx_index"`
-	VoutNumber       uint8  `json:"n"`
+	VoutNumber       uint32 `json:"n"`
 	Address          string `json:"addr"`
 }
 
 type Input struct {
-	PrevOut PreviousOut `json:"prev_out"`
-	Script  string      `json:"script"`
+	PrevOut  PreviousOut `json:"prev_out"`
+	Script   string      `json:"script"`
+	Sequence uint32      `json:"sequence"`
 }
 
 type Output struct {
 	Value           uint64 `json:"value"`
 	TransactionHash string `json:"hash"`
 	Script          string `json:"script"`
+	Address         string `json:"addr"`
+}
+
+// Addresses decodes the script of the output and returns the addresses it
+// pays to on the given network.
+func (output Output) Addresses(params *chaincfg.Params) ([]string, error) {
+	script, err := hex.DecodeString(output.Script)
+	if err != nil {
+		return nil, err
+	}
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(script, params)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]string, len(addrs))
+	for i, addr := range addrs {
+		addresses[i] = addr.EncodeAddress()
+	}
+	return addresses, nil
 }
 
 type Transaction struct {
@@ -64,8 +90,35 @@ type Block struct {
 	Transactions      []Transaction `json:"tx"`
 }
 
+// TxStatus is the status of a transaction, as returned by
+// GetTransactionStatus. BlockHeight is zero unless the transaction is
+// confirmed.
+type TxStatus struct {
+	Found         bool
+	Confirmations int64
+	BlockHeight   int64
+	InMempool     bool
+}
+
 type Blocks struct {
-	Blocks []Block `json:"block"`
+	Blocks []Block `json:"blocks"`
+}
+
+// BlockHeader is the subset of a Block that makes up its header.
+type BlockHeader struct {
+	BlockHash         string `json:"hash"`
+	Version           uint8  `json:"ver"`
+	PreviousBlockHash string `json:"prev_block"`
+	MerkleRoot        string `json:"mrkl_root"`
+	Time              int64  `json:"time"`
+	Bits              int64  `json:"bits"`
+	Nonce             int64  `json:"nonce"`
+	Height            int64  `json:"height"`
+	MainChain         bool   `json:"main_chain"`
+}
+
+type blockHeaders struct {
+	Blocks []BlockHeader `json:"blocks"`
 }
 
 type SingleAddress struct {
@@ -100,6 +153,7 @@ type UnspentOutput struct {
 	TransactionOutputNumber uint32 `json:"tx_output_n"`
 	ScriptPubKey            string `json:"script"`
 	Amount                  int64  `json:"value"`
+	Confirmations           int64  `json:"confirmations"`
 }
 
 type Unspent struct {
@@ -113,9 +167,63 @@ type LatestBlock struct {
 	Height     int64  `json:"height"`
 }
 
+const (
+	// spentPollInterval is the interval at which clients poll for an
+	// address to be spent from.
+	spentPollInterval = 10 * time.Second
+
+	// confirmationPollInterval is the interval at which clients poll for the
+	// confirmations of a transaction.
+	confirmationPollInterval = 30 * time.Second
+
+	// blockHeightCacheTTL is the time for which clients remember the height
+	// of the latest block.
+	blockHeightCacheTTL = 5 * time.Second
+)
+
+// addressTxPageSize is the maximum number of transactions of an address
+// returned by a single request to blockchain.info.
+const addressTxPageSize = 50
+
+// unspentPageSize is the maximum number of unspent outputs returned by a
+// single request to blockchain.info.
+const unspentPageSize = 1000
+
 type client struct {
-	URL    string
-	Params *chaincfg.Params
+	URL         string
+	Params      *chaincfg.Params
+	feeURL      string
+	httpClient  *http.Client
+	limiter     *rate.Limiter
+	retryPolicy RetryPolicy
+	budget      RetryBudget
+	logger      Logger
+	height      *heightCache
+}
+
+// DefaultHTTPTimeout bounds the requests of clients that are not given an
+// HTTP client using WithHTTPClient.
+const DefaultHTTPTimeout = 30 * time.Second
+
+// WithHTTPClient makes the requests of the client using the given HTTP
+// client, which can be used to configure timeouts, proxies and TLS. By
+// default, requests are made by an HTTP client with the DefaultHTTPTimeout.
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(client *client) {
+		client.httpClient = httpClient
+	}
+}
+
+// ClientOption configures a Client when it is constructed.
+type ClientOption func(*client)
+
+// RateLimit limits the requests made by the client to rps requests per
+// second, with bursts of up to burst requests. The limit is shared by all
+// goroutines using the client.
+func RateLimit(rps float64, burst int) ClientOption {
+	return func(client *client) {
+		client.limiter = rate.NewLimiter(rate.Limit(rps), burst)
+	}
 }
 
 type Client interface {
@@ -123,9 +231,21 @@ type Client interface {
 	// Bitcoin blockchain.
 	NetworkParams() *chaincfg.Params
 	GetUnspentOutputs(ctx context.Context, address string, limit, confitmations int64) (Unspent, error)
+
+	// GetUnspentOutputsPage returns up to limit unspent outputs of the
+	// address, skipping the first offset unspent outputs.
+	GetUnspentOutputsPage(ctx context.Context, address string, offset, limit, confirmations int64) (Unspent, error)
 	GetRawTransaction(ctx context.Context, txhash string) (Transaction, error)
+
+	// GetSerializedTransaction returns the transaction with the given hash,
+	// serialized as it was published.
+	GetSerializedTransaction(ctx context.Context, txhash string) ([]byte, error)
 	GetRawAddressInformation(ctx context.Context, addr string) (SingleAddress, error)
 
+	// GetAddressTransactions returns up to limit transactions of the address,
+	// newest first, skipping the first offset transactions.
+	GetAddressTransactions(ctx context.Context, addr string, offset, limit int) ([]Transaction, error)
+
 	// PublishTransaction should publish a signed transaction to the Bitcoin
 	// blockchain.
 	PublishTransaction(ctx context.Context, signedTransaction []byte) error
@@ -133,182 +253,644 @@ type Client interface {
 	// Balance of the given address on Bitcoin blockchain.
 	Balance(ctx context.Context, address string, confirmations int64) (int64, error)
 
+	// BalanceDetails returns the value of the unspent outputs of the address
+	// that have at least one confirmation, and the value of those that do
+	// not, including outputs that are still in the mempool.
+	BalanceDetails(ctx context.Context, address string) (confirmed, unconfirmed int64, err error)
+
 	// ScriptSpent checks whether a script is spent.
 	ScriptSpent(ctx context.Context, address string) (bool, error)
 
+	// HasBeenUsed checks whether an address has any transaction history.
+	HasBeenUsed(ctx context.Context, address string) (bool, error)
+
+	// BalanceDelta returns the net change in the balance of an address
+	// (received minus sent) from its confirmed transactions in blocks
+	// fromHeight to toHeight (inclusive).
+	BalanceDelta(ctx context.Context, address string, fromHeight, toHeight int64) (int64, error)
+
 	// ScriptFunded checks whether a script is funded.
 	ScriptFunded(ctx context.Context, address string, value int64) (bool, int64, error)
 
+	// ScriptRedeemed checks whether a script has received at least value
+	// and has since been spent, returning the value still held by it.
 	ScriptRedeemed(ctx context.Context, address string, value int64) (bool, int64, error)
 
 	GetScriptFromSpentP2SH(ctx context.Context, address string) ([]byte, error)
 
 	Confirmations(ctx context.Context, txHash string) (int64, error)
 
+	// GetTransactionStatus returns whether the transaction is known, and if
+	// so, whether it is confirmed. A transaction that is not found is not an
+	// error.
+	GetTransactionStatus(ctx context.Context, txHash string) (TxStatus, error)
+
+	// WaitForConfirmations blocks until the transaction has at least n
+	// confirmations, or the context is done.
+	WaitForConfirmations(ctx context.Context, txHash string, n int64) error
+
+	// GetBlockHeight returns the height of the latest block.
+	GetBlockHeight(ctx context.Context) (int64, error)
+
+	// EstimateFeeRate returns the fee rate (in SAT per byte) needed for a
+	// transaction to confirm within targetBlocks blocks.
+	EstimateFeeRate(ctx context.Context, targetBlocks int) (int64, error)
+
+	// GetBlockHeader returns the header of the block with the given hash.
+	GetBlockHeader(ctx context.Context, hash string) (BlockHeader, error)
+
+	// GetBlockHeaderByHeight returns the header of the block at the given
+	// height on the main chain.
+	GetBlockHeaderByHeight(ctx context.Context, height int64) (BlockHeader, error)
+
 	// FormatTransactionView formats the message and txhash into a user friendly
 	// message.
 	FormatTransactionView(msg, txhash string) string
 }
 
-func NewBlockchainInfoClient(network string) Client {
+// NewBlockchainInfoClient returns a Client that talks to the blockchain.info
+// API of the network ("mainnet", "testnet" or "regtest"). It panics if the
+// network is not supported.
+func NewBlockchainInfoClient(network string, opts ...ClientOption) Client {
+	client, err := NewBlockchainInfoClientWithError(network, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// NewBlockchainInfoClientWithError is like NewBlockchainInfoClient, but
+// returns an error instead of panicking if the network is not supported.
+func NewBlockchainInfoClientWithError(network string, opts ...ClientOption) (Client, error) {
+	var c *client
 	network = strings.ToLower(network)
 	switch network {
 	case "mainnet":
-		return &client{
+		c = &client{
 			URL:    "https://blockchain.info",
 			Params: &chaincfg.MainNetParams,
+			feeURL: "https://mempool.space/api",
 		}
 	case "testnet", "testnet3", "":
-		return &client{
+		c = &client{
 			URL:    "https://testnet.blockchain.info",
 			Params: &chaincfg.TestNet3Params,
+			feeURL: "https://mempool.space/testnet/api",
+		}
+	case "regtest":
+		// There is no public blockchain.info API for regtest, so the URL
+		// of a compatible local API is expected to be given using WithURL.
+		c = &client{
+			URL:    "http://localhost:3000",
+			Params: &chaincfg.RegressionNetParams,
 		}
 	default:
-		panic(NewErrUnsupportedNetwork(network))
+		return nil, NewErrUnsupportedNetwork(network)
+	}
+	c.height = new(heightCache)
+	c.httpClient = &http.Client{Timeout: DefaultHTTPTimeout}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// NewClientWithParams returns a Client that talks to a blockchain.info
+// compatible API at the url, which must not have a trailing slash, for the
+// network described by the params. This allows the package to be used with
+// networks other than those of Bitcoin, such as Litecoin, whose params are
+// not known to chaincfg. There is no default fee estimate for such networks,
+// so WithFeeURL must be used to estimate fee rates.
+func NewClientWithParams(url string, params *chaincfg.Params, opts ...ClientOption) Client {
+	registerParams(params)
+	c := &client{
+		URL:        url,
+		Params:     params,
+		height:     new(heightCache),
+		httpClient: &http.Client{Timeout: DefaultHTTPTimeout},
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// registerParams registers the params with chaincfg, which is needed to
+// decode the base58 addresses of networks other than those of Bitcoin. It
+// does nothing if a network with the same magic is already registered.
+func registerParams(params *chaincfg.Params) {
+	_ = chaincfg.Register(params)
 }
 
+// GetUnspentOutputs returns up to limit unspent outputs of the address, or
+// all of them if limit is 0. blockchain.info returns at most unspentPageSize
+// unspent outputs at once, so they are fetched page by page.
 func (client *client) GetUnspentOutputs(ctx context.Context, address string, limit, confitmations int64) (Unspent, error) {
+	utxos := Unspent{}
+	err := iterateUnspent(ctx, client, address, confitmations, func(page []UnspentOutput) bool {
+		utxos.Outputs = append(utxos.Outputs, page...)
+		return limit <= 0 || int64(len(utxos.Outputs)) < limit
+	})
+	if limit > 0 && int64(len(utxos.Outputs)) > limit {
+		utxos.Outputs = utxos.Outputs[:limit]
+	}
+	return utxos, err
+}
+
+// iterateUnspent fetches the unspent outputs of the address page by page, and
+// calls f with each page until f returns false or there are no more pages.
+func iterateUnspent(ctx context.Context, client Client, address string, confirmations int64, f func([]UnspentOutput) bool) error {
+	for offset := int64(0); ; offset += unspentPageSize {
+		utxos, err := client.GetUnspentOutputsPage(ctx, address, offset, unspentPageSize, confirmations)
+		if err != nil {
+			return err
+		}
+		if !f(utxos.Outputs) || len(utxos.Outputs) < unspentPageSize {
+			return nil
+		}
+	}
+}
+
+func (client *client) GetUnspentOutputsPage(ctx context.Context, address string, offset, limit, confirmations int64) (Unspent, error) {
 	if limit == 0 {
 		limit = 250
 	}
 	utxos := Unspent{}
-	err := backoff(ctx, func() error {
-		resp, err := http.Get(fmt.Sprintf("%s/unspent?active=%s&confirmations=%d&limit=%d", client.URL, address, confitmations, limit))
+	err := client.backoff(ctx, func() (*http.Response, error) {
+		resp, err := client.get(ctx, fmt.Sprintf("%s/unspent?active=%s&confirmations=%d&limit=%d&offset=%d", client.URL, address, confirmations, limit, offset))
 		if err != nil {
-			return err
+			return nil, err
 		}
 		defer resp.Body.Close()
 
-		respBytes, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
+		respBytes, err := readBody(resp)
+		// blockchain.info responds with an error status when the address
+		// has no unspent outputs.
 		if string(respBytes) == "No free outputs to spend" {
-			return nil
+			return resp, nil
+		}
+		if err != nil {
+			return resp, err
 		}
-		return json.Unmarshal(respBytes, &utxos)
+		return resp, json.Unmarshal(respBytes, &utxos)
 	})
-	return utxos, err
+	if err != nil {
+		return utxos, err
+	}
+	// Filter the unspent outputs again, so that the confirmations behave the
+	// same as on the other backends even if the API ignores them.
+	filtered := utxos.Outputs[:0]
+	for _, utxo := range utxos.Outputs {
+		if utxo.Confirmations >= confirmations {
+			filtered = append(filtered, utxo)
+		}
+	}
+	utxos.Outputs = filtered
+	return utxos, nil
+}
+
+// confirmationsAt returns the confirmations of an output in the block at the
+// given height, when the latest block is at the tip height. Unconfirmed
+// outputs have no confirmations, and confirmed outputs have at least one even
+// if the tip height is stale.
+func confirmationsAt(confirmed bool, height, tip int64) int64 {
+	if !confirmed {
+		return 0
+	}
+	if tip < height {
+		return 1
+	}
+	return tip - height + 1
 }
 
 func (client *client) GetRawTransaction(ctx context.Context, txhash string) (Transaction, error) {
 	transaction := Transaction{}
-	err := backoff(ctx, func() error {
-		resp, err := http.Get(fmt.Sprintf("%s/rawtx/%s", client.URL, txhash))
+	err := client.backoff(ctx, func() (*http.Response, error) {
+		resp, err := client.get(ctx, fmt.Sprintf("%s/rawtx/%s", client.URL, txhash))
 		if err != nil {
-			return err
+			return nil, err
 		}
 		defer resp.Body.Close()
-		txBytes, err := ioutil.ReadAll(resp.Body)
-		return json.Unmarshal(txBytes, &transaction)
+		txBytes, err := readBody(resp)
+		if err != nil {
+			return resp, err
+		}
+		return resp, json.Unmarshal(txBytes, &transaction)
 	})
 	return transaction, err
 }
 
+func (client *client) GetSerializedTransaction(ctx context.Context, txhash string) ([]byte, error) {
+	var stx []byte
+	err := client.backoff(ctx, func() (*http.Response, error) {
+		resp, err := client.get(ctx, fmt.Sprintf("%s/rawtx/%s?format=hex", client.URL, txhash))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		txBytes, err := readBody(resp)
+		if err != nil {
+			return resp, err
+		}
+		stx, err = hex.DecodeString(strings.TrimSpace(string(txBytes)))
+		return resp, err
+	})
+	return stx, err
+}
+
 func (client *client) Confirmations(ctx context.Context, txhash string) (int64, error) {
 	tx, err := client.GetRawTransaction(ctx, txhash)
 	if err != nil {
 		return 0, err
 	}
 	if tx.BlockHeight != 0 {
-		latest, err := client.LatestBlock(ctx)
+		height, err := client.GetBlockHeight(ctx)
 		if err != nil {
 			return 0, err
 		}
-		return 1 + (latest.Height - tx.BlockHeight), nil
+		return 1 + (height - tx.BlockHeight), nil
 	}
 	return 0, nil
 }
 
+func (client *client) GetTransactionStatus(ctx context.Context, txhash string) (TxStatus, error) {
+	return transactionStatus(ctx, client, txhash)
+}
+
+// transactionStatus gets the transaction and the latest block height from the
+// client. A transaction with no block height is assumed to be in the mempool.
+func transactionStatus(ctx context.Context, client Client, txhash string) (TxStatus, error) {
+	tx, err := client.GetRawTransaction(ctx, txhash)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return TxStatus{}, nil
+		}
+		return TxStatus{}, err
+	}
+	if tx.BlockHeight == 0 {
+		return TxStatus{Found: true, InMempool: true}, nil
+	}
+	height, err := client.GetBlockHeight(ctx)
+	if err != nil {
+		return TxStatus{}, err
+	}
+	return TxStatus{
+		Found:         true,
+		Confirmations: 1 + (height - tx.BlockHeight),
+		BlockHeight:   tx.BlockHeight,
+	}, nil
+}
+
+func (client *client) WaitForConfirmations(ctx context.Context, txhash string, n int64) error {
+	return waitForConfirmations(ctx, client, txhash, n)
+}
+
+// waitForConfirmations blocks until the transaction has at least n
+// confirmations, or the context is done.
+func waitForConfirmations(ctx context.Context, client Client, txhash string, n int64) error {
+	for {
+		confirmations, err := client.Confirmations(ctx, txhash)
+		if err != nil {
+			return err
+		}
+		if confirmations >= n {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ErrTimedOut
+		case <-time.After(confirmationPollInterval):
+		}
+	}
+}
+
+func (client *client) GetBlockHeight(ctx context.Context) (int64, error) {
+	return client.height.get(func() (int64, error) {
+		latestBlock, err := client.LatestBlock(ctx)
+		if err != nil {
+			return 0, err
+		}
+		return latestBlock.Height, nil
+	})
+}
+
+// heightCache remembers the height of the latest block for a short time, so
+// that many callers asking for it at once do not all query the backend.
+type heightCache struct {
+	mu        sync.Mutex
+	height    int64
+	fetchedAt time.Time
+}
+
+// get returns the cached height, or the height returned by fetch if the
+// cached height is stale.
+func (cache *heightCache) get(fetch func() (int64, error)) (int64, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if time.Since(cache.fetchedAt) < blockHeightCacheTTL {
+		return cache.height, nil
+	}
+	height, err := fetch()
+	if err != nil {
+		return 0, err
+	}
+	cache.height = height
+	cache.fetchedAt = time.Now()
+	return height, nil
+}
+
 func (client *client) GetRawAddressInformation(ctx context.Context, addr string) (SingleAddress, error) {
+	return client.rawAddressPage(ctx, addr, 0)
+}
+
+// GetAddressTransactions returns up to limit transactions of the address,
+// newest first, skipping the first offset transactions. blockchain.info
+// returns at most addressTxPageSize transactions at once, which is also the
+// default limit.
+func (client *client) GetAddressTransactions(ctx context.Context, addr string, offset, limit int) ([]Transaction, error) {
+	if limit <= 0 || limit > addressTxPageSize {
+		limit = addressTxPageSize
+	}
 	addressInfo := SingleAddress{}
-	err := backoff(ctx, func() error {
-		resp, err := http.Get(fmt.Sprintf("%s/rawaddr/%s", client.URL, addr))
+	err := client.backoff(ctx, func() (*http.Response, error) {
+		resp, err := client.get(ctx, fmt.Sprintf("%s/rawaddr/%s?offset=%d&limit=%d", client.URL, addr, offset, limit))
 		if err != nil {
-			return err
+			return nil, err
+		}
+		defer resp.Body.Close()
+		addrBytes, err := readBody(resp)
+		if err != nil {
+			return resp, err
+		}
+		return resp, json.Unmarshal(addrBytes, &addressInfo)
+	})
+	return addressInfo.Transactions, err
+}
+
+// rawAddressPage returns the information of the address with its
+// transactions, newest first, skipping the first offset transactions.
+func (client *client) rawAddressPage(ctx context.Context, addr string, offset int64) (SingleAddress, error) {
+	addressInfo := SingleAddress{}
+	err := client.backoff(ctx, func() (*http.Response, error) {
+		resp, err := client.get(ctx, fmt.Sprintf("%s/rawaddr/%s?offset=%d", client.URL, addr, offset))
+		if err != nil {
+			return nil, err
 		}
 		defer resp.Body.Close()
-		addrBytes, err := ioutil.ReadAll(resp.Body)
-		return json.Unmarshal(addrBytes, &addressInfo)
+		addrBytes, err := readBody(resp)
+		if err != nil {
+			return resp, err
+		}
+		return resp, json.Unmarshal(addrBytes, &addressInfo)
 	})
 	return addressInfo, err
 }
 
 func (client *client) LatestBlock(ctx context.Context) (LatestBlock, error) {
 	latestBlock := LatestBlock{}
-	err := backoff(ctx, func() error {
-		resp, err := http.Get(fmt.Sprintf("%s/latestblock", client.URL))
+	err := client.backoff(ctx, func() (*http.Response, error) {
+		resp, err := client.get(ctx, fmt.Sprintf("%s/latestblock", client.URL))
 		if err != nil {
-			return err
+			return nil, err
 		}
 		defer resp.Body.Close()
-		latestBlockBytes, err := ioutil.ReadAll(resp.Body)
-		return json.Unmarshal(latestBlockBytes, &latestBlock)
+		latestBlockBytes, err := readBody(resp)
+		if err != nil {
+			return resp, err
+		}
+		return resp, json.Unmarshal(latestBlockBytes, &latestBlock)
 	})
 	return latestBlock, err
 }
 
+// GetBlockHeader returns the header of the block with the given hash.
+// blockchain.info does not serve headers on their own, so the block is
+// fetched and only its header fields are decoded.
+func (client *client) GetBlockHeader(ctx context.Context, hash string) (BlockHeader, error) {
+	header := BlockHeader{}
+	err := client.backoff(ctx, func() (*http.Response, error) {
+		resp, err := client.get(ctx, fmt.Sprintf("%s/rawblock/%s", client.URL, hash))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		headerBytes, err := readBody(resp)
+		if err != nil {
+			return resp, err
+		}
+		return resp, json.Unmarshal(headerBytes, &header)
+	})
+	return header, err
+}
+
+// GetBlockHeaderByHeight returns the header of the block at the given height
+// on the main chain.
+func (client *client) GetBlockHeaderByHeight(ctx context.Context, height int64) (BlockHeader, error) {
+	headers := blockHeaders{}
+	err := client.backoff(ctx, func() (*http.Response, error) {
+		resp, err := client.get(ctx, fmt.Sprintf("%s/block-height/%d?format=json", client.URL, height))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		headersBytes, err := readBody(resp)
+		if err != nil {
+			return resp, err
+		}
+		return resp, json.Unmarshal(headersBytes, &headers)
+	})
+	if err != nil {
+		return BlockHeader{}, err
+	}
+	for _, header := range headers.Blocks {
+		if header.MainChain {
+			return header, nil
+		}
+	}
+	return BlockHeader{}, NewErrBlockNotFound(fmt.Sprintf("%d", height))
+}
+
 func (client *client) PublishTransaction(ctx context.Context, signedTransaction []byte) error {
 	data := url.Values{}
 	data.Set("tx", hex.EncodeToString(signedTransaction))
-	err := backoff(ctx, func() error {
-		httpClient := &http.Client{}
+	err := client.backoff(ctx, func() (*http.Response, error) {
 		r, err := http.NewRequest("POST", fmt.Sprintf("%s/pushtx", client.URL), strings.NewReader(data.Encode())) // URL-encoded payload
 		if err != nil {
-			return err
+			return nil, err
 		}
 		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-		resp, err := httpClient.Do(r)
+		resp, err := client.httpClient.Do(r.WithContext(ctx))
 		if err != nil {
-			return err
+			return nil, err
 		}
 		defer resp.Body.Close()
 		stxResultBytes, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			return err
+			return resp, err
 		}
 		stxResult := string(stxResultBytes)
 		if !strings.Contains(stxResult, "Transaction Submitted") {
-			return NewErrBitcoinSubmitTx(stxResult)
+			return resp, NewErrBitcoinSubmitTx(stxResult)
 		}
-		return nil
+		return resp, nil
 	})
 	return err
 }
 
 func (client *client) GetScriptFromSpentP2SH(ctx context.Context, address string) ([]byte, error) {
-	for {
-		addrInfo, err := client.GetRawAddressInformation(ctx, address)
+	if err := waitForSpend(ctx, client, address); err != nil {
+		return nil, err
+	}
+	return spendingScript(ctx, client, address)
+}
+
+// spendingScript returns the signature script of the newest input spending
+// from the address, looking through every page of its transactions.
+func spendingScript(ctx context.Context, client Client, address string) ([]byte, error) {
+	for offset := 0; ; offset += addressTxPageSize {
+		txs, err := client.GetAddressTransactions(ctx, address, offset, addressTxPageSize)
 		if err != nil {
 			return nil, err
 		}
-		if addrInfo.Sent > 0 {
-			break
+		for _, tx := range txs {
+			for _, input := range tx.Inputs {
+				if input.PrevOut.Address == address {
+					return hex.DecodeString(input.Script)
+				}
+			}
+		}
+		if len(txs) < addressTxPageSize {
+			return nil, ErrNoSpendingTransactions
 		}
 	}
-	addrInfo, err := client.GetRawAddressInformation(ctx, address)
-	if err != nil {
-		return nil, err
+}
+
+// pageTransactions returns up to limit of the transactions, skipping the first
+// offset transactions. A limit of 0 returns all remaining transactions.
+func pageTransactions(txs []Transaction, offset, limit int) []Transaction {
+	if offset >= len(txs) {
+		return []Transaction{}
 	}
-	for _, tx := range addrInfo.Transactions {
-		for i := range tx.Inputs {
-			if tx.Inputs[i].PrevOut.Address == addrInfo.Address {
-				return hex.DecodeString(tx.Inputs[i].Script)
-			}
+	txs = txs[offset:]
+	if limit > 0 && limit < len(txs) {
+		txs = txs[:limit]
+	}
+	return txs
+}
+
+// waitForSpend blocks until the address has been spent from, or the context
+// is done.
+func waitForSpend(ctx context.Context, client Client, address string) error {
+	for {
+		spent, err := client.ScriptSpent(ctx, address)
+		if err != nil {
+			return err
+		}
+		if spent {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ErrTimedOut
+		case <-time.After(spentPollInterval):
 		}
 	}
-	return nil, ErrNoSpendingTransactions
 }
 
 func (client *client) Balance(ctx context.Context, address string, confirmations int64) (balance int64, err error) {
-	unspent, err := client.GetUnspentOutputs(ctx, address, 1000, confirmations)
+	unspent, err := client.GetUnspentOutputs(ctx, address, 0, confirmations)
 	for _, utxo := range unspent.Outputs {
 		balance = balance + utxo.Amount
 	}
 	return
 }
 
+func (client *client) BalanceDetails(ctx context.Context, address string) (int64, int64, error) {
+	return balanceDetails(ctx, client, address)
+}
+
+// balanceDetails sums the unspent outputs of the address, with and without
+// at least one confirmation.
+func balanceDetails(ctx context.Context, client Client, address string) (confirmed, unconfirmed int64, err error) {
+	err = iterateUnspent(ctx, client, address, 0, func(utxos []UnspentOutput) bool {
+		for _, utxo := range utxos {
+			if utxo.Confirmations > 0 {
+				confirmed = confirmed + utxo.Amount
+			} else {
+				unconfirmed = unconfirmed + utxo.Amount
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return confirmed, unconfirmed, nil
+}
+
+func (client *client) HasBeenUsed(ctx context.Context, address string) (bool, error) {
+	rawAddress, err := client.GetRawAddressInformation(ctx, address)
+	if err != nil {
+		return false, err
+	}
+	return rawAddress.TransactionCount > 0, nil
+}
+
+func (client *client) BalanceDelta(ctx context.Context, address string, fromHeight, toHeight int64) (int64, error) {
+	delta := int64(0)
+	offset := int64(0)
+	for {
+		rawAddress, err := client.rawAddressPage(ctx, address, offset)
+		if err != nil {
+			return 0, err
+		}
+		for _, tx := range rawAddress.Transactions {
+			// Unconfirmed transactions have no block height.
+			if tx.BlockHeight == 0 || tx.BlockHeight > toHeight {
+				continue
+			}
+			// Transactions are ordered newest first, so there are no more
+			// transactions in range.
+			if tx.BlockHeight < fromHeight {
+				return delta, nil
+			}
+			txDelta, err := transactionDelta(tx, address, client.Params)
+			if err != nil {
+				return 0, err
+			}
+			delta = delta + txDelta
+		}
+		offset = offset + int64(len(rawAddress.Transactions))
+		if len(rawAddress.Transactions) == 0 || offset >= rawAddress.TransactionCount {
+			return delta, nil
+		}
+	}
+}
+
+// transactionDelta returns the value the transaction pays to the address,
+// minus the value it spends from the address.
+func transactionDelta(tx Transaction, address string, params *chaincfg.Params) (int64, error) {
+	delta := int64(0)
+	for _, input := range tx.Inputs {
+		if input.PrevOut.Address == address {
+			delta = delta - int64(input.PrevOut.Value)
+		}
+	}
+	for _, output := range tx.Outputs {
+		addrs, err := output.Addresses(params)
+		if err != nil {
+			return 0, err
+		}
+		for _, addr := range addrs {
+			if addr == address {
+				delta = delta + int64(output.Value)
+				break
+			}
+		}
+	}
+	return delta, nil
+}
+
 func (client *client) ScriptSpent(ctx context.Context, address string) (bool, error) {
 	rawAddress, err := client.GetRawAddressInformation(ctx, address)
 	if err != nil {
@@ -338,30 +920,221 @@ func (client *client) NetworkParams() *chaincfg.Params {
 }
 
 func (client *client) FormatTransactionView(msg, txhash string) string {
-	switch client.NetworkParams().Name {
-	case "mainnet":
+	return formatTransactionView(client.NetworkParams(), msg, txhash)
+}
+
+// formatTransactionView links to a public explorer for the Bitcoin mainnet and
+// testnet. Networks are told apart by their magic, since forks of Bitcoin
+// often reuse its network names.
+func formatTransactionView(params *chaincfg.Params, msg, txhash string) string {
+	switch params.Net {
+	case chaincfg.MainNetParams.Net:
 		return fmt.Sprintf("%s, transaction can be viewed at https://live.blockcypher.com/btc/tx/%s", msg, txhash)
-	case "testnet3":
+	case chaincfg.TestNet3Params.Net:
 		return fmt.Sprintf("%s, transaction can be viewed at https://live.blockcypher.com/btc-testnet/tx/%s", msg, txhash)
 	default:
-		panic(NewErrUnsupportedNetwork(client.NetworkParams().Name))
+		// Transactions on other networks, such as regtest, cannot be viewed
+		// on a public explorer.
+		return fmt.Sprintf("%s, transaction %s", msg, txhash)
 	}
 }
 
-func backoff(ctx context.Context, f func() error) error {
-	duration := time.Duration(1000)
-	for {
+// wait blocks until the rate limit of the client allows another request.
+func (client *client) wait(ctx context.Context) error {
+	if client.limiter == nil {
+		return nil
+	}
+	return client.limiter.Wait(ctx)
+}
+
+// RetryPolicy decides whether a failed request should be retried, and how
+// long to wait before retrying. attempt is the number of attempts that have
+// already failed, starting at zero, and resp is the response to the failed
+// request, which is nil if no response was received.
+type RetryPolicy func(attempt int, err error, resp *http.Response) (bool, time.Duration)
+
+// DefaultRetryPolicy retries every failed request, using the
+// DefaultBackoffConfig.
+func DefaultRetryPolicy(attempt int, err error, resp *http.Response) (bool, time.Duration) {
+	return DefaultBackoffConfig.RetryPolicy()(attempt, err, resp)
+}
+
+// BackoffConfig configures the exponential backoff between retries of failed
+// requests.
+type BackoffConfig struct {
+	// InitialDelay is the delay before the first retry.
+	InitialDelay time.Duration
+
+	// Multiplier is the factor by which the delay grows after each retry.
+	Multiplier float64
+
+	// MaxDelay caps the delay between retries.
+	MaxDelay time.Duration
+
+	// Jitter is the fraction by which each delay is randomly increased or
+	// decreased, so that concurrent callers do not retry in lockstep.
+	Jitter float64
+
+	// MaxRetries is the number of retries after which a request fails. Zero
+	// means that requests are retried until the context is done.
+	MaxRetries int
+}
+
+// DefaultBackoffConfig waits one second before the first retry and 60% longer
+// before each retry after that, up to 30 seconds, with 20% jitter.
+var DefaultBackoffConfig = BackoffConfig{
+	InitialDelay: time.Second,
+	Multiplier:   1.6,
+	MaxDelay:     30 * time.Second,
+	Jitter:       0.2,
+}
+
+// RetryPolicy returns the RetryPolicy that retries failed requests with the
+// backoff described by the config. Requests that fail with a 4xx status,
+// other than 429 (Too Many Requests), are not retried.
+func (config BackoffConfig) RetryPolicy() RetryPolicy {
+	return func(attempt int, err error, resp *http.Response) (bool, time.Duration) {
+		if config.MaxRetries > 0 && attempt >= config.MaxRetries {
+			return false, 0
+		}
+		// Client errors will fail again, unless the client is being rate
+		// limited.
+		if resp != nil && resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return false, 0
+		}
+		delay := float64(config.InitialDelay) * math.Pow(config.Multiplier, float64(attempt))
+		if config.MaxDelay > 0 && delay > float64(config.MaxDelay) {
+			delay = float64(config.MaxDelay)
+		}
+		delay = delay * (1 + config.Jitter*(2*rand.Float64()-1))
+		return true, time.Duration(delay)
+	}
+}
+
+// WithBackoffConfig retries failed requests with the backoff described by the
+// config, instead of the DefaultBackoffConfig.
+func WithBackoffConfig(config BackoffConfig) ClientOption {
+	return WithRetryPolicy(config.RetryPolicy())
+}
+
+// RetryBudget bounds the retries of each request made by a client, so that a
+// single failing request gives up before the context of the call is done.
+type RetryBudget struct {
+	// MaxAttempts is the number of attempts after which a request fails.
+	// Zero means no limit.
+	MaxAttempts int
+
+	// MaxDuration is the time after which a request is no longer retried.
+	// Zero means no limit.
+	MaxDuration time.Duration
+}
+
+// exhausted returns whether a request that has failed the given number of
+// attempts since start is out of budget, if it is retried after the delay.
+func (budget RetryBudget) exhausted(attempts int, start time.Time, delay time.Duration) bool {
+	if budget.MaxAttempts > 0 && attempts >= budget.MaxAttempts {
+		return true
+	}
+	return budget.MaxDuration > 0 && time.Since(start)+delay >= budget.MaxDuration
+}
+
+// WithRetryBudget stops retrying each request of the client once the budget
+// is exhausted, returning an error that wraps ErrRetryBudgetExhausted and the
+// error of the last attempt. By default, requests are retried until the
+// retry policy gives up, or the context is done.
+func WithRetryBudget(budget RetryBudget) ClientOption {
+	return func(client *client) {
+		client.budget = budget
+	}
+}
+
+// WithURL sends the requests of a blockchain.info client to the given URL,
+// which must not have a trailing slash. This is needed to talk to a local
+// regtest API.
+func WithURL(url string) ClientOption {
+	return func(client *client) {
+		client.URL = url
+	}
+}
+
+// WithRetryPolicy replaces the DefaultRetryPolicy used by the client.
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return func(client *client) {
+		client.retryPolicy = policy
+	}
+}
+
+// get makes a GET request to the url using the HTTP client of the client.
+func (client *client) get(ctx context.Context, url string) (*http.Response, error) {
+	r, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.httpClient.Do(r.WithContext(ctx))
+}
+
+// readBody reads the body of the response, and returns an error if the
+// response does not have a successful status.
+func readBody(resp *http.Response) ([]byte, error) {
+	respBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return respBytes, NewErrUnexpectedStatus(resp.StatusCode, string(respBytes))
+	}
+	return respBytes, nil
+}
+
+// Logger receives the messages logged by a Client, such as the errors of
+// requests that are retried.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+}
+
+// WithLogger logs the messages of the client to the given Logger. By
+// default, nothing is logged.
+func WithLogger(logger Logger) ClientOption {
+	return func(client *client) {
+		client.logger = logger
+	}
+}
+
+// backoff calls f until it succeeds, the retry policy of the client gives up,
+// the retry budget of the client is exhausted, or the context is done.
+func (client *client) backoff(ctx context.Context, f func() (*http.Response, error)) error {
+	policy := client.retryPolicy
+	if policy == nil {
+		policy = DefaultRetryPolicy
+	}
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
 		select {
 		case <-ctx.Done():
 			return ErrTimedOut
 		default:
-			err := f()
-			if err == nil {
-				return nil
-			}
-			fmt.Printf("Error: %v, will try again in %d sec\n", err, duration)
-			time.Sleep(duration * time.Millisecond)
-			duration = time.Duration(float64(duration) * 1.6)
+		}
+		if err := client.wait(ctx); err != nil {
+			return ErrTimedOut
+		}
+		resp, err := f()
+		if err == nil {
+			return nil
+		}
+		retry, duration := policy(attempt, err, resp)
+		if !retry {
+			return err
+		}
+		if client.budget.exhausted(attempt+1, start, duration) {
+			return NewErrRetryBudgetExhausted(attempt+1, err)
+		}
+		if client.logger != nil {
+			client.logger.Debugf("Error: %v, will try again in %v", err, duration)
+		}
+		select {
+		case <-ctx.Done():
+			return ErrTimedOut
+		case <-time.After(duration):
 		}
 	}
 }
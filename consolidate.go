@@ -0,0 +1,300 @@
+package libbtc
+
+import (
+	"context"
+	"encoding/hex"
+	"math"
+	"sort"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// RedeemAndConsolidate spends all the unspent outputs of the contract, along
+// with up to extraInputs of the account's smallest unspent outputs, to a
+// single output paying the given address. The fee is computed from feeRate
+// (in SAT per byte) and the size of the signed transaction. f is used to add
+// the data required by the contract (such as a secret) to the signature
+// scripts of the contract inputs, this can be nil. The contract is spent as a
+// P2WSH output if the WitnessContract option is given. Only confirmed outputs
+// are spent unless AllowUnconfirmed is given, and outputs reserved by other
+// transactions of the account, or of a script type that the account does not
+// sign, are never added. RedeemAndConsolidate returns the transaction hash.
+func (account *account) RedeemAndConsolidate(ctx context.Context, contract []byte, extraInputs int, to string, feeRate int64, f func(*txscript.ScriptBuilder), opts ...SendOption) (string, error) {
+	if extraInputs < 0 {
+		return "", NewErrNegativeInputs(extraInputs)
+	}
+	me, err := account.Address()
+	if err != nil {
+		return "", err
+	}
+	toAddress, err := btcutil.DecodeAddress(to, account.NetworkParams())
+	if err != nil {
+		return "", err
+	}
+	P2PKHScript, err := txscript.PayToAddrScript(toAddress)
+	if err != nil {
+		return "", err
+	}
+
+	// Current Bitcoin Transaction Version (2).
+	tx := account.newTx(ctx, wire.NewMsgTx(2), newSendOptions(opts))
+	defer tx.release()
+	address, err := contractAddress(contract, tx.opts.witness, account.NetworkParams())
+	if err != nil {
+		return "", err
+	}
+	contractUtxos, _, reserved, err := tx.unspentOutputs(address, math.MaxInt64)
+	if err != nil {
+		return "", err
+	}
+	if len(contractUtxos) == 0 {
+		if reserved > 0 {
+			return "", NewErrUTXOsReserved(reserved)
+		}
+		return "", NewErrInsufficientBalance(address.EncodeAddress(), 1, 0)
+	}
+	myUtxos, _, _, err := tx.unspentOutputs(me, math.MaxInt64)
+	if err != nil {
+		return "", err
+	}
+	// Outputs that the account cannot sign for would fail to sign with
+	// ErrMixedInputTypes, so they are left behind.
+	extraUtxos := []UnspentOutput{}
+	for _, utxo := range myUtxos {
+		script, err := hex.DecodeString(utxo.ScriptPubKey)
+		if err != nil {
+			return "", err
+		}
+		if txscript.GetScriptClass(script) == account.scriptClass() {
+			extraUtxos = append(extraUtxos, utxo)
+		}
+	}
+	sort.SliceStable(extraUtxos, func(i, j int) bool {
+		return extraUtxos[i].Amount < extraUtxos[j].Amount
+	})
+	if len(extraUtxos) > extraInputs {
+		extraUtxos = extraUtxos[:extraInputs]
+	}
+
+	var value int64
+	for _, utxo := range append(contractUtxos, extraUtxos...) {
+		script, err := hex.DecodeString(utxo.ScriptPubKey)
+		if err != nil {
+			return "", err
+		}
+		if !account.reserved.reserve(utxo, tx) {
+			continue
+		}
+		if err := tx.addInput(utxo, script); err != nil {
+			return "", err
+		}
+		value = value + utxo.Amount
+	}
+	tx.msgTx.AddTxOut(wire.NewTxOut(value, P2PKHScript))
+
+	if err := tx.deductFee(0, feeRate, 0, f, contract); err != nil {
+		return "", err
+	}
+
+	if err := tx.verify(); err != nil {
+		return "", err
+	}
+	if err := tx.submit(); err != nil {
+		return "", err
+	}
+	return tx.msgTx.TxHash().String(), nil
+}
+
+// Sweep spends all the unspent outputs of the account to a single output
+// paying the given address, without change. The fee is computed from feeRate
+// (in SAT per byte) and the size of the signed transaction. Sweep returns the
+// transaction hash, or an error if the swept value after the fee would be
+// dust. Outputs reserved by other transactions of the account are left
+// behind.
+func (account *account) Sweep(ctx context.Context, to string, feeRate int64) (string, error) {
+	me, err := account.Address()
+	if err != nil {
+		return "", err
+	}
+	toAddress, err := btcutil.DecodeAddress(to, account.NetworkParams())
+	if err != nil || !toAddress.IsForNet(account.NetworkParams()) {
+		return "", NewErrInvalidAddresses([]string{to})
+	}
+	script, err := txscript.PayToAddrScript(toAddress)
+	if err != nil {
+		return "", err
+	}
+
+	// Current Bitcoin Transaction Version (2).
+	tx := account.newTx(ctx, wire.NewMsgTx(2), sendOptions{})
+	defer tx.release()
+	utxos, _, reserved, err := tx.unspentOutputs(me, math.MaxInt64)
+	if err != nil {
+		return "", err
+	}
+	var value int64
+	for _, utxo := range utxos {
+		utxoScript, err := hex.DecodeString(utxo.ScriptPubKey)
+		if err != nil {
+			return "", err
+		}
+		// Outputs that the account cannot sign for are left behind.
+		if txscript.GetScriptClass(utxoScript) != account.scriptClass() {
+			continue
+		}
+		if !account.reserved.reserve(utxo, tx) {
+			reserved = reserved + utxo.Amount
+			continue
+		}
+		if err := tx.addInput(utxo, utxoScript); err != nil {
+			return "", err
+		}
+		value = value + utxo.Amount
+	}
+	if len(tx.msgTx.TxIn) == 0 {
+		if reserved > 0 {
+			return "", NewErrUTXOsReserved(reserved)
+		}
+		return "", NewErrInsufficientBalance(me.EncodeAddress(), 1, 0)
+	}
+	tx.msgTx.AddTxOut(wire.NewTxOut(value, script))
+
+	if err := tx.deductFee(0, feeRate, 0, nil, nil); err != nil {
+		return "", err
+	}
+	if err := tx.checkDust(); err != nil {
+		return "", err
+	}
+	if err := tx.verify(); err != nil {
+		return "", err
+	}
+	if err := tx.submit(); err != nil {
+		return "", err
+	}
+	return tx.msgTx.TxHash().String(), nil
+}
+
+// Consolidate spends up to maxInputs of the account's smallest confirmed
+// unspent outputs, skipping those reserved by other transactions of the
+// account, to a single output paying the account, so that later
+// transactions need fewer inputs. The fee is computed from feeRate (in SAT
+// per byte) and the size of the signed transaction. Nothing is published if
+// the fee would exceed the consolidated value, or if the account has fewer
+// than two unspent outputs to consolidate. Consolidate returns the
+// transaction hash.
+func (account *account) Consolidate(ctx context.Context, feeRate int64, maxInputs int) (string, error) {
+	me, err := account.Address()
+	if err != nil {
+		return "", err
+	}
+	script, err := txscript.PayToAddrScript(me)
+	if err != nil {
+		return "", err
+	}
+
+	// Current Bitcoin Transaction Version (2).
+	tx := account.newTx(ctx, wire.NewMsgTx(2), sendOptions{})
+	defer tx.release()
+	utxos, err := tx.consolidationCandidates(maxInputs)
+	if err != nil {
+		return "", err
+	}
+	var value int64
+	for _, utxo := range utxos {
+		utxoScript, err := hex.DecodeString(utxo.ScriptPubKey)
+		if err != nil {
+			return "", err
+		}
+		if !account.reserved.reserve(utxo, tx) {
+			continue
+		}
+		if err := tx.addInput(utxo, utxoScript); err != nil {
+			return "", err
+		}
+		value = value + utxo.Amount
+	}
+	if len(tx.msgTx.TxIn) < 2 {
+		return "", ErrNothingToConsolidate
+	}
+	tx.msgTx.AddTxOut(wire.NewTxOut(value, script))
+
+	if err := tx.deductFee(0, feeRate, 0, nil, nil); err != nil {
+		return "", err
+	}
+	if err := tx.checkDust(); err != nil {
+		return "", err
+	}
+	if err := tx.verify(); err != nil {
+		return "", err
+	}
+	if err := tx.submit(); err != nil {
+		return "", err
+	}
+	return tx.msgTx.TxHash().String(), nil
+}
+
+// EstimateConsolidationBenefit selects up to maxInputs of the account's
+// smallest confirmed unspent outputs, and returns their total value, the fee
+// at the given fee rate (in SAT per byte) for consolidating them into a
+// single output, and whether the consolidation is worthwhile because there
+// are at least two outputs to merge and the fee is less than the value
+// recovered. It selects the same outputs as Consolidate.
+func (account *account) EstimateConsolidationBenefit(ctx context.Context, maxInputs int, feeRate int64) (int64, int64, bool, error) {
+	// Current Bitcoin Transaction Version (2).
+	tx := account.newTx(ctx, wire.NewMsgTx(2), sendOptions{})
+	defer tx.release()
+	utxos, err := tx.consolidationCandidates(maxInputs)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	var recovered int64
+	for _, utxo := range utxos {
+		recovered = recovered + utxo.Amount
+	}
+	size, err := account.estimateSize(len(utxos), 1)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	fee := feeRate * size
+	return recovered, fee, len(utxos) >= 2 && fee < recovered, nil
+}
+
+// consolidationCandidates returns up to maxInputs of the account's smallest
+// confirmed unspent outputs that the transaction can spend. Outputs reserved
+// by other transactions, and outputs of a script type that the account does
+// not sign, are skipped. The candidates are not filtered by value: the
+// smallest outputs are taken even if each of them is worth less than the fee
+// of spending it, since those are the outputs that consolidating saves the
+// most on.
+func (tx *tx) consolidationCandidates(maxInputs int) ([]UnspentOutput, error) {
+	if maxInputs < 0 {
+		return nil, NewErrNegativeInputs(maxInputs)
+	}
+	me, err := tx.account.Address()
+	if err != nil {
+		return nil, err
+	}
+	utxos, _, _, err := tx.unspentOutputs(me, math.MaxInt64)
+	if err != nil {
+		return nil, err
+	}
+	candidates := []UnspentOutput{}
+	for _, utxo := range utxos {
+		script, err := hex.DecodeString(utxo.ScriptPubKey)
+		if err != nil {
+			return nil, err
+		}
+		if txscript.GetScriptClass(script) == tx.account.scriptClass() {
+			candidates = append(candidates, utxo)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Amount < candidates[j].Amount
+	})
+	if len(candidates) > maxInputs {
+		candidates = candidates[:maxInputs]
+	}
+	return candidates, nil
+}
@@ -0,0 +1,106 @@
+package libbtc
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// BumpWithChild accelerates an unconfirmed transaction by spending one of its
+// outputs back to the account in a child transaction, and returns the hash of
+// the child. The fee of the child is chosen so that the parent and child
+// together pay feeRate SAT per byte (child-pays-for-parent). The output must
+// belong to the account.
+func (account *account) BumpWithChild(ctx context.Context, parentTxid string, vout uint32, feeRate int64) (string, error) {
+	parent, err := account.GetRawTransaction(ctx, parentTxid)
+	if err != nil {
+		return "", err
+	}
+	if parent.BlockHeight != 0 {
+		return "", ErrAlreadyConfirmed
+	}
+	if int(vout) >= len(parent.Outputs) {
+		return "", NewErrOutputNotFound(parentTxid, vout)
+	}
+	var parentFee int64
+	for _, input := range parent.Inputs {
+		parentFee = parentFee + int64(input.PrevOut.Value)
+	}
+	for _, output := range parent.Outputs {
+		parentFee = parentFee - int64(output.Value)
+	}
+
+	me, err := account.Address()
+	if err != nil {
+		return "", err
+	}
+	script, err := txscript.PayToAddrScript(me)
+	if err != nil {
+		return "", err
+	}
+	output := parent.Outputs[vout]
+	outputScript, err := hex.DecodeString(output.Script)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(outputScript, script) {
+		owner := output.Script
+		if addrs, err := output.Addresses(account.NetworkParams()); err == nil && len(addrs) > 0 {
+			owner = addrs[0]
+		}
+		return "", NewErrForeignInput(owner)
+	}
+	hash, err := chainhash.NewHashFromStr(parentTxid)
+	if err != nil {
+		return "", err
+	}
+
+	// Current Bitcoin Transaction Version (2).
+	tx := account.newTx(ctx, wire.NewMsgTx(2), sendOptions{})
+	defer tx.release()
+	utxo := UnspentOutput{
+		TransactionHash:         hex.EncodeToString(hash[:]),
+		TransactionOutputNumber: vout,
+		Amount:                  int64(output.Value),
+	}
+	if !account.reserved.reserve(utxo, tx) {
+		return "", NewErrUTXOsReserved(utxo.Amount)
+	}
+	if err := tx.addInput(utxo, script); err != nil {
+		return "", err
+	}
+	tx.msgTx.AddTxOut(wire.NewTxOut(int64(output.Value), script))
+
+	// The child pays for the size of both transactions, less the fee that
+	// the parent already pays, but never less than its own size.
+	if err := tx.sign(nil, nil, nil); err != nil {
+		return "", err
+	}
+	childSize := virtualSize(tx.msgTx)
+	fee := feeRate*(parent.Size+childSize) - parentFee
+	if fee < feeRate*childSize {
+		fee = feeRate * childSize
+	}
+	if fee >= int64(output.Value) {
+		return "", NewErrFeeExceedsValue(fee, int64(output.Value))
+	}
+	tx.msgTx.TxOut[0].Value = int64(output.Value) - fee
+	if err := tx.checkDust(); err != nil {
+		return "", err
+	}
+	if err := tx.sign(nil, nil, nil); err != nil {
+		return "", err
+	}
+
+	if err := tx.verify(); err != nil {
+		return "", err
+	}
+	if err := tx.submit(); err != nil {
+		return "", err
+	}
+	return tx.msgTx.TxHash().String(), nil
+}
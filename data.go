@@ -0,0 +1,54 @@
+package libbtc
+
+import (
+	"context"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// AddDataOutput appends a zero value OP_RETURN output carrying the data to the
+// transaction. It can be used inside the preCond of SendTransaction. Data
+// larger than txscript.MaxDataCarrierSize (80 bytes) is not relayed by Bitcoin
+// nodes, and is refused.
+func AddDataOutput(msgTx *wire.MsgTx, data []byte) error {
+	if len(data) > txscript.MaxDataCarrierSize {
+		return NewErrDataTooLarge(len(data))
+	}
+	script, err := txscript.NullDataScript(data)
+	if err != nil {
+		return err
+	}
+	msgTx.AddTxOut(wire.NewTxOut(0, script))
+	return nil
+}
+
+// SendWithData transfers the value to an address, in a transaction that also
+// carries the data in an OP_RETURN output.
+func (account *account) SendWithData(ctx context.Context, to string, value int64, data []byte, fee int64, opts ...SendOption) (string, error) {
+	if len(data) > txscript.MaxDataCarrierSize {
+		return "", NewErrDataTooLarge(len(data))
+	}
+	address, err := btcutil.DecodeAddress(to, account.NetworkParams())
+	if err != nil {
+		return "", err
+	}
+	P2PKHScript, err := txscript.PayToAddrScript(address)
+	if err != nil {
+		return "", err
+	}
+	return account.SendTransaction(
+		ctx,
+		nil,
+		fee,
+		nil,
+		func(tx *wire.MsgTx) bool {
+			tx.AddTxOut(wire.NewTxOut(value, P2PKHScript))
+			return AddDataOutput(tx, data) == nil
+		},
+		nil,
+		nil,
+		opts...,
+	)
+}
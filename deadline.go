@@ -0,0 +1,167 @@
+package libbtc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+const (
+	// deadlineBumps is the number of times SendWithDeadline bumps the fee of
+	// the transaction before the deadline is reached.
+	deadlineBumps = 4
+
+	// deadlinePollInterval is the longest interval at which SendWithDeadline
+	// checks whether the transaction is confirmed.
+	deadlinePollInterval = 30 * time.Second
+)
+
+// SendWithDeadline sends the given outputs (a map from address to value) and
+// tries to get the transaction confirmed before the deadline. The
+// transaction signals BIP125 replace-by-fee and is first broadcast at the fee
+// rate estimated by the client for it to confirm by the deadline. If it is
+// not confirmed, the fee rate is increased by 50% (and by at least 1 SAT per
+// byte) at evenly spaced intervals before the deadline, and a replacement is
+// broadcast by reducing the change output, or dropping it once it would be
+// dust. SendWithDeadline returns the hash of the transaction that was
+// confirmed, or ErrTimedOut if none of them were confirmed before the
+// deadline.
+func (account *account) SendWithDeadline(ctx context.Context, outputs map[string]int64, deadline time.Time) (string, error) {
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	me, err := account.Address()
+	if err != nil {
+		return "", err
+	}
+
+	// Current Bitcoin Transaction Version (2).
+	tx := account.newTx(ctx, wire.NewMsgTx(2), sendOptions{rbf: true})
+	defer tx.release()
+	if err := tx.addOutputs(outputs); err != nil {
+		return "", err
+	}
+
+	targetBlocks := int(time.Until(deadline) / blockInterval)
+	if targetBlocks < 1 {
+		targetBlocks = 1
+	}
+	feeRate, err := account.EstimateFeeRate(ctx, targetBlocks)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.fundWithFeeRate(me, feeRate, nil, nil); err != nil {
+		return "", err
+	}
+	if err := tx.verify(); err != nil {
+		return "", err
+	}
+	if err := tx.submit(); err != nil {
+		return "", err
+	}
+	txHashes := []string{tx.msgTx.TxHash().String()}
+
+	bumpInterval := time.Until(deadline) / (deadlineBumps + 1)
+	nextBump := time.Now().Add(bumpInterval)
+	// Short deadlines are polled more often, so that no bump is missed.
+	pollInterval := deadlinePollInterval
+	if bumpInterval < pollInterval {
+		pollInterval = bumpInterval
+	}
+	for {
+		// The latest replacement is checked first. Replaced transactions
+		// are evicted from the mempool, and a transaction that has just been
+		// published may not be indexed yet, so transactions that cannot be
+		// found are not confirmed yet. Each check is bounded by the poll
+		// interval.
+		for i := len(txHashes) - 1; i >= 0; i-- {
+			checkCtx, checkCancel := context.WithTimeout(ctx, pollInterval)
+			confirmations, err := account.Confirmations(checkCtx, txHashes[i])
+			checkCancel()
+			if err != nil && err != ErrTimedOut && !errors.Is(err, ErrNotFound) {
+				return "", err
+			}
+			if err == nil && confirmations > 0 {
+				return txHashes[i], nil
+			}
+		}
+
+		if time.Now().After(nextBump) {
+			nextBump = nextBump.Add(bumpInterval)
+			// Low fee rates would not increase by half with integer
+			// arithmetic.
+			if feeRate*3/2 > feeRate+1 {
+				feeRate = feeRate * 3 / 2
+			} else {
+				feeRate = feeRate + 1
+			}
+			bumped, err := tx.bumpFee(feeRate)
+			if err != nil {
+				return "", err
+			}
+			if bumped {
+				if err := tx.submit(); err != nil {
+					return "", err
+				}
+				txHashes = append(txHashes, tx.msgTx.TxHash().String())
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ErrTimedOut
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// bumpFee increases the fee of a signed transaction to feeRate SAT per byte
+// by reducing its change output, and signs it again. BIP125 also requires the
+// fee to increase by at least the minimum relay fee (1 SAT per byte) of the
+// replacement, so the fee is increased by at least that much. If the reduced
+// change would be dust, the change output is dropped and left to the miners.
+// It returns false if the transaction has no change output that can cover the
+// increase.
+func (tx *tx) bumpFee(feeRate int64) (bool, error) {
+	if tx.changeIndex < 0 {
+		return false, nil
+	}
+	var in, out int64
+	for _, value := range tx.receiveValues {
+		in = in + value
+	}
+	for _, txOut := range tx.msgTx.TxOut {
+		out = out + txOut.Value
+	}
+	size := virtualSize(tx.msgTx)
+	increase := feeRate*size - (in - out)
+	// Signing the replacement can make each signature up to 2 bytes longer,
+	// which must also be paid for by the minimum increase.
+	if minIncrease := size + 2*int64(len(tx.msgTx.TxIn)); increase < minIncrease {
+		increase = minIncrease
+	}
+	change := tx.msgTx.TxOut[tx.changeIndex]
+	if change.Value-increase <= 0 {
+		return false, nil
+	}
+	if change.Value-increase < DustThreshold(change.PkScript) {
+		tx.msgTx.TxOut = append(tx.msgTx.TxOut[:tx.changeIndex], tx.msgTx.TxOut[tx.changeIndex+1:]...)
+		tx.changeIndex = -1
+		for i, changeOutput := range tx.changeOutputs {
+			if changeOutput == change {
+				tx.changeOutputs = append(tx.changeOutputs[:i], tx.changeOutputs[i+1:]...)
+				break
+			}
+		}
+		tx.change = tx.change - change.Value
+	} else {
+		change.Value = change.Value - increase
+		tx.change = tx.change - increase
+	}
+	if err := tx.sign(nil, nil, nil); err != nil {
+		return false, err
+	}
+	return true, tx.verify()
+}
@@ -0,0 +1,90 @@
+package libbtc
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// DecodeTransaction deserializes a raw transaction, with or without witness
+// data.
+func DecodeTransaction(raw []byte) (*wire.MsgTx, error) {
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	if err := msgTx.Deserialize(bytes.NewReader(raw)); err != nil {
+		return nil, err
+	}
+	return msgTx, nil
+}
+
+// SerializeTransaction serializes a signed transaction, with its witness data
+// if it has any, and returns the raw transaction along with its hex encoding.
+// The raw transaction can be published using PublishTransaction.
+func SerializeTransaction(msgTx *wire.MsgTx) ([]byte, string, error) {
+	raw, err := serializeTransaction(msgTx, defaultEncoding(msgTx))
+	if err != nil {
+		return nil, "", err
+	}
+	return raw, hex.EncodeToString(raw), nil
+}
+
+// PublishRawHex decodes a hex encoded raw transaction, publishes it, and
+// returns its hash.
+func (account *account) PublishRawHex(ctx context.Context, hexTx string) (string, error) {
+	raw, err := hex.DecodeString(hexTx)
+	if err != nil {
+		return "", err
+	}
+	msgTx, err := DecodeTransaction(raw)
+	if err != nil {
+		return "", err
+	}
+	if err := account.PublishTransaction(ctx, raw); err != nil {
+		return "", err
+	}
+	return msgTx.TxHash().String(), nil
+}
+
+// ParseTransaction decodes a raw transaction into a Transaction, without
+// asking the client about it. Output addresses are decoded on the network of
+// the account. Inputs only reference their previous outputs, since their
+// values and addresses are not part of the transaction.
+func (account *account) ParseTransaction(raw []byte) (Transaction, error) {
+	msgTx, err := DecodeTransaction(raw)
+	if err != nil {
+		return Transaction{}, err
+	}
+	hash := msgTx.TxHash()
+	transaction := Transaction{
+		TransactionHash: hash.String(),
+		Version:         uint8(msgTx.Version),
+		VinSize:         uint32(len(msgTx.TxIn)),
+		VoutSize:        uint32(len(msgTx.TxOut)),
+		Size:            int64(msgTx.SerializeSize()),
+	}
+	for _, txIn := range msgTx.TxIn {
+		transaction.Inputs = append(transaction.Inputs, Input{
+			PrevOut: PreviousOut{
+				TransactionHash: txIn.PreviousOutPoint.Hash.String(),
+				VoutNumber:      txIn.PreviousOutPoint.Index,
+			},
+			Script:   hex.EncodeToString(txIn.SignatureScript),
+			Sequence: txIn.Sequence,
+		})
+	}
+	for _, txOut := range msgTx.TxOut {
+		output := Output{
+			Value:           uint64(txOut.Value),
+			TransactionHash: hash.String(),
+			Script:          hex.EncodeToString(txOut.PkScript),
+		}
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(txOut.PkScript, account.NetworkParams())
+		if err == nil && len(addrs) > 0 {
+			output.Address = addrs[0].EncodeAddress()
+		}
+		transaction.Outputs = append(transaction.Outputs, output)
+	}
+	return transaction, nil
+}
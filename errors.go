@@ -3,6 +3,11 @@ package libbtc
 import (
 	"errors"
 	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
 )
 
 // ErrPreConditionCheckFailed indicates that the pre-condition for executing
@@ -15,18 +20,395 @@ var ErrPostConditionCheckFailed = errors.New("post-condition check failed")
 
 var ErrTimedOut = errors.New("timed out")
 
+// ErrConfirmationTimeout indicates that the post-condition of a transaction
+// did not hold before the context was done, or the confirmation timeout
+// passed. Errors returned by NewErrConfirmationTimeout wrap it, as well as
+// the error of the context.
+var ErrConfirmationTimeout = errors.New("timed out waiting for the post-condition")
+
+type confirmationTimeoutError struct {
+	err error
+}
+
+func NewErrConfirmationTimeout(err error) error {
+	return confirmationTimeoutError{err: err}
+}
+
+func (err confirmationTimeoutError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrConfirmationTimeout, err.err)
+}
+
+func (err confirmationTimeoutError) Is(target error) bool {
+	return target == ErrConfirmationTimeout
+}
+
+func (err confirmationTimeoutError) Unwrap() error {
+	return err.err
+}
+
+// ErrRebroadcastLimit indicates that the post-condition of a transaction did
+// not hold after publishing it again the maximum number of times. Errors
+// returned by NewErrRebroadcastLimit wrap it, as well as the last error
+// returned when publishing the transaction again.
+var ErrRebroadcastLimit = errors.New("post-condition did not hold after rebroadcasting")
+
+type rebroadcastLimitError struct {
+	rebroadcasts int
+	err          error
+}
+
+func NewErrRebroadcastLimit(rebroadcasts int, lastErr error) error {
+	return rebroadcastLimitError{rebroadcasts: rebroadcasts, err: lastErr}
+}
+
+func (err rebroadcastLimitError) Error() string {
+	if err.err == nil {
+		return fmt.Sprintf("%v %d times", ErrRebroadcastLimit, err.rebroadcasts)
+	}
+	return fmt.Sprintf("%v %d times: %v", ErrRebroadcastLimit, err.rebroadcasts, err.err)
+}
+
+func (err rebroadcastLimitError) Is(target error) bool {
+	return target == ErrRebroadcastLimit
+}
+
+func (err rebroadcastLimitError) Unwrap() error {
+	return err.err
+}
+
+// ErrRetryBudgetExhausted indicates that a request failed, and was not
+// retried again because its retry budget was exhausted. Errors returned by
+// NewErrRetryBudgetExhausted wrap it, as well as the error of the last
+// attempt.
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+type retryBudgetError struct {
+	attempts int
+	err      error
+}
+
+func NewErrRetryBudgetExhausted(attempts int, lastErr error) error {
+	return retryBudgetError{attempts: attempts, err: lastErr}
+}
+
+func (err retryBudgetError) Error() string {
+	return fmt.Sprintf("%v after %d attempts: %v", ErrRetryBudgetExhausted, err.attempts, err.err)
+}
+
+func (err retryBudgetError) Is(target error) bool {
+	return target == ErrRetryBudgetExhausted
+}
+
+func (err retryBudgetError) Unwrap() error {
+	return err.err
+}
+
 var ErrNoSpendingTransactions = fmt.Errorf("No spending transactions")
 
-var ErrMismatchedPubKeys = fmt.Errorf("failed to fund the transaction mismatched script public keys")
+// ErrMismatchedPubKeys indicates that a transaction could not be funded
+// because some of the unspent outputs pay to a different script than the
+// others. Errors returned by NewErrMismatchedPubKeys wrap it.
+var ErrMismatchedPubKeys = errors.New("failed to fund the transaction mismatched script public keys")
+
+func NewErrMismatchedPubKeys(shortfall int64) error {
+	return fmt.Errorf("%w: %d SAT short without them", ErrMismatchedPubKeys, shortfall)
+}
+
+func NewErrNetworkMismatch(network string) error {
+	return fmt.Errorf("key is not encoded for the %s network", network)
+}
 
 func NewErrUnsupportedNetwork(network string) error {
 	return fmt.Errorf("unsupported network %s", network)
 }
 
+// ErrNoClients indicates that a failover Client was created without any
+// clients to fail over between.
+var ErrNoClients = errors.New("no clients")
+
+func NewErrNetworkParamsMismatch(expected, got string) error {
+	return fmt.Errorf("client for the %s network, expected the %s network", got, expected)
+}
+
 func NewErrBitcoinSubmitTx(msg string) error {
 	return fmt.Errorf("error while submitting Bitcoin transaction: %s", msg)
 }
+
+// ErrInsufficientBalance indicates that an address does not have enough
+// unspent outputs to fund a transaction. InsufficientBalanceError wraps it.
+var ErrInsufficientBalance = errors.New("insufficient balance")
+
+// InsufficientBalanceError is returned when the unspent outputs of an address
+// are worth less than required to fund a transaction.
+type InsufficientBalanceError struct {
+	Address  string
+	Required int64
+	Current  int64
+}
+
 func NewErrInsufficientBalance(address string, required, current int64) error {
-	return fmt.Errorf("insufficient balance in %s "+
-		"required:%d current:%d", address, required, current)
+	return &InsufficientBalanceError{Address: address, Required: required, Current: current}
+}
+
+func (err *InsufficientBalanceError) Error() string {
+	return fmt.Sprintf("insufficient balance in %s "+
+		"required:%d current:%d", err.Address, err.Required, err.Current)
+}
+
+// Unwrap returns ErrInsufficientBalance, so that the error can be checked
+// using errors.Is.
+func (err *InsufficientBalanceError) Unwrap() error {
+	return ErrInsufficientBalance
+}
+
+// Shortfall returns the value that the address is missing.
+func (err *InsufficientBalanceError) Shortfall() int64 {
+	return err.Required - err.Current
+}
+
+// ErrUTXOsReserved indicates that a transaction could not be funded because
+// the unspent outputs that would cover it are reserved by other transactions
+// of the account, which are being built or have not confirmed yet. Errors
+// returned by NewErrUTXOsReserved wrap it.
+var ErrUTXOsReserved = errors.New("unspent outputs are reserved by other transactions")
+
+func NewErrUTXOsReserved(shortfall int64) error {
+	return fmt.Errorf("%w: %d SAT short without them", ErrUTXOsReserved, shortfall)
+}
+
+// ErrNotReplaceable indicates that a transaction does not signal BIP125
+// replace-by-fee and cannot be replaced.
+var ErrNotReplaceable = errors.New("transaction is not replaceable")
+
+// ErrNoChangeOutput indicates that a transaction has no output paying back to
+// the account, from which an increased fee can be deducted.
+var ErrNoChangeOutput = errors.New("transaction has no change output")
+
+// ErrFeeEstimateUnavailable indicates that a Client cannot estimate fee rates.
+var ErrFeeEstimateUnavailable = errors.New("fee estimate unavailable")
+
+// ErrFeeRateTooLow indicates that a fee rate is below the fee rate estimated
+// for the longest confirmation target, so no confirmation time can be
+// estimated.
+var ErrFeeRateTooLow = errors.New("fee rate too low to estimate a confirmation time")
+
+// ErrAlreadyConfirmed indicates that a transaction is already confirmed and
+// cannot be replaced.
+var ErrAlreadyConfirmed = errors.New("transaction is already confirmed")
+
+// ErrNothingToConsolidate indicates that an account has fewer than two
+// unspent outputs that can be consolidated.
+var ErrNothingToConsolidate = errors.New("fewer than two unspent outputs to consolidate")
+
+func NewErrFeeExceedsValue(fee, value int64) error {
+	return fmt.Errorf("fee of %d exceeds the value of %d", fee, value)
+}
+
+func NewErrForeignInput(address string) error {
+	return fmt.Errorf("cannot sign input spending from %s", address)
+}
+
+// ErrInsufficientUnspentOutputs indicates that the unspent outputs available
+// to a CoinSelector cannot cover the target value.
+var ErrInsufficientUnspentOutputs = errors.New("insufficient unspent outputs")
+
+func NewErrUnsupportedAddress(address string) error {
+	return fmt.Errorf("unsupported address type %s", address)
+}
+
+func NewErrNotEnoughSignatures(required, current int) error {
+	return fmt.Errorf("not enough signatures required:%d current:%d", required, current)
+}
+
+// ErrNotPushOnly indicates that a signature script cannot be used as a
+// witness, because it does more than push data.
+var ErrNotPushOnly = errors.New("script is not push only")
+
+// ErrNotMultisig indicates that a redeem script is not a multisig script.
+var ErrNotMultisig = errors.New("redeem script is not a multisig script")
+
+func NewErrInvalidMultisig(required, keys int) error {
+	return fmt.Errorf("invalid multisig requiring %d of %d keys", required, keys)
+}
+
+func NewErrBlockNotFound(block string) error {
+	return fmt.Errorf("block %s not found", block)
+}
+
+// ErrWitnessEncodingRequired indicates that a transaction with witness data
+// was going to be serialized without its witnesses.
+var ErrWitnessEncodingRequired = errors.New("transaction with witness data requires witness encoding")
+
+func NewErrInvalidRefund(reason string) error {
+	return fmt.Errorf("invalid refund transaction: %s", reason)
+}
+
+// ErrSecretNotFound indicates that a signature script does not contain the
+// secret of an HTLC.
+var ErrSecretNotFound = errors.New("secret not found")
+
+type secretNotFoundError struct {
+	address string
+}
+
+func NewErrSecretNotFound(address string) error {
+	return secretNotFoundError{address: address}
+}
+
+func (err secretNotFoundError) Error() string {
+	return fmt.Sprintf("%v in the script spending from %s", ErrSecretNotFound, err.address)
+}
+
+func (err secretNotFoundError) Is(target error) bool {
+	return target == ErrSecretNotFound
+}
+
+func NewErrInvalidSecretHash(size, expected int) error {
+	return fmt.Errorf("invalid secret hash of %d bytes, expected %d bytes", size, expected)
+}
+
+// ErrNoChangeAddresses indicates that a ChangeAddressSelector has no
+// addresses to send change to.
+var ErrNoChangeAddresses = errors.New("no change addresses")
+
+func NewErrInvalidPaymentURI(uri string) error {
+	return fmt.Errorf("invalid payment uri %s", uri)
+}
+
+func NewErrInvalidAmount(amount string) error {
+	return fmt.Errorf("invalid amount %s", amount)
+}
+
+// ErrFeeExceedsPercentOfAmount indicates that the fee of a transaction
+// exceeds the maximum percentage of the amount being sent.
+var ErrFeeExceedsPercentOfAmount = errors.New("fee exceeds the maximum percentage of the amount")
+
+// ErrFeeTooHigh indicates that the fee of a transaction exceeds the maximum
+// fee, or fee rate, allowed by the send options. Errors returned by
+// NewErrFeeTooHigh wrap it.
+var ErrFeeTooHigh = errors.New("fee too high")
+
+type feeTooHighError struct {
+	fee int64
+	max int64
+}
+
+func NewErrFeeTooHigh(fee, max int64) error {
+	return feeTooHighError{fee: fee, max: max}
+}
+
+func (err feeTooHighError) Error() string {
+	return fmt.Sprintf("%v: fee of %d SAT exceeds the maximum of %d SAT", ErrFeeTooHigh, err.fee, err.max)
+}
+
+func (err feeTooHighError) Is(target error) bool {
+	return target == ErrFeeTooHigh
+}
+
+func NewErrUnsupportedPurpose(purpose uint32) error {
+	return fmt.Errorf("unsupported derivation purpose %d", purpose)
+}
+
+// ErrTxidMismatch indicates that the txid of a broadcast transaction differs
+// from the expected txid.
+var ErrTxidMismatch = errors.New("txid of the broadcast transaction does not match the expected txid")
+
+// ErrTxidUnverified indicates that a transaction was broadcast, but the
+// transaction spending its outputs could not be found in time to verify its
+// txid.
+var ErrTxidUnverified = errors.New("broadcast transaction not found to verify its txid")
+
+// ErrPrevOutMismatch indicates that an output spent by a transaction does not
+// have the value or script that the client reported for it. Errors returned
+// by NewErrPrevOutMismatch wrap it.
+var ErrPrevOutMismatch = errors.New("spent output does not match the unspent output reported by the client")
+
+type prevOutMismatchError struct {
+	outpoint wire.OutPoint
+}
+
+func NewErrPrevOutMismatch(outpoint wire.OutPoint) error {
+	return prevOutMismatchError{outpoint: outpoint}
+}
+
+func (err prevOutMismatchError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrPrevOutMismatch, err.outpoint)
+}
+
+func (err prevOutMismatchError) Is(target error) bool {
+	return target == ErrPrevOutMismatch
+}
+
+func NewErrUnsupportedAddressType(addressType AddressType) error {
+	return fmt.Errorf("unsupported address type %d", addressType)
+}
+
+// ErrMixedInputTypes indicates that a transaction spends an output of a
+// different address type than the address type of the signing account.
+var ErrMixedInputTypes = errors.New("cannot sign inputs of a different address type than the account")
+
+func NewErrBitcoinRPC(method string, code int, msg string) error {
+	return fmt.Errorf("error while calling %s: %d %s", method, code, msg)
+}
+
+// ErrNotFound indicates that an API or node does not know the requested
+// transaction or block. Errors returned by NewErrUnexpectedStatus for a 404
+// (Not Found) status wrap it.
+var ErrNotFound = errors.New("not found")
+
+type unexpectedStatusError struct {
+	status int
+	body   string
+}
+
+func NewErrUnexpectedStatus(status int, body string) error {
+	return unexpectedStatusError{status: status, body: body}
+}
+
+func (err unexpectedStatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d: %s", err.status, err.body)
+}
+
+func (err unexpectedStatusError) Is(target error) bool {
+	return target == ErrNotFound && err.status == http.StatusNotFound
+}
+
+func NewErrInvalidAddresses(addresses []string) error {
+	return fmt.Errorf("invalid addresses: %s", strings.Join(addresses, ", "))
+}
+
+func NewErrDustOutput(value, threshold int64) error {
+	return fmt.Errorf("output value of %d is below the dust threshold of %d", value, threshold)
+}
+
+func NewErrDataTooLarge(size int) error {
+	return fmt.Errorf("data of %d bytes exceeds the limit of %d bytes", size, txscript.MaxDataCarrierSize)
+}
+
+func NewErrOutputNotFound(txid string, vout uint32) error {
+	return fmt.Errorf("transaction %s has no output %d", txid, vout)
+}
+
+func NewErrInputCount(expected, got int) error {
+	return fmt.Errorf("expected %d inputs, got %d", expected, got)
+}
+
+func NewErrNegativeInputs(inputs int) error {
+	return fmt.Errorf("number of inputs %d is negative", inputs)
+}
+
+func NewErrInputIndex(index, inputs int) error {
+	return fmt.Errorf("input %d out of range for %d inputs", index, inputs)
+}
+
+func NewErrInputMismatch(index int) error {
+	return fmt.Errorf("input %d does not spend the given output", index)
+}
+
+func NewErrInvalidSigHashType(hashType txscript.SigHashType) error {
+	return fmt.Errorf("invalid sighash type %#x", uint32(hashType))
+}
+
+func NewErrSigHashSingleOutputs(numInputs, numOutputs int) error {
+	return fmt.Errorf("SigHashSingle needs an output for each of the %d inputs, got %d outputs", numInputs, numOutputs)
 }
@@ -0,0 +1,461 @@
+package libbtc
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+)
+
+// esploraPageSize is the number of confirmed transactions of an address
+// returned by a single request to Esplora.
+const esploraPageSize = 25
+
+type esploraClient struct {
+	// base is used for its rate limit and retry policy.
+	base   client
+	URL    string
+	Params *chaincfg.Params
+}
+
+type esploraStatus struct {
+	Confirmed   bool   `json:"confirmed"`
+	BlockHeight int64  `json:"block_height"`
+	BlockHash   string `json:"block_hash"`
+}
+
+type esploraUTXO struct {
+	TxID   string        `json:"txid"`
+	Vout   uint32        `json:"vout"`
+	Value  int64         `json:"value"`
+	Status esploraStatus `json:"status"`
+}
+
+type esploraOutput struct {
+	ScriptPubKey        string `json:"scriptpubkey"`
+	ScriptPubKeyAddress string `json:"scriptpubkey_address"`
+	Value               uint64 `json:"value"`
+}
+
+type esploraTransaction struct {
+	TxID    string `json:"txid"`
+	Version int32  `json:"version"`
+	Size    int64  `json:"size"`
+	Vin     []struct {
+		TxID      string        `json:"txid"`
+		Vout      uint32        `json:"vout"`
+		PrevOut   esploraOutput `json:"prevout"`
+		ScriptSig string        `json:"scriptsig"`
+		Sequence  uint32        `json:"sequence"`
+	} `json:"vin"`
+	Vout   []esploraOutput `json:"vout"`
+	Status esploraStatus   `json:"status"`
+}
+
+type esploraAddressStats struct {
+	FundedTxoSum int64 `json:"funded_txo_sum"`
+	SpentTxoSum  int64 `json:"spent_txo_sum"`
+	TxCount      int64 `json:"tx_count"`
+}
+
+type esploraAddress struct {
+	Address      string              `json:"address"`
+	ChainStats   esploraAddressStats `json:"chain_stats"`
+	MempoolStats esploraAddressStats `json:"mempool_stats"`
+}
+
+type esploraBlock struct {
+	ID                string `json:"id"`
+	Height            int64  `json:"height"`
+	Version           int32  `json:"version"`
+	Timestamp         int64  `json:"timestamp"`
+	MerkleRoot        string `json:"merkle_root"`
+	PreviousBlockHash string `json:"previousblockhash"`
+	Nonce             int64  `json:"nonce"`
+	Bits              int64  `json:"bits"`
+}
+
+// NewEsploraClient returns a Client that talks to an Esplora REST API, such
+// as the ones exposed by blockstream.info and mempool.space. The url must
+// not have a trailing slash (for example, https://blockstream.info/api).
+func NewEsploraClient(url string, params *chaincfg.Params, opts ...ClientOption) Client {
+	registerParams(params)
+	c := &esploraClient{
+		base:   client{height: new(heightCache), httpClient: &http.Client{Timeout: DefaultHTTPTimeout}},
+		URL:    url,
+		Params: params,
+	}
+	for _, opt := range opts {
+		opt(&c.base)
+	}
+	return c
+}
+
+func (client *esploraClient) NetworkParams() *chaincfg.Params {
+	return client.Params
+}
+
+func (client *esploraClient) GetUnspentOutputs(ctx context.Context, address string, limit, confirmations int64) (Unspent, error) {
+	return client.GetUnspentOutputsPage(ctx, address, 0, limit, confirmations)
+}
+
+// GetUnspentOutputsPage returns the unspent outputs of the address. Esplora
+// returns all unspent outputs at once, so the page is taken after filtering
+// them by their confirmations.
+func (client *esploraClient) GetUnspentOutputsPage(ctx context.Context, address string, offset, limit, confirmations int64) (Unspent, error) {
+	utxos := Unspent{}
+	addr, err := btcutil.DecodeAddress(address, client.Params)
+	if err != nil {
+		return utxos, err
+	}
+	script, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return utxos, err
+	}
+	esploraUTXOs := []esploraUTXO{}
+	if err := client.getJSON(ctx, fmt.Sprintf("/address/%s/utxo", address), &esploraUTXOs); err != nil {
+		return utxos, err
+	}
+	height, err := client.GetBlockHeight(ctx)
+	if err != nil {
+		return utxos, err
+	}
+	for _, utxo := range esploraUTXOs {
+		utxoConfirmations := confirmationsAt(utxo.Status.Confirmed, utxo.Status.BlockHeight, height)
+		if utxoConfirmations < confirmations {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if limit > 0 && int64(len(utxos.Outputs)) >= limit {
+			break
+		}
+		// Unspent outputs identify their transaction by its hash in
+		// little-endian byte order.
+		hash, err := chainhash.NewHashFromStr(utxo.TxID)
+		if err != nil {
+			return utxos, err
+		}
+		utxos.Outputs = append(utxos.Outputs, UnspentOutput{
+			TransactionHash:         hex.EncodeToString(hash[:]),
+			TransactionOutputNumber: utxo.Vout,
+			ScriptPubKey:            hex.EncodeToString(script),
+			Amount:                  utxo.Value,
+			Confirmations:           utxoConfirmations,
+		})
+	}
+	return utxos, nil
+}
+
+func (client *esploraClient) GetRawTransaction(ctx context.Context, txhash string) (Transaction, error) {
+	tx := esploraTransaction{}
+	if err := client.getJSON(ctx, fmt.Sprintf("/tx/%s", txhash), &tx); err != nil {
+		return Transaction{}, err
+	}
+	return tx.transaction(), nil
+}
+
+func (client *esploraClient) GetSerializedTransaction(ctx context.Context, txhash string) ([]byte, error) {
+	stx, err := client.getText(ctx, fmt.Sprintf("/tx/%s/hex", txhash))
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(stx)
+}
+
+// transaction converts the Esplora transaction into a Transaction.
+func (tx esploraTransaction) transaction() Transaction {
+	transaction := Transaction{
+		TransactionHash: tx.TxID,
+		Version:         uint8(tx.Version),
+		VinSize:         uint32(len(tx.Vin)),
+		VoutSize:        uint32(len(tx.Vout)),
+		Size:            tx.Size,
+		BlockHeight:     tx.Status.BlockHeight,
+	}
+	for _, vin := range tx.Vin {
+		transaction.Inputs = append(transaction.Inputs, Input{
+			PrevOut: PreviousOut{
+				TransactionHash: vin.TxID,
+				Value:           vin.PrevOut.Value,
+				VoutNumber:      vin.Vout,
+				Address:         vin.PrevOut.ScriptPubKeyAddress,
+			},
+			Script:   vin.ScriptSig,
+			Sequence: vin.Sequence,
+		})
+	}
+	for _, vout := range tx.Vout {
+		transaction.Outputs = append(transaction.Outputs, Output{
+			Value:           vout.Value,
+			TransactionHash: tx.TxID,
+			Script:          vout.ScriptPubKey,
+			Address:         vout.ScriptPubKeyAddress,
+		})
+	}
+	return transaction
+}
+
+// GetAddressTransactions returns up to limit transactions of the address,
+// newest first, skipping the first offset transactions.
+func (client *esploraClient) GetAddressTransactions(ctx context.Context, addr string, offset, limit int) ([]Transaction, error) {
+	addressInfo, err := client.GetRawAddressInformation(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return pageTransactions(addressInfo.Transactions, offset, limit), nil
+}
+
+// GetRawAddressInformation returns the information of the address, with all
+// of its transactions, newest first.
+func (client *esploraClient) GetRawAddressInformation(ctx context.Context, addr string) (SingleAddress, error) {
+	addressInfo, err := client.addressStats(ctx, addr)
+	if err != nil {
+		return addressInfo, err
+	}
+	path := fmt.Sprintf("/address/%s/txs", addr)
+	for {
+		txs := []esploraTransaction{}
+		if err := client.getJSON(ctx, path, &txs); err != nil {
+			return addressInfo, err
+		}
+		confirmed := 0
+		for _, tx := range txs {
+			addressInfo.Transactions = append(addressInfo.Transactions, tx.transaction())
+			if tx.Status.Confirmed {
+				confirmed++
+			}
+		}
+		if confirmed < esploraPageSize {
+			return addressInfo, nil
+		}
+		path = fmt.Sprintf("/address/%s/txs/chain/%s", addr, txs[len(txs)-1].TxID)
+	}
+}
+
+// addressStats returns the information of the address, without its
+// transactions.
+func (client *esploraClient) addressStats(ctx context.Context, addr string) (SingleAddress, error) {
+	address := esploraAddress{}
+	if err := client.getJSON(ctx, fmt.Sprintf("/address/%s", addr), &address); err != nil {
+		return SingleAddress{}, err
+	}
+	received := address.ChainStats.FundedTxoSum + address.MempoolStats.FundedTxoSum
+	sent := address.ChainStats.SpentTxoSum + address.MempoolStats.SpentTxoSum
+	return SingleAddress{
+		Address:          address.Address,
+		TransactionCount: address.ChainStats.TxCount + address.MempoolStats.TxCount,
+		Received:         received,
+		Sent:             sent,
+		Balance:          received - sent,
+	}, nil
+}
+
+func (client *esploraClient) PublishTransaction(ctx context.Context, signedTransaction []byte) error {
+	return client.base.backoff(ctx, func() (*http.Response, error) {
+		r, err := http.NewRequest("POST", client.URL+"/tx", strings.NewReader(hex.EncodeToString(signedTransaction)))
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.base.httpClient.Do(r.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		respBytes, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return resp, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return resp, NewErrBitcoinSubmitTx(string(respBytes))
+		}
+		return resp, nil
+	})
+}
+
+func (client *esploraClient) Balance(ctx context.Context, address string, confirmations int64) (int64, error) {
+	unspent, err := client.GetUnspentOutputs(ctx, address, 0, confirmations)
+	if err != nil {
+		return 0, err
+	}
+	balance := int64(0)
+	for _, utxo := range unspent.Outputs {
+		balance = balance + utxo.Amount
+	}
+	return balance, nil
+}
+
+func (client *esploraClient) BalanceDetails(ctx context.Context, address string) (int64, int64, error) {
+	return balanceDetails(ctx, client, address)
+}
+
+func (client *esploraClient) ScriptSpent(ctx context.Context, address string) (bool, error) {
+	rawAddress, err := client.addressStats(ctx, address)
+	if err != nil {
+		return false, err
+	}
+	return rawAddress.Sent > 0, nil
+}
+
+func (client *esploraClient) HasBeenUsed(ctx context.Context, address string) (bool, error) {
+	rawAddress, err := client.addressStats(ctx, address)
+	if err != nil {
+		return false, err
+	}
+	return rawAddress.TransactionCount > 0, nil
+}
+
+func (client *esploraClient) BalanceDelta(ctx context.Context, address string, fromHeight, toHeight int64) (int64, error) {
+	rawAddress, err := client.GetRawAddressInformation(ctx, address)
+	if err != nil {
+		return 0, err
+	}
+	delta := int64(0)
+	for _, tx := range rawAddress.Transactions {
+		// Unconfirmed transactions have no block height.
+		if tx.BlockHeight == 0 || tx.BlockHeight < fromHeight || tx.BlockHeight > toHeight {
+			continue
+		}
+		txDelta, err := transactionDelta(tx, address, client.Params)
+		if err != nil {
+			return 0, err
+		}
+		delta = delta + txDelta
+	}
+	return delta, nil
+}
+
+func (client *esploraClient) ScriptFunded(ctx context.Context, address string, value int64) (bool, int64, error) {
+	rawAddress, err := client.addressStats(ctx, address)
+	if err != nil {
+		return false, 0, err
+	}
+	return rawAddress.Received >= value, rawAddress.Received, nil
+}
+
+func (client *esploraClient) ScriptRedeemed(ctx context.Context, address string, value int64) (bool, int64, error) {
+	rawAddress, err := client.addressStats(ctx, address)
+	if err != nil {
+		return false, 0, err
+	}
+	return rawAddress.Received >= value && rawAddress.Balance == 0, rawAddress.Balance, nil
+}
+
+// GetScriptFromSpentP2SH waits for the address to be spent from, and returns
+// the signature script of the first input spending from it.
+func (client *esploraClient) GetScriptFromSpentP2SH(ctx context.Context, address string) ([]byte, error) {
+	if err := waitForSpend(ctx, client, address); err != nil {
+		return nil, err
+	}
+	return spendingScript(ctx, client, address)
+}
+
+func (client *esploraClient) Confirmations(ctx context.Context, txHash string) (int64, error) {
+	status := esploraStatus{}
+	if err := client.getJSON(ctx, fmt.Sprintf("/tx/%s/status", txHash), &status); err != nil {
+		return 0, err
+	}
+	if !status.Confirmed {
+		return 0, nil
+	}
+	height, err := client.GetBlockHeight(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return 1 + (height - status.BlockHeight), nil
+}
+
+func (client *esploraClient) GetBlockHeader(ctx context.Context, hash string) (BlockHeader, error) {
+	block := esploraBlock{}
+	if err := client.getJSON(ctx, fmt.Sprintf("/block/%s", hash), &block); err != nil {
+		return BlockHeader{}, err
+	}
+	status := struct {
+		InBestChain bool `json:"in_best_chain"`
+	}{}
+	if err := client.getJSON(ctx, fmt.Sprintf("/block/%s/status", hash), &status); err != nil {
+		return BlockHeader{}, err
+	}
+	return BlockHeader{
+		BlockHash:         block.ID,
+		Version:           uint8(block.Version),
+		PreviousBlockHash: block.PreviousBlockHash,
+		MerkleRoot:        block.MerkleRoot,
+		Time:              block.Timestamp,
+		Bits:              block.Bits,
+		Nonce:             block.Nonce,
+		Height:            block.Height,
+		MainChain:         status.InBestChain,
+	}, nil
+}
+
+func (client *esploraClient) GetBlockHeaderByHeight(ctx context.Context, height int64) (BlockHeader, error) {
+	hash, err := client.getText(ctx, fmt.Sprintf("/block-height/%d", height))
+	if err != nil {
+		return BlockHeader{}, err
+	}
+	return client.GetBlockHeader(ctx, hash)
+}
+
+func (client *esploraClient) FormatTransactionView(msg, txhash string) string {
+	return formatTransactionView(client.Params, msg, txhash)
+}
+
+func (client *esploraClient) GetTransactionStatus(ctx context.Context, txHash string) (TxStatus, error) {
+	return transactionStatus(ctx, client, txHash)
+}
+
+func (client *esploraClient) WaitForConfirmations(ctx context.Context, txHash string, n int64) error {
+	return waitForConfirmations(ctx, client, txHash, n)
+}
+
+func (client *esploraClient) GetBlockHeight(ctx context.Context) (int64, error) {
+	return client.base.height.get(func() (int64, error) {
+		height, err := client.getText(ctx, "/blocks/tip/height")
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseInt(height, 10, 64)
+	})
+}
+
+func (client *esploraClient) getJSON(ctx context.Context, path string, v interface{}) error {
+	body, err := client.getText(ctx, path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(body), v)
+}
+
+func (client *esploraClient) getText(ctx context.Context, path string) (string, error) {
+	var body string
+	err := client.base.backoff(ctx, func() (*http.Response, error) {
+		r, err := http.NewRequest("GET", client.URL+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.base.httpClient.Do(r.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		respBytes, err := readBody(resp)
+		if err != nil {
+			return resp, err
+		}
+		body = strings.TrimSpace(string(respBytes))
+		return resp, nil
+	})
+	return body, err
+}
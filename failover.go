@@ -0,0 +1,250 @@
+package libbtc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+type failoverClient struct {
+	clients []Client
+}
+
+// NewFailoverClient returns a Client that tries each of the clients in turn,
+// moving on to the next one when a client returns an error. It returns the
+// error of the last client if all of them fail. Transactions are published
+// to all of the clients. It panics if no clients are given, or the clients
+// are not for the same network.
+func NewFailoverClient(clients ...Client) Client {
+	client, err := NewFailoverClientWithError(clients...)
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// NewFailoverClientWithError is like NewFailoverClient, but returns an error
+// instead of panicking if the clients are not valid.
+func NewFailoverClientWithError(clients ...Client) (Client, error) {
+	if len(clients) == 0 {
+		return nil, ErrNoClients
+	}
+	params := clients[0].NetworkParams()
+	for _, client := range clients[1:] {
+		if client.NetworkParams().Net != params.Net {
+			return nil, NewErrNetworkParamsMismatch(params.Name, client.NetworkParams().Name)
+		}
+	}
+	return &failoverClient{clients: clients}, nil
+}
+
+// try calls f with each of the clients until it succeeds, or the context is
+// done.
+func (client *failoverClient) try(ctx context.Context, f func(Client) error) error {
+	var err error
+	for _, c := range client.clients {
+		if err = f(c); err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (client *failoverClient) NetworkParams() *chaincfg.Params {
+	return client.clients[0].NetworkParams()
+}
+
+func (client *failoverClient) GetUnspentOutputs(ctx context.Context, address string, limit, confirmations int64) (utxos Unspent, err error) {
+	err = client.try(ctx, func(c Client) (err error) {
+		utxos, err = c.GetUnspentOutputs(ctx, address, limit, confirmations)
+		return
+	})
+	return
+}
+
+func (client *failoverClient) GetUnspentOutputsPage(ctx context.Context, address string, offset, limit, confirmations int64) (utxos Unspent, err error) {
+	err = client.try(ctx, func(c Client) (err error) {
+		utxos, err = c.GetUnspentOutputsPage(ctx, address, offset, limit, confirmations)
+		return
+	})
+	return
+}
+
+func (client *failoverClient) GetRawTransaction(ctx context.Context, txhash string) (tx Transaction, err error) {
+	err = client.try(ctx, func(c Client) (err error) {
+		tx, err = c.GetRawTransaction(ctx, txhash)
+		return
+	})
+	return
+}
+
+func (client *failoverClient) GetSerializedTransaction(ctx context.Context, txhash string) (raw []byte, err error) {
+	err = client.try(ctx, func(c Client) (err error) {
+		raw, err = c.GetSerializedTransaction(ctx, txhash)
+		return
+	})
+	return
+}
+
+func (client *failoverClient) GetRawAddressInformation(ctx context.Context, addr string) (info SingleAddress, err error) {
+	err = client.try(ctx, func(c Client) (err error) {
+		info, err = c.GetRawAddressInformation(ctx, addr)
+		return
+	})
+	return
+}
+
+func (client *failoverClient) GetAddressTransactions(ctx context.Context, addr string, offset, limit int) (txs []Transaction, err error) {
+	err = client.try(ctx, func(c Client) (err error) {
+		txs, err = c.GetAddressTransactions(ctx, addr, offset, limit)
+		return
+	})
+	return
+}
+
+// PublishTransaction publishes the transaction to all of the clients, so that
+// it propagates as widely as possible. It only returns an error if all of
+// them fail.
+func (client *failoverClient) PublishTransaction(ctx context.Context, signedTransaction []byte) error {
+	errs := make([]error, len(client.clients))
+	wg := new(sync.WaitGroup)
+	for i, c := range client.clients {
+		wg.Add(1)
+		go func(i int, c Client) {
+			defer wg.Done()
+			errs[i] = c.PublishTransaction(ctx, signedTransaction)
+		}(i, c)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+	}
+	return errs[len(errs)-1]
+}
+
+func (client *failoverClient) Balance(ctx context.Context, address string, confirmations int64) (balance int64, err error) {
+	err = client.try(ctx, func(c Client) (err error) {
+		balance, err = c.Balance(ctx, address, confirmations)
+		return
+	})
+	return
+}
+
+func (client *failoverClient) BalanceDetails(ctx context.Context, address string) (confirmed, unconfirmed int64, err error) {
+	err = client.try(ctx, func(c Client) (err error) {
+		confirmed, unconfirmed, err = c.BalanceDetails(ctx, address)
+		return
+	})
+	return
+}
+
+func (client *failoverClient) ScriptSpent(ctx context.Context, address string) (spent bool, err error) {
+	err = client.try(ctx, func(c Client) (err error) {
+		spent, err = c.ScriptSpent(ctx, address)
+		return
+	})
+	return
+}
+
+func (client *failoverClient) HasBeenUsed(ctx context.Context, address string) (used bool, err error) {
+	err = client.try(ctx, func(c Client) (err error) {
+		used, err = c.HasBeenUsed(ctx, address)
+		return
+	})
+	return
+}
+
+func (client *failoverClient) BalanceDelta(ctx context.Context, address string, fromHeight, toHeight int64) (delta int64, err error) {
+	err = client.try(ctx, func(c Client) (err error) {
+		delta, err = c.BalanceDelta(ctx, address, fromHeight, toHeight)
+		return
+	})
+	return
+}
+
+func (client *failoverClient) ScriptFunded(ctx context.Context, address string, value int64) (funded bool, received int64, err error) {
+	err = client.try(ctx, func(c Client) (err error) {
+		funded, received, err = c.ScriptFunded(ctx, address, value)
+		return
+	})
+	return
+}
+
+func (client *failoverClient) ScriptRedeemed(ctx context.Context, address string, value int64) (redeemed bool, balance int64, err error) {
+	err = client.try(ctx, func(c Client) (err error) {
+		redeemed, balance, err = c.ScriptRedeemed(ctx, address, value)
+		return
+	})
+	return
+}
+
+// GetScriptFromSpentP2SH waits for the address to be spent from, failing over
+// on every poll rather than waiting on a single client.
+func (client *failoverClient) GetScriptFromSpentP2SH(ctx context.Context, address string) ([]byte, error) {
+	if err := waitForSpend(ctx, client, address); err != nil {
+		return nil, err
+	}
+	return spendingScript(ctx, client, address)
+}
+
+func (client *failoverClient) Confirmations(ctx context.Context, txHash string) (confirmations int64, err error) {
+	err = client.try(ctx, func(c Client) (err error) {
+		confirmations, err = c.Confirmations(ctx, txHash)
+		return
+	})
+	return
+}
+
+func (client *failoverClient) GetTransactionStatus(ctx context.Context, txHash string) (status TxStatus, err error) {
+	err = client.try(ctx, func(c Client) (err error) {
+		status, err = c.GetTransactionStatus(ctx, txHash)
+		return
+	})
+	return
+}
+
+func (client *failoverClient) WaitForConfirmations(ctx context.Context, txHash string, n int64) error {
+	return waitForConfirmations(ctx, client, txHash, n)
+}
+
+func (client *failoverClient) GetBlockHeight(ctx context.Context) (height int64, err error) {
+	err = client.try(ctx, func(c Client) (err error) {
+		height, err = c.GetBlockHeight(ctx)
+		return
+	})
+	return
+}
+
+func (client *failoverClient) EstimateFeeRate(ctx context.Context, targetBlocks int) (feeRate int64, err error) {
+	err = client.try(ctx, func(c Client) (err error) {
+		feeRate, err = c.EstimateFeeRate(ctx, targetBlocks)
+		return
+	})
+	return
+}
+
+func (client *failoverClient) GetBlockHeader(ctx context.Context, hash string) (header BlockHeader, err error) {
+	err = client.try(ctx, func(c Client) (err error) {
+		header, err = c.GetBlockHeader(ctx, hash)
+		return
+	})
+	return
+}
+
+func (client *failoverClient) GetBlockHeaderByHeight(ctx context.Context, height int64) (header BlockHeader, err error) {
+	err = client.try(ctx, func(c Client) (err error) {
+		header, err = c.GetBlockHeaderByHeight(ctx, height)
+		return
+	})
+	return
+}
+
+func (client *failoverClient) FormatTransactionView(msg, txhash string) string {
+	return client.clients[0].FormatTransactionView(msg, txhash)
+}
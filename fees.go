@@ -0,0 +1,201 @@
+package libbtc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// RecommendedFees are the fee rates (in SAT per byte) recommended by the
+// mempool.space API for different confirmation targets.
+type RecommendedFees struct {
+	FastestFee  int64 `json:"fastestFee"`
+	HalfHourFee int64 `json:"halfHourFee"`
+	HourFee     int64 `json:"hourFee"`
+	EconomyFee  int64 `json:"economyFee"`
+	MinimumFee  int64 `json:"minimumFee"`
+}
+
+// FeeRate returns the recommended fee rate for a transaction to confirm
+// within targetBlocks blocks. A target of 1 block uses the fastest fee, a
+// target of up to 3 blocks uses the half hour fee, a target of up to 6 blocks
+// uses the hour fee, a target of up to a day (144 blocks) uses the economy
+// fee, and longer targets use the minimum fee. Tiers that are not recommended
+// fall back to the next shorter tier.
+func (fees RecommendedFees) FeeRate(targetBlocks int) int64 {
+	switch {
+	case targetBlocks <= 1:
+		return fees.FastestFee
+	case targetBlocks <= 3:
+		return fees.HalfHourFee
+	case targetBlocks <= 6 || fees.EconomyFee <= 0:
+		return fees.HourFee
+	case targetBlocks <= 144 || fees.MinimumFee <= 0:
+		return fees.EconomyFee
+	default:
+		return fees.MinimumFee
+	}
+}
+
+// feeRatesEstimator is implemented by clients that fetch the estimated fee
+// rates of every confirmation target with a single request.
+type feeRatesEstimator interface {
+	// estimateFeeRates returns a function that returns the estimated fee
+	// rate (in SAT per byte) for a confirmation target.
+	estimateFeeRates(ctx context.Context) (func(targetBlocks int) int64, error)
+}
+
+// WithFeeURL estimates fee rates using the mempool.space API at the url,
+// which must not have a trailing slash, instead of the public mempool.space
+// API of the network.
+func WithFeeURL(url string) ClientOption {
+	return func(client *client) {
+		client.feeURL = url
+	}
+}
+
+// EstimateFeeRate returns the fee rate recommended by the mempool.space API
+// of the network. There is no public API for regtest, so its URL must be
+// given using WithFeeURL.
+func (client *client) EstimateFeeRate(ctx context.Context, targetBlocks int) (int64, error) {
+	feeRates, err := client.estimateFeeRates(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return feeRates(targetBlocks), nil
+}
+
+func (client *client) estimateFeeRates(ctx context.Context) (func(targetBlocks int) int64, error) {
+	if client.feeURL == "" {
+		return nil, ErrFeeEstimateUnavailable
+	}
+	fees := RecommendedFees{}
+	err := client.backoff(ctx, func() (*http.Response, error) {
+		resp, err := client.get(ctx, fmt.Sprintf("%s/v1/fees/recommended", client.feeURL))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		respBytes, err := readBody(resp)
+		if err != nil {
+			return resp, err
+		}
+		return resp, json.Unmarshal(respBytes, &fees)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func(targetBlocks int) int64 {
+		return minFeeRate(fees.FeeRate(targetBlocks))
+	}, nil
+}
+
+// EstimateFeeRate returns the fee rate estimated by Esplora for the largest
+// confirmation target that is at most targetBlocks, or for the smallest
+// target if there is none.
+func (client *esploraClient) EstimateFeeRate(ctx context.Context, targetBlocks int) (int64, error) {
+	feeRates, err := client.estimateFeeRates(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return feeRates(targetBlocks), nil
+}
+
+func (client *esploraClient) estimateFeeRates(ctx context.Context) (func(targetBlocks int) int64, error) {
+	estimates := map[string]float64{}
+	if err := client.getJSON(ctx, "/fee-estimates", &estimates); err != nil {
+		return nil, err
+	}
+	targets := []int{}
+	for target := range estimates {
+		n, err := strconv.Atoi(target)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, n)
+	}
+	if len(targets) == 0 {
+		return nil, ErrFeeEstimateUnavailable
+	}
+	sort.Ints(targets)
+	return func(targetBlocks int) int64 {
+		chosen := targets[0]
+		for _, target := range targets {
+			if target <= targetBlocks {
+				chosen = target
+			}
+		}
+		return minFeeRate(int64(math.Ceil(estimates[strconv.Itoa(chosen)])))
+	}, nil
+}
+
+// EstimateFeeRate returns the fee rate estimated by estimatesmartfee, which
+// requires the node to have seen enough transactions to make an estimate.
+func (client *rpcClient) EstimateFeeRate(ctx context.Context, targetBlocks int) (int64, error) {
+	estimate := struct {
+		FeeRate float64 `json:"feerate"`
+	}{}
+	if err := client.call(ctx, "estimatesmartfee", []interface{}{targetBlocks}, &estimate); err != nil {
+		return 0, err
+	}
+	if estimate.FeeRate <= 0 {
+		return 0, ErrFeeEstimateUnavailable
+	}
+	// The node estimates BTC per kilobyte.
+	return minFeeRate(int64(math.Ceil(estimate.FeeRate * 1e8 / 1000))), nil
+}
+
+// blockInterval is the expected time between blocks.
+const blockInterval = 10 * time.Minute
+
+// confirmationTargets are the confirmation targets (in blocks) checked by
+// EstimateConfirmationTime, from the shortest to the longest.
+var confirmationTargets = []int{1, 2, 3, 6, 12, 24, 48, 144, 1008}
+
+// EstimateConfirmationTime returns roughly how long a transaction paying
+// feeRate (in SAT per byte) will take to confirm. It finds the shortest
+// confirmation target for which the client estimates a fee rate of at most
+// feeRate, and assumes a block every 10 minutes. Clients that estimate every
+// target with a single request are only asked once. This is only a
+// best-effort estimate: fee estimates change with the mempool, and blocks are
+// not found at regular intervals, so the transaction can take much longer (or
+// less time) to confirm. ErrFeeRateTooLow is returned if feeRate is below the
+// estimate for the longest target.
+func (account *account) EstimateConfirmationTime(ctx context.Context, feeRate int64) (time.Duration, error) {
+	estimate := func(targetBlocks int) (int64, error) {
+		return account.EstimateFeeRate(ctx, targetBlocks)
+	}
+	if estimator, ok := account.Client.(feeRatesEstimator); ok {
+		feeRates, err := estimator.estimateFeeRates(ctx)
+		if err != nil {
+			return 0, err
+		}
+		estimate = func(targetBlocks int) (int64, error) {
+			return feeRates(targetBlocks), nil
+		}
+	}
+	for _, target := range confirmationTargets {
+		estimated, err := estimate(target)
+		if err != nil {
+			return 0, err
+		}
+		if feeRate >= estimated {
+			return time.Duration(target) * blockInterval, nil
+		}
+	}
+	return 0, ErrFeeRateTooLow
+}
+
+// minFeeRate returns the fee rate, but at least the minimum relay fee rate of
+// 1 SAT per byte.
+func minFeeRate(feeRate int64) int64 {
+	if feeRate < 1 {
+		return 1
+	}
+	return feeRate
+}
@@ -0,0 +1,63 @@
+package libbtc
+
+import (
+	"github.com/btcsuite/btcutil/hdkeychain"
+)
+
+// The BIP43 purposes supported by NewHDAccount.
+const (
+	// PurposeLegacy derives keys for P2PKH addresses (BIP44).
+	PurposeLegacy = 44
+
+	// PurposeP2SHSegWit derives keys for P2SH-wrapped SegWit addresses
+	// (BIP49).
+	PurposeP2SHSegWit = 49
+
+	// PurposeNativeSegWit derives keys for native SegWit addresses (BIP84).
+	PurposeNativeSegWit = 84
+)
+
+// NewHDAccount returns the account for the key at the derivation path
+// m/purpose'/coin_type'/account'/change/index of the given seed, which is
+// connected to a Bitcoin client. The coin type is the HDCoinType of the
+// network params of the client (0 on mainnet, and 1 on testnet and regtest),
+// so that forks of Bitcoin derive keys at their registered path. The purpose
+// must be one of PurposeLegacy, PurposeP2SHSegWit or PurposeNativeSegWit, and
+// sets the AddressType of the account.
+func NewHDAccount(client Client, seed []byte, purpose, account, change, index uint32) (Account, error) {
+	var addressType AddressType
+	switch purpose {
+	case PurposeLegacy:
+		addressType = AddressTypeLegacy
+	case PurposeP2SHSegWit:
+		addressType = AddressTypeP2SHSegWit
+	case PurposeNativeSegWit:
+		addressType = AddressTypeNativeSegWit
+	default:
+		return nil, NewErrUnsupportedPurpose(purpose)
+	}
+	coinType := client.NetworkParams().HDCoinType
+
+	key, err := hdkeychain.NewMaster(seed, client.NetworkParams())
+	if err != nil {
+		return nil, err
+	}
+	path := []uint32{
+		hdkeychain.HardenedKeyStart + purpose,
+		hdkeychain.HardenedKeyStart + coinType,
+		hdkeychain.HardenedKeyStart + account,
+		change,
+		index,
+	}
+	for _, child := range path {
+		key, err = key.Child(child)
+		if err != nil {
+			return nil, err
+		}
+	}
+	privKey, err := key.ECPrivKey()
+	if err != nil {
+		return nil, err
+	}
+	return NewAccount(client, privKey.ToECDSA(), WithAddressType(addressType)), nil
+}
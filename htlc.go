@@ -0,0 +1,256 @@
+package libbtc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// HashFunc is the hash function used by an HTLC to lock its secret.
+type HashFunc uint8
+
+const (
+	// SHA256 locks the secret with its SHA256 hash (OP_SHA256).
+	SHA256 HashFunc = iota
+
+	// Hash160 locks the secret with the RIPEMD160 hash of its SHA256 hash
+	// (OP_HASH160).
+	Hash160
+
+	// DoubleSHA256 locks the secret with the SHA256 hash of its SHA256 hash
+	// (OP_HASH256).
+	DoubleSHA256
+)
+
+// Size returns the size of the hashes produced by the hash function.
+func (hashFunc HashFunc) Size() int {
+	if hashFunc == Hash160 {
+		return 20
+	}
+	return 32
+}
+
+func (hashFunc HashFunc) opcode() byte {
+	switch hashFunc {
+	case Hash160:
+		return txscript.OP_HASH160
+	case DoubleSHA256:
+		return txscript.OP_HASH256
+	default:
+		return txscript.OP_SHA256
+	}
+}
+
+// SecretHash returns the hash of the secret that an HTLC built with the same
+// hash function is locked with.
+func SecretHash(secret []byte, hashFunc HashFunc) []byte {
+	switch hashFunc {
+	case Hash160:
+		return btcutil.Hash160(secret)
+	case DoubleSHA256:
+		return chainhash.DoubleHashB(secret)
+	default:
+		hash := sha256.Sum256(secret)
+		return hash[:]
+	}
+}
+
+// HTLCRedeem returns a function that adds the data required to redeem an
+// HTLC with the secret to a signature script. It is meant to be used as the
+// signature script callback of SendTransaction.
+func HTLCRedeem(secret []byte) func(*txscript.ScriptBuilder) {
+	return func(builder *txscript.ScriptBuilder) {
+		builder.AddData(secret)
+		builder.AddOp(txscript.OP_TRUE)
+	}
+}
+
+// HTLCRefund returns a function that adds the data required to refund an
+// HTLC to a signature script. It is meant to be used as the signature script
+// callback of SendTransaction.
+func HTLCRefund() func(*txscript.ScriptBuilder) {
+	return func(builder *txscript.ScriptBuilder) {
+		builder.AddOp(txscript.OP_FALSE)
+	}
+}
+
+// ExtractSecretFromSpend returns the secret pushed by a signature script that
+// redeemed an HTLC locked with the secret hash and hash function.
+func ExtractSecretFromSpend(sigScript []byte, secretHash []byte, hashFunc HashFunc) ([]byte, error) {
+	pushes, err := txscript.PushedData(sigScript)
+	if err != nil {
+		return nil, err
+	}
+	for _, push := range pushes {
+		if bytes.Equal(SecretHash(push, hashFunc), secretHash) {
+			return push, nil
+		}
+	}
+	return nil, ErrSecretNotFound
+}
+
+// ExtractSecret returns the secret whose SHA256 hash is secretHash, pushed by
+// the signature script that spent from the P2SH contract address. It waits
+// for the contract to be spent in the same way as GetScriptFromSpentP2SH. An
+// error wrapping ErrSecretNotFound is returned if the contract was spent
+// without revealing the secret, such as when it was refunded.
+func (account *account) ExtractSecret(ctx context.Context, contractAddress string, secretHash [32]byte) ([]byte, error) {
+	sigScript, err := account.GetScriptFromSpentP2SH(ctx, contractAddress)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := ExtractSecretFromSpend(sigScript, secretHash[:], SHA256)
+	if err == ErrSecretNotFound {
+		return nil, NewErrSecretNotFound(contractAddress)
+	}
+	return secret, err
+}
+
+// BuildHTLC returns a hash time-locked contract that can be redeemed by the
+// redeemer with a secret whose SHA256 hash is secretHash, or refunded to the
+// refunder once the locktime has passed. The script follows the layout used
+// by the reference atomic swap implementations, so that contracts are
+// compatible across clients:
+//
+//	OP_IF
+//	    OP_SIZE 32 OP_EQUALVERIFY OP_SHA256 <secretHash> OP_EQUALVERIFY
+//	    OP_DUP OP_HASH160 <redeemer>
+//	OP_ELSE
+//	    <locktime> OP_CHECKLOCKTIMEVERIFY OP_DROP
+//	    OP_DUP OP_HASH160 <refunder>
+//	OP_ENDIF
+//	OP_EQUALVERIFY OP_CHECKSIG
+//
+// Both the redeemer and the refunder must be P2PKH addresses.
+func BuildHTLC(secretHash [32]byte, redeemer, refunder btcutil.Address, locktime int64) ([]byte, error) {
+	return BuildHTLCWithHash(secretHash[:], SHA256, redeemer, refunder, locktime)
+}
+
+// BuildHTLCWithHash returns the same contract as BuildHTLC, but locks the
+// secret using the given hash function. The secret hash should be computed
+// using SecretHash with the same hash function.
+func BuildHTLCWithHash(secretHash []byte, hashFunc HashFunc, redeemer, refunder btcutil.Address, locktime int64) ([]byte, error) {
+	if len(secretHash) != hashFunc.Size() {
+		return nil, NewErrInvalidSecretHash(len(secretHash), hashFunc.Size())
+	}
+	redeemerPKH, ok := redeemer.(*btcutil.AddressPubKeyHash)
+	if !ok {
+		return nil, NewErrUnsupportedAddress(redeemer.EncodeAddress())
+	}
+	refunderPKH, ok := refunder.(*btcutil.AddressPubKeyHash)
+	if !ok {
+		return nil, NewErrUnsupportedAddress(refunder.EncodeAddress())
+	}
+
+	b := txscript.NewScriptBuilder()
+	b.AddOp(txscript.OP_IF)
+	{
+		b.AddOp(txscript.OP_SIZE)
+		b.AddInt64(32)
+		b.AddOp(txscript.OP_EQUALVERIFY)
+		b.AddOp(hashFunc.opcode())
+		b.AddData(secretHash)
+		b.AddOp(txscript.OP_EQUALVERIFY)
+		b.AddOp(txscript.OP_DUP)
+		b.AddOp(txscript.OP_HASH160)
+		b.AddData(redeemerPKH.Hash160()[:])
+	}
+	b.AddOp(txscript.OP_ELSE)
+	{
+		b.AddInt64(locktime)
+		b.AddOp(txscript.OP_CHECKLOCKTIMEVERIFY)
+		b.AddOp(txscript.OP_DROP)
+		b.AddOp(txscript.OP_DUP)
+		b.AddOp(txscript.OP_HASH160)
+		b.AddData(refunderPKH.Hash160()[:])
+	}
+	b.AddOp(txscript.OP_ENDIF)
+	b.AddOp(txscript.OP_EQUALVERIFY)
+	b.AddOp(txscript.OP_CHECKSIG)
+	return b.Script()
+}
+
+// VerifyRefundTx checks that a serialized refund transaction, usually
+// pre-signed by a counterparty, can refund the HTLC contract to the expected
+// address once the expected locktime has passed. The contract must be built
+// by BuildHTLC (or be compatible with it) and refund to the expected address
+// at the expected locktime. The transaction must spend the contract, use the
+// expected locktime, enable the locktime with a non-final input sequence, and
+// pay at least minRefundValue to the expected address. The minimum should be
+// the value of the contract less the most that the refund is allowed to pay
+// in fees, so that a refund cannot send most of the contract to someone else.
+func (account *account) VerifyRefundTx(rawRefund []byte, contract []byte, expectedRefundAddress string, expectedLocktime, minRefundValue int64) error {
+	msgTx := wire.NewMsgTx(2)
+	if err := msgTx.Deserialize(bytes.NewReader(rawRefund)); err != nil {
+		return err
+	}
+
+	pushes, err := txscript.ExtractAtomicSwapDataPushes(0, contract)
+	if err != nil {
+		return err
+	}
+	if pushes == nil {
+		return NewErrInvalidRefund("contract is not an HTLC")
+	}
+	if pushes.LockTime != expectedLocktime {
+		return NewErrInvalidRefund(fmt.Sprintf("contract locktime %d does not match %d", pushes.LockTime, expectedLocktime))
+	}
+	refundAddress, err := btcutil.DecodeAddress(expectedRefundAddress, account.NetworkParams())
+	if err != nil {
+		return err
+	}
+	refundPKH, ok := refundAddress.(*btcutil.AddressPubKeyHash)
+	if !ok {
+		return NewErrUnsupportedAddress(expectedRefundAddress)
+	}
+	if !bytes.Equal(pushes.RefundHash160[:], refundPKH.Hash160()[:]) {
+		return NewErrInvalidRefund("contract does not refund to " + expectedRefundAddress)
+	}
+
+	if int64(msgTx.LockTime) != expectedLocktime {
+		return NewErrInvalidRefund(fmt.Sprintf("transaction locktime %d does not match %d", msgTx.LockTime, expectedLocktime))
+	}
+	spendsContract := false
+	for _, txIn := range msgTx.TxIn {
+		data, err := txscript.PushedData(txIn.SignatureScript)
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 || !bytes.Equal(data[len(data)-1], contract) {
+			continue
+		}
+		// OP_CHECKLOCKTIMEVERIFY fails if the input is final.
+		if txIn.Sequence == wire.MaxTxInSequenceNum {
+			return NewErrInvalidRefund("input sequence disables the locktime")
+		}
+		spendsContract = true
+	}
+	if !spendsContract {
+		return NewErrInvalidRefund("no input spends the contract")
+	}
+
+	refundScript, err := txscript.PayToAddrScript(refundAddress)
+	if err != nil {
+		return err
+	}
+	refunded, paysRefundAddress := int64(0), false
+	for _, txOut := range msgTx.TxOut {
+		if bytes.Equal(txOut.PkScript, refundScript) {
+			refunded = refunded + txOut.Value
+			paysRefundAddress = true
+		}
+	}
+	if !paysRefundAddress {
+		return NewErrInvalidRefund("no output pays to " + expectedRefundAddress)
+	}
+	if refunded < minRefundValue {
+		return NewErrInvalidRefund(fmt.Sprintf("refund of %d SAT is less than %d SAT", refunded, minRefundValue))
+	}
+	return nil
+}
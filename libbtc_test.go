@@ -6,19 +6,32 @@ import (
 	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	. "github.com/republicprotocol/libbtc-go"
+	"github.com/republicprotocol/libbtc-go/mock"
 
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/btcsuite/btcutil/psbt"
 	"github.com/tyler-smith/go-bip39"
 )
 
@@ -138,20 +151,48 @@ var _ = Describe("LibBTC", func() {
 			initialBalance, err := secondaryAccount.Balance(context.Background(), secAddr.String(), 0)
 			Expect(err).Should(BeNil())
 			// building a transaction to transfer bitcoin to the secondary address
-			_, err = mainAccount.Transfer(context.Background(), secAddr.String(), 10000)
+			_, err = mainAccount.Transfer(context.Background(), secAddr.String(), 10000, 1000, false)
 			Expect(err).Should(BeNil())
 			finalBalance, err := secondaryAccount.Balance(context.Background(), secAddr.String(), 0)
 			Expect(err).Should(BeNil())
 			Expect(finalBalance - initialBalance).Should(Equal(int64(10000)))
 		})
 
+		It("should fund and sweep a nested P2WPKH address", func() {
+			mainAccount, _ := getAccounts()
+			mainAddr, err := mainAccount.Address()
+			Expect(err).Should(BeNil())
+			key, err := loadKey(49, 1, 0, 0, 0) // "m/49'/1'/0'/0/0"
+			Expect(err).Should(BeNil())
+			nestedAccount := NewAccount(mainAccount, key, WithAddressType(AddressTypeP2SHSegWit))
+			nestedAddr, err := nestedAccount.Address()
+			Expect(err).Should(BeNil())
+			Expect(nestedAddr.EncodeAddress()[0]).Should(Equal(byte('2')))
+
+			_, err = mainAccount.Transfer(context.Background(), nestedAddr.EncodeAddress(), 20000, 1000, false)
+			Expect(err).Should(BeNil())
+			txHash, err := nestedAccount.Sweep(context.Background(), mainAddr.EncodeAddress(), 10)
+			Expect(err).Should(BeNil())
+			serialized, err := nestedAccount.GetSerializedTransaction(context.Background(), txHash)
+			Expect(err).Should(BeNil())
+			msgTx, err := DecodeTransaction(serialized)
+			Expect(err).Should(BeNil())
+			for _, txIn := range msgTx.TxIn {
+				Expect(txIn.SignatureScript).ShouldNot(BeEmpty())
+				Expect(txIn.Witness).Should(HaveLen(2))
+			}
+			balance, err := nestedAccount.Balance(context.Background(), nestedAddr.EncodeAddress(), 0)
+			Expect(err).Should(BeNil())
+			Expect(balance).Should(Equal(int64(0)))
+		})
+
 		It("should deposit 50000 SAT to the contract address", func() {
 			_, payToContractPublicKey, contractAddress := getContractDetails(secret)
 			mainAccount, secondaryAccount := getAccounts()
 			initialBalance, err := secondaryAccount.Balance(context.Background(), contractAddress.EncodeAddress(), 0)
 			Expect(err).Should(BeNil())
 			// building a transaction to transfer bitcoin to the secondary address
-			err = mainAccount.SendTransaction(
+			_, err = mainAccount.SendTransaction(
 				context.Background(),
 				nil,
 				10000, // fee
@@ -191,7 +232,7 @@ var _ = Describe("LibBTC", func() {
 			P2PKHScript, err := txscript.PayToAddrScript(secondaryAddress)
 			Expect(err).Should(BeNil())
 			// building a transaction to transfer bitcoin to the secondary address
-			err = secondaryAccount.SendTransaction(
+			_, err = secondaryAccount.SendTransaction(
 				context.Background(),
 				contract,
 				10000, // fee
@@ -240,7 +281,3375 @@ var _ = Describe("LibBTC", func() {
 				}
 			}
 			Expect(success).Should(BeTrue())
+			extracted, err := mainAccount.ExtractSecret(context.Background(), contractAddress.EncodeAddress(), sha256.Sum256(secret[:]))
+			Expect(err).Should(BeNil())
+			Expect(extracted).Should(Equal(secret[:]))
 		})
 	})
 
-})
+	Context("when classifying transactions", func() {
+		It("should return the values received and sent by my addresses", func() {
+			key, err := ecdsa.GenerateKey(btcec.S256(), rand.Reader)
+			Expect(err).Should(BeNil())
+			account := NewAccount(NewBlockchainInfoClient("testnet"), key)
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, _, contractAddress := getContractDetails(secret)
+			addrScript, err := txscript.PayToAddrScript(addr)
+			Expect(err).Should(BeNil())
+			contractScript, err := txscript.PayToAddrScript(contractAddress)
+			Expect(err).Should(BeNil())
+
+			tx := Transaction{
+				Inputs: []Input{
+					{PrevOut: PreviousOut{Address: addr.EncodeAddress(), Value: 30000}},
+					{PrevOut: PreviousOut{Address: "someone else", Value: 20000}},
+				},
+				Outputs: []Output{
+					{Value: 10000, Script: hex.EncodeToString(addrScript)},
+					{Value: 5000, Script: hex.EncodeToString(contractScript)},
+					{Value: 2500, Script: hex.EncodeToString(addrScript)},
+				},
+			}
+			received, sent, err := account.ClassifyTransaction(context.Background(), tx, map[string]bool{
+				addr.EncodeAddress():            true,
+				contractAddress.EncodeAddress(): true,
+			})
+			Expect(err).Should(BeNil())
+			Expect(received).Should(Equal(map[string]int64{
+				addr.EncodeAddress():            12500,
+				contractAddress.EncodeAddress(): 5000,
+			}))
+			Expect(sent).Should(Equal(map[string]int64{
+				addr.EncodeAddress(): 30000,
+			}))
+		})
+	})
+
+	Context("when selecting coins", func() {
+		utxos := []UnspentOutput{
+			{TransactionHash: "a", ScriptPubKey: "a", Amount: 30000},
+			{TransactionHash: "b", ScriptPubKey: "a", Amount: 20000},
+			{TransactionHash: "c", ScriptPubKey: "b", Amount: 60000},
+			{TransactionHash: "d", ScriptPubKey: "c", Amount: 10000},
+		}
+
+		It("should prefer spending from a single address", func() {
+			selected, err := PrivacyAwareSelector().Select(utxos, 45000)
+			Expect(err).Should(BeNil())
+			Expect(selected).Should(Equal(utxos[:2]))
+		})
+
+		It("should spend every output of the chosen address", func() {
+			selected, err := PrivacyAwareSelector().Select(utxos, 25000)
+			Expect(err).Should(BeNil())
+			Expect(selected).Should(Equal(utxos[:2]))
+		})
+
+		It("should avoid partial spends when funding from an account", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			for _, value := range []int64{50000, 30000, 20000} {
+				_, err = client.Fund(addr.EncodeAddress(), value)
+				Expect(err).Should(BeNil())
+			}
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+
+			summary := TxSummary{}
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 10000, 1000, false, WithCoinSelector(PrivacyAwareSelector()), WithTxSummary(&summary))
+			Expect(err).Should(BeNil())
+			Expect(summary.Inputs).Should(HaveLen(3))
+			Expect(summary.Change).Should(Equal(int64(89000)))
+		})
+
+		It("should combine as few addresses as possible", func() {
+			selected, err := PrivacyAwareSelector().Select(utxos, 100000)
+			Expect(err).Should(BeNil())
+			Expect(selected).Should(ConsistOf(utxos[0], utxos[1], utxos[2]))
+		})
+
+		It("should fail when the target cannot be covered", func() {
+			_, err := PrivacyAwareSelector().Select(utxos, 200000)
+			Expect(err).Should(Equal(ErrInsufficientUnspentOutputs))
+		})
+
+		It("should spend the largest or smallest outputs first", func() {
+			selected, err := LargestFirstSelector().Select(utxos, 70000)
+			Expect(err).Should(BeNil())
+			Expect(selected).Should(Equal([]UnspentOutput{utxos[2], utxos[0]}))
+			selected, err = SmallestFirstSelector().Select(utxos, 25000)
+			Expect(err).Should(BeNil())
+			Expect(selected).Should(Equal([]UnspentOutput{utxos[3], utxos[1]}))
+		})
+
+		It("should find outputs that avoid change", func() {
+			selected, err := BranchAndBoundSelector(0).Select(utxos, 40000)
+			Expect(err).Should(BeNil())
+			Expect(selected).Should(ConsistOf(utxos[0], utxos[3]))
+			selected, err = BranchAndBoundSelector(1000).Select(utxos, 89500)
+			Expect(err).Should(BeNil())
+			Expect(selected).Should(ConsistOf(utxos[2], utxos[0]))
+			// Without an exact match, the largest outputs are spent.
+			selected, err = BranchAndBoundSelector(0).Select(utxos, 55000)
+			Expect(err).Should(BeNil())
+			Expect(selected).Should(Equal([]UnspentOutput{utxos[2]}))
+		})
+	})
+
+	Context("when building HTLC contracts", func() {
+		var secretHash [32]byte
+		var redeemer, refunder btcutil.Address
+
+		BeforeEach(func() {
+			copy(secretHash[:], bytes.Repeat([]byte{0x11}, 32))
+			var err error
+			redeemer, err = btcutil.NewAddressPubKeyHash(bytes.Repeat([]byte{0x22}, 20), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			refunder, err = btcutil.NewAddressPubKeyHash(bytes.Repeat([]byte{0x33}, 20), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+		})
+
+		vectors := []struct {
+			locktime int64
+			contract string
+		}{
+			{
+				1600000000,
+				"6382012088a8201111111111111111111111111111111111111111111111111111111111111111" +
+					"8876a9142222222222222222222222222222222222222222670400105e5fb17576a914" +
+					"33333333333333333333333333333333333333336888ac",
+			},
+			{
+				100000,
+				"6382012088a8201111111111111111111111111111111111111111111111111111111111111111" +
+					"8876a91422222222222222222222222222222222222222226703a08601b17576a914" +
+					"33333333333333333333333333333333333333336888ac",
+			},
+		}
+
+		It("should match the reference contracts", func() {
+			for _, vector := range vectors {
+				contract, err := BuildHTLC(secretHash, redeemer, refunder, vector.locktime)
+				Expect(err).Should(BeNil())
+				Expect(hex.EncodeToString(contract)).Should(Equal(vector.contract))
+			}
+		})
+
+		It("should reject addresses that are not P2PKH", func() {
+			scriptAddress, err := btcutil.NewAddressScriptHash([]byte{txscript.OP_TRUE}, &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			_, err = BuildHTLC(secretHash, scriptAddress, refunder, 100000)
+			Expect(err).ShouldNot(BeNil())
+		})
+	})
+
+	Context("when accumulating signatures", func() {
+		It("should build a valid multisig signature script", func() {
+			accounts := make([]Account, 3)
+			pubKeys := make([]*btcutil.AddressPubKey, 3)
+			for i := range accounts {
+				key, err := ecdsa.GenerateKey(btcec.S256(), rand.Reader)
+				Expect(err).Should(BeNil())
+				accounts[i] = NewAccount(NewBlockchainInfoClient("testnet"), key)
+				pubKey, err := accounts[i].SerializedPublicKey()
+				Expect(err).Should(BeNil())
+				pubKeys[i], err = btcutil.NewAddressPubKey(pubKey, &chaincfg.TestNet3Params)
+				Expect(err).Should(BeNil())
+			}
+			redeemScript, err := txscript.MultiSigScript(pubKeys, 2)
+			Expect(err).Should(BeNil())
+			scriptAddress, err := btcutil.NewAddressScriptHash(redeemScript, &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			pkScript, err := txscript.PayToAddrScript(scriptAddress)
+			Expect(err).Should(BeNil())
+
+			msgTx := wire.NewMsgTx(2)
+			msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, 0), nil, nil))
+			msgTx.AddTxOut(wire.NewTxOut(10000, pkScript))
+
+			Expect(accounts[0].AddSignature(msgTx, 1, redeemScript)).Should(Equal(NewErrInputIndex(1, 1)))
+			Expect(FinalizeSignatures(msgTx, -1, redeemScript)).Should(Equal(NewErrInputIndex(-1, 1)))
+
+			// Sign out of order to check that the signatures are reordered.
+			Expect(accounts[2].AddSignature(msgTx, 0, redeemScript)).Should(BeNil())
+			Expect(FinalizeSignatures(msgTx, 0, redeemScript)).ShouldNot(BeNil())
+			Expect(accounts[0].AddSignature(msgTx, 0, redeemScript)).Should(BeNil())
+			Expect(accounts[0].AddSignature(msgTx, 0, redeemScript)).Should(BeNil())
+			Expect(FinalizeSignatures(msgTx, 0, redeemScript)).Should(BeNil())
+
+			engine, err := txscript.NewEngine(pkScript, msgTx, 0, txscript.StandardVerifyFlags, nil, nil, 20000)
+			Expect(err).Should(BeNil())
+			Expect(engine.Execute()).Should(BeNil())
+		})
+
+		It("should combine signatures from independent signers", func() {
+			accounts := make([]Account, 3)
+			pubKeys := make([][]byte, 3)
+			for i := range accounts {
+				key, err := ecdsa.GenerateKey(btcec.S256(), rand.Reader)
+				Expect(err).Should(BeNil())
+				accounts[i] = NewAccount(NewBlockchainInfoClient("testnet"), key)
+				pubKeys[i], err = accounts[i].SerializedPublicKey()
+				Expect(err).Should(BeNil())
+			}
+			_, err := BuildMultisigScript(pubKeys, 4)
+			Expect(err).Should(Equal(NewErrInvalidMultisig(4, 3)))
+			redeemScript, err := BuildMultisigScript(pubKeys, 2)
+			Expect(err).Should(BeNil())
+			_, err = MultisigAddress(pubKeys[0], &chaincfg.TestNet3Params)
+			Expect(err).Should(Equal(ErrNotMultisig))
+			scriptAddress, err := MultisigAddress(redeemScript, &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			pkScript, err := txscript.PayToAddrScript(scriptAddress)
+			Expect(err).Should(BeNil())
+
+			msgTx := wire.NewMsgTx(2)
+			msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, 0), nil, nil))
+			msgTx.AddTxOut(wire.NewTxOut(10000, pkScript))
+
+			sigs := [][]byte{}
+			for _, i := range []int{2, 1} {
+				sig, err := accounts[i].SignMultisigInput(msgTx, 0, redeemScript)
+				Expect(err).Should(BeNil())
+				sigs = append(sigs, sig)
+			}
+			Expect(CombineSignatures(msgTx, 0, redeemScript, sigs[:1])).Should(Equal(NewErrNotEnoughSignatures(2, 1)))
+			Expect(CombineSignatures(msgTx, 0, redeemScript, sigs)).Should(BeNil())
+
+			engine, err := txscript.NewEngine(pkScript, msgTx, 0, txscript.StandardVerifyFlags, nil, nil, 10000)
+			Expect(err).Should(BeNil())
+			Expect(engine.Execute()).Should(BeNil())
+		})
+	})
+
+	Context("when verifying refund transactions", func() {
+		buildRefund := func(contract []byte, to btcutil.Address, locktime uint32, sequence uint32, value int64) []byte {
+			script, err := txscript.PayToAddrScript(to)
+			Expect(err).Should(BeNil())
+			builder := txscript.NewScriptBuilder()
+			builder.AddData(bytes.Repeat([]byte{0x01}, 72))
+			builder.AddData(bytes.Repeat([]byte{0x02}, 33))
+			builder.AddOp(txscript.OP_FALSE)
+			builder.AddData(contract)
+			sigScript, err := builder.Script()
+			Expect(err).Should(BeNil())
+			msgTx := wire.NewMsgTx(2)
+			msgTx.LockTime = locktime
+			msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, 0), sigScript, nil))
+			msgTx.TxIn[0].Sequence = sequence
+			msgTx.AddTxOut(wire.NewTxOut(value, script))
+			buf := new(bytes.Buffer)
+			Expect(msgTx.Serialize(buf)).Should(BeNil())
+			return buf.Bytes()
+		}
+
+		It("should accept a valid refund and reject invalid ones", func() {
+			mainAccount, secondaryAccount := getAccounts()
+			me, err := mainAccount.Address()
+			Expect(err).Should(BeNil())
+			other, err := secondaryAccount.Address()
+			Expect(err).Should(BeNil())
+			contract, err := BuildHTLC(sha256.Sum256(secret[:]), other, me, 1600000000)
+			Expect(err).Should(BeNil())
+
+			refund := buildRefund(contract, me, 1600000000, 0xfffffffe, 10000)
+			Expect(mainAccount.VerifyRefundTx(refund, contract, me.EncodeAddress(), 1600000000, 9000)).Should(BeNil())
+			Expect(mainAccount.VerifyRefundTx(refund, contract, me.EncodeAddress(), 1600000001, 9000)).ShouldNot(BeNil())
+			Expect(mainAccount.VerifyRefundTx(refund, contract, other.EncodeAddress(), 1600000000, 9000)).ShouldNot(BeNil())
+
+			finalRefund := buildRefund(contract, me, 1600000000, 0xffffffff, 10000)
+			Expect(mainAccount.VerifyRefundTx(finalRefund, contract, me.EncodeAddress(), 1600000000, 9000)).ShouldNot(BeNil())
+			stolenRefund := buildRefund(contract, other, 1600000000, 0xfffffffe, 10000)
+			Expect(mainAccount.VerifyRefundTx(stolenRefund, contract, me.EncodeAddress(), 1600000000, 9000)).ShouldNot(BeNil())
+
+			// A refund that pays the refund address less than the minimum
+			// sends the rest of the contract elsewhere.
+			Expect(mainAccount.VerifyRefundTx(refund, contract, me.EncodeAddress(), 1600000000, 10001)).ShouldNot(BeNil())
+			strandingRefund := buildRefund(contract, me, 1600000000, 0xfffffffe, 546)
+			Expect(mainAccount.VerifyRefundTx(strandingRefund, contract, me.EncodeAddress(), 1600000000, 9000)).ShouldNot(BeNil())
+		})
+	})
+
+	Context("when hashing secrets", func() {
+		It("should redeem and extract the secret with every hash function", func() {
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			pubKey := key.PubKey().SerializeCompressed()
+			redeemer, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(pubKey), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+
+			for _, hashFunc := range []HashFunc{SHA256, Hash160, DoubleSHA256} {
+				secretHash := SecretHash(secret[:], hashFunc)
+				Expect(secretHash).Should(HaveLen(hashFunc.Size()))
+				contract, err := BuildHTLCWithHash(secretHash, hashFunc, redeemer, redeemer, 100000)
+				Expect(err).Should(BeNil())
+				contractAddress, err := btcutil.NewAddressScriptHash(contract, &chaincfg.TestNet3Params)
+				Expect(err).Should(BeNil())
+				pkScript, err := txscript.PayToAddrScript(contractAddress)
+				Expect(err).Should(BeNil())
+
+				msgTx := wire.NewMsgTx(2)
+				msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, 0), nil, nil))
+				msgTx.AddTxOut(wire.NewTxOut(10000, pkScript))
+				sig, err := txscript.RawTxInSignature(msgTx, 0, contract, txscript.SigHashAll, key)
+				Expect(err).Should(BeNil())
+				builder := txscript.NewScriptBuilder()
+				builder.AddData(sig)
+				builder.AddData(pubKey)
+				HTLCRedeem(secret[:])(builder)
+				builder.AddData(contract)
+				msgTx.TxIn[0].SignatureScript, err = builder.Script()
+				Expect(err).Should(BeNil())
+
+				engine, err := txscript.NewEngine(pkScript, msgTx, 0, txscript.StandardVerifyFlags, nil, nil, 20000)
+				Expect(err).Should(BeNil())
+				Expect(engine.Execute()).Should(BeNil())
+
+				extracted, err := ExtractSecretFromSpend(msgTx.TxIn[0].SignatureScript, secretHash, hashFunc)
+				Expect(err).Should(BeNil())
+				Expect(extracted).Should(Equal(secret[:]))
+			}
+		})
+
+		It("should reject secret hashes of the wrong size", func() {
+			_, _, contractAddress := getContractDetails(secret)
+			_, err := BuildHTLCWithHash(SecretHash(secret[:], SHA256), Hash160, contractAddress, contractAddress, 100000)
+			Expect(err).ShouldNot(BeNil())
+		})
+	})
+
+	Context("when selecting change addresses", func() {
+		It("should cycle through the addresses", func() {
+			selector := RoundRobinChange("a", "b")
+			for _, address := range []string{"a", "b", "a"} {
+				outputs, err := selector.SelectChange(1000)
+				Expect(err).Should(BeNil())
+				Expect(outputs).Should(Equal([]ChangeOutput{{Address: address, Value: 1000}}))
+			}
+		})
+
+		It("should split the change by weight", func() {
+			outputs, err := WeightedChange([]string{"a", "b", "c"}, []int64{1, 2, 0}).SelectChange(1000)
+			Expect(err).Should(BeNil())
+			Expect(outputs).Should(Equal([]ChangeOutput{{Address: "a", Value: 334}, {Address: "b", Value: 666}}))
+		})
+
+		It("should not create change outputs that are dust", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			change1, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("change1")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			change2, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("change2")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+
+			summary := TxSummary{}
+			selector := WeightedChange([]string{change1.EncodeAddress(), change2.EncodeAddress()}, []int64{99, 1})
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 89000, 1000, false, WithChangeAddresses(selector), WithTxSummary(&summary))
+			Expect(err).Should(BeNil())
+			Expect(summary.Change).Should(Equal(int64(10000)))
+			Expect(summary.OutputValues).Should(ConsistOf(int64(89000), int64(10000)))
+		})
+	})
+
+	Context("when handling payment URIs", func() {
+		It("should build payment URIs", func() {
+			Expect(BuildPaymentURI("mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn", 0, "", "")).Should(Equal("bitcoin:mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn"))
+			Expect(BuildPaymentURI("mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn", 2030000, "Luke Jr", "Donation & thanks")).Should(Equal(
+				"bitcoin:mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn?amount=0.0203&label=Luke%20Jr&message=Donation%20%26%20thanks"))
+		})
+
+		It("should parse payment URIs", func() {
+			address, amount, label, err := ParsePaymentURI("bitcoin:mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn?amount=20.3&label=Luke-Jr")
+			Expect(err).Should(BeNil())
+			Expect(address).Should(Equal("mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn"))
+			Expect(amount).Should(Equal(int64(2030000000)))
+			Expect(label).Should(Equal("Luke-Jr"))
+
+			uri := BuildPaymentURI("mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn", 1, "a b", "")
+			_, amount, label, err = ParsePaymentURI(uri)
+			Expect(err).Should(BeNil())
+			Expect(amount).Should(Equal(int64(1)))
+			Expect(label).Should(Equal("a b"))
+		})
+
+		It("should reject invalid payment URIs", func() {
+			for _, uri := range []string{
+				"litecoin:mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn",
+				"bitcoin:mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn?amount=0.000000001",
+				"bitcoin:mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn?amount=-1",
+				"bitcoin:mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn?amount=-0.5",
+				"bitcoin:mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn?amount=0.+5",
+				"bitcoin:mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn?amount=+1",
+				"bitcoin:mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn?amount=1.",
+				"bitcoin:mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn?amount=.5",
+				"bitcoin:mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn?req-somethingyoudontunderstand=50",
+			} {
+				_, _, _, err := ParsePaymentURI(uri)
+				Expect(err).ShouldNot(BeNil())
+			}
+		})
+	})
+
+	Context("when deriving HD accounts", func() {
+		mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+		It("should derive the BIP44 reference address", func() {
+			seed := bip39.NewSeed(mnemonic, "")
+			account, err := NewHDAccount(NewBlockchainInfoClient("mainnet"), seed, PurposeLegacy, 0, 0, 0)
+			Expect(err).Should(BeNil())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			Expect(addr.EncodeAddress()).Should(Equal("1LqBGSKuX5yYUonjxT5qGfpUsXKYYWeabA"))
+		})
+
+		It("should derive different accounts from the same seed", func() {
+			seed := bip39.NewSeed(mnemonic, "")
+			first, err := NewHDAccount(NewBlockchainInfoClient("testnet"), seed, PurposeLegacy, 0, 0, 0)
+			Expect(err).Should(BeNil())
+			second, err := NewHDAccount(NewBlockchainInfoClient("testnet"), seed, PurposeLegacy, 1, 0, 0)
+			Expect(err).Should(BeNil())
+			firstAddr, err := first.Address()
+			Expect(err).Should(BeNil())
+			secondAddr, err := second.Address()
+			Expect(err).Should(BeNil())
+			Expect(firstAddr.EncodeAddress()).ShouldNot(Equal(secondAddr.EncodeAddress()))
+		})
+
+		It("should reject unsupported purposes", func() {
+			_, err := NewHDAccount(NewBlockchainInfoClient("testnet"), bip39.NewSeed(mnemonic, ""), 45, 0, 0, 0)
+			Expect(err).ShouldNot(BeNil())
+		})
+	})
+
+	Context("when signing with a Signer", func() {
+		It("should sign exactly like an account holding the private key", func() {
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			signer := &countingSigner{key: key}
+			client := NewBlockchainInfoClient("testnet")
+			keyAccount := NewAccount(client, key.ToECDSA())
+			signerAccount := NewAccountWithSigner(client, signer)
+
+			keyAddress, err := keyAccount.Address()
+			Expect(err).Should(BeNil())
+			signerAddress, err := signerAccount.Address()
+			Expect(err).Should(BeNil())
+			Expect(signerAddress.EncodeAddress()).Should(Equal(keyAddress.EncodeAddress()))
+
+			pkScript, err := txscript.PayToAddrScript(keyAddress)
+			Expect(err).Should(BeNil())
+			keyTx := wire.NewMsgTx(2)
+			keyTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, 0), nil, nil))
+			keyTx.AddTxOut(wire.NewTxOut(10000, pkScript))
+			signerTx := keyTx.Copy()
+			Expect(keyAccount.AddSignature(keyTx, 0, pkScript)).Should(BeNil())
+			Expect(signerAccount.AddSignature(signerTx, 0, pkScript)).Should(BeNil())
+			Expect(signerTx.TxIn[0].SignatureScript).Should(Equal(keyTx.TxIn[0].SignatureScript))
+			Expect(signer.signs).Should(Equal(1))
+		})
+
+		It("should publish transactions signed by a remote signing function", func() {
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			hashes := 0
+			sign := func(hash []byte) ([]byte, error) {
+				hashes++
+				sig, err := key.Sign(hash)
+				if err != nil {
+					return nil, err
+				}
+				return sig.Serialize(), nil
+			}
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			account := NewAccountWithSigner(client, NewFuncSigner(key.PubKey().SerializeCompressed(), sign), WithAddressType(AddressTypeNativeSegWit))
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 40000, 1000, false, AllowUnconfirmed())
+			Expect(err).Should(BeNil())
+			Expect(client.Published()).Should(HaveLen(1))
+			Expect(hashes).Should(BeNumerically(">", 0))
+		})
+	})
+
+	Context("when using SegWit addresses", func() {
+		mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+		It("should derive the BIP49 and BIP84 reference addresses", func() {
+			seed := bip39.NewSeed(mnemonic, "")
+			account, err := NewHDAccount(NewBlockchainInfoClient("testnet"), seed, PurposeP2SHSegWit, 0, 0, 0)
+			Expect(err).Should(BeNil())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			Expect(addr.EncodeAddress()).Should(Equal("2Mww8dCYPUpKHofjgcXcBCEGmniw9CoaiD2"))
+
+			account, err = NewHDAccount(NewBlockchainInfoClient("mainnet"), seed, PurposeNativeSegWit, 0, 0, 0)
+			Expect(err).Should(BeNil())
+			addr, err = account.Address()
+			Expect(err).Should(BeNil())
+			Expect(addr.EncodeAddress()).Should(Equal("bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu"))
+		})
+
+		It("should sign transactions spending from every address type", func() {
+			for _, addressType := range []AddressType{AddressTypeLegacy, AddressTypeP2SHSegWit, AddressTypeNativeSegWit} {
+				key, err := btcec.NewPrivateKey(btcec.S256())
+				Expect(err).Should(BeNil())
+				client := &unspentClient{Client: NewBlockchainInfoClient("testnet")}
+				account := NewAccount(client, key.ToECDSA(), WithAddressType(addressType))
+				addr, err := account.Address()
+				Expect(err).Should(BeNil())
+				pkScript, err := txscript.PayToAddrScript(addr)
+				Expect(err).Should(BeNil())
+				client.utxos = Unspent{Outputs: []UnspentOutput{{
+					TransactionHash: hex.EncodeToString(chainhash.DoubleHashB([]byte("funding"))),
+					ScriptPubKey:    hex.EncodeToString(pkScript),
+					Amount:          100000,
+				}}}
+
+				stx, _, err := account.BuildAndSign(context.Background(), map[string]int64{addr.EncodeAddress(): 50000}, 1000)
+				Expect(err).Should(BeNil())
+				msgTx := wire.NewMsgTx(2)
+				Expect(msgTx.Deserialize(bytes.NewReader(stx))).Should(BeNil())
+				Expect(msgTx.HasWitness()).Should(Equal(addressType != AddressTypeLegacy))
+			}
+		})
+	})
+
+	Context("when talking to a Bitcoin Core node", func() {
+		It("should decode unspent outputs and map rejected transactions", func() {
+			txid := "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				req := struct {
+					Method string        `json:"method"`
+					Params []interface{} `json:"params"`
+				}{}
+				Expect(json.NewDecoder(r.Body).Decode(&req)).Should(BeNil())
+				switch req.Method {
+				case "listunspent":
+					// The node filters by the minimum confirmations.
+					if req.Params[0].(float64) > 10 {
+						fmt.Fprint(w, `{"result":[],"error":null}`)
+						return
+					}
+					fmt.Fprintf(w, `{"result":[{"txid":"%s","vout":1,"scriptPubKey":"00","amount":0.0005,"confirmations":10}],"error":null}`, txid)
+				case "sendrawtransaction":
+					w.WriteHeader(http.StatusInternalServerError)
+					fmt.Fprint(w, `{"result":null,"error":{"code":-26,"message":"min relay fee not met"}}`)
+				}
+			}))
+			defer server.Close()
+			client := NewRPCClient(server.URL, "user", "pass", &chaincfg.TestNet3Params)
+
+			utxos, err := client.GetUnspentOutputs(context.Background(), "address", 0, 10)
+			Expect(err).Should(BeNil())
+			Expect(utxos.Outputs).Should(HaveLen(1))
+			hash, err := chainhash.NewHashFromStr(txid)
+			Expect(err).Should(BeNil())
+			Expect(utxos.Outputs[0].TransactionHash).Should(Equal(hex.EncodeToString(hash[:])))
+			Expect(utxos.Outputs[0].Amount).Should(Equal(int64(50000)))
+			Expect(utxos.Outputs[0].Confirmations).Should(Equal(int64(10)))
+
+			utxos, err = client.GetUnspentOutputs(context.Background(), "address", 0, 11)
+			Expect(err).Should(BeNil())
+			Expect(utxos.Outputs).Should(BeEmpty())
+
+			err = client.PublishTransaction(context.Background(), []byte{})
+			Expect(err).Should(Equal(NewErrBitcoinSubmitTx("min relay fee not met")))
+		})
+
+		It("should retry calls that the node did not answer", func() {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				req := struct {
+					Method string `json:"method"`
+				}{}
+				Expect(json.NewDecoder(r.Body).Decode(&req)).Should(BeNil())
+				switch req.Method {
+				case "getblockcount":
+					if requests == 1 {
+						w.WriteHeader(http.StatusServiceUnavailable)
+						return
+					}
+					fmt.Fprint(w, `{"result":102,"error":null}`)
+				case "getrawtransaction":
+					w.WriteHeader(http.StatusInternalServerError)
+					fmt.Fprint(w, `{"result":null,"error":{"code":-5,"message":"No such mempool or blockchain transaction"}}`)
+				}
+			}))
+			defer server.Close()
+			retryNow := func(attempt int, err error, resp *http.Response) (bool, time.Duration) {
+				return true, 0
+			}
+			logger := &recordingLogger{}
+			client := NewRPCClient(server.URL, "user", "pass", &chaincfg.TestNet3Params, WithRetryPolicy(retryNow), WithLogger(logger))
+
+			height, err := client.GetBlockHeight(context.Background())
+			Expect(err).Should(BeNil())
+			Expect(height).Should(Equal(int64(102)))
+			Expect(requests).Should(Equal(2))
+			Expect(logger.messages).Should(HaveLen(1))
+
+			// Errors returned by the node are not retried.
+			_, err = client.Confirmations(context.Background(), strings.Repeat("00", 32))
+			Expect(errors.Is(err, ErrNotFound)).Should(BeTrue())
+			Expect(requests).Should(Equal(3))
+		})
+
+		It("should only fetch the transactions of the address", func() {
+			addr, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("address")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			script, err := txscript.PayToAddrScript(addr)
+			Expect(err).Should(BeNil())
+			other, err := txscript.PayToAddrScript(&btcutil.AddressPubKeyHash{})
+			Expect(err).Should(BeNil())
+			txs := map[string]string{
+				"funding":  fmt.Sprintf(`{"txid":"funding","blockhash":"block100","vin":[{"coinbase":"00"}],"vout":[{"value":0.0005,"n":0,"scriptPubKey":{"hex":"%x"}},{"value":0.0001,"n":1,"scriptPubKey":{"hex":"%x"}}]}`, script, other),
+				"spending": fmt.Sprintf(`{"txid":"spending","blockhash":"block101","vin":[{"txid":"funding","vout":0}],"vout":[{"value":0.0004,"n":0,"scriptPubKey":{"hex":"%x"}}]}`, other),
+				"other":    fmt.Sprintf(`{"txid":"other","vin":[{"txid":"funding","vout":1}],"vout":[{"value":0.0001,"n":0,"scriptPubKey":{"hex":"%x"}}]}`, other),
+			}
+			fetched := []string{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				req := struct {
+					Method string        `json:"method"`
+					Params []interface{} `json:"params"`
+				}{}
+				Expect(json.NewDecoder(r.Body).Decode(&req)).Should(BeNil())
+				switch req.Method {
+				case "listreceivedbyaddress":
+					Expect(req.Params[3]).Should(Equal(addr.EncodeAddress()))
+					fmt.Fprintf(w, `{"result":[{"address":"%s","txids":["funding"]}],"error":null}`, addr.EncodeAddress())
+				case "gettxout":
+					Expect(req.Params[:2]).Should(Equal([]interface{}{"funding", float64(0)}))
+					fmt.Fprint(w, `{"result":null,"error":null}`)
+				case "listtransactions":
+					fmt.Fprint(w, `{"result":[{"txid":"received","category":"receive"},{"txid":"spending","category":"send"},{"txid":"other","category":"send"}],"error":null}`)
+				case "getrawtransaction":
+					fetched = append(fetched, req.Params[0].(string))
+					fmt.Fprintf(w, `{"result":%s,"error":null}`, txs[req.Params[0].(string)])
+				case "getblockheader":
+					height := strings.TrimPrefix(req.Params[0].(string), "block")
+					fmt.Fprintf(w, `{"result":{"hash":"%s","height":%s},"error":null}`, req.Params[0], height)
+				}
+			}))
+			defer server.Close()
+			client := NewRPCClient(server.URL, "user", "pass", &chaincfg.TestNet3Params)
+
+			info, err := client.GetRawAddressInformation(context.Background(), addr.EncodeAddress())
+			Expect(err).Should(BeNil())
+			Expect(info.Received).Should(Equal(int64(50000)))
+			Expect(info.Sent).Should(Equal(int64(50000)))
+			Expect(info.Balance).Should(Equal(int64(0)))
+			Expect(info.Transactions).Should(HaveLen(2))
+			Expect(info.Transactions[0].TransactionHash).Should(Equal("spending"))
+			Expect(info.Transactions[0].BlockHeight).Should(Equal(int64(101)))
+			Expect(info.Transactions[1].TransactionHash).Should(Equal("funding"))
+			Expect(fetched).ShouldNot(ContainElement("received"))
+		})
+	})
+
+	Context("when talking to an Esplora API", func() {
+		It("should filter unspent outputs by their confirmations", func() {
+			txid := "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			addr, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(key.PubKey().SerializeCompressed()), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/address/" + addr.EncodeAddress() + "/utxo":
+					fmt.Fprintf(w, `[{"txid":"%s","vout":0,"value":50000,"status":{"confirmed":true,"block_height":100}},{"txid":"%s","vout":1,"value":20000,"status":{"confirmed":false}}]`, txid, txid)
+				case "/blocks/tip/height":
+					fmt.Fprint(w, "102")
+				case "/tx":
+					w.WriteHeader(http.StatusBadRequest)
+					fmt.Fprint(w, "bad-txns-inputs-missingorspent")
+				}
+			}))
+			defer server.Close()
+			noRetry := func(attempt int, err error, resp *http.Response) (bool, time.Duration) {
+				return false, 0
+			}
+			client := NewEsploraClient(server.URL, &chaincfg.TestNet3Params, WithRetryPolicy(noRetry))
+
+			balance, err := client.Balance(context.Background(), addr.EncodeAddress(), 0)
+			Expect(err).Should(BeNil())
+			Expect(balance).Should(Equal(int64(70000)))
+			confirmed, unconfirmed, err := client.BalanceDetails(context.Background(), addr.EncodeAddress())
+			Expect(err).Should(BeNil())
+			Expect(confirmed).Should(Equal(int64(50000)))
+			Expect(unconfirmed).Should(Equal(int64(20000)))
+			utxos, err := client.GetUnspentOutputs(context.Background(), addr.EncodeAddress(), 0, 1)
+			Expect(err).Should(BeNil())
+			Expect(utxos.Outputs).Should(HaveLen(1))
+			utxos, err = client.GetUnspentOutputs(context.Background(), addr.EncodeAddress(), 0, 4)
+			Expect(err).Should(BeNil())
+			Expect(utxos.Outputs).Should(BeEmpty())
+			utxos, err = client.GetUnspentOutputs(context.Background(), addr.EncodeAddress(), 0, 3)
+			Expect(err).Should(BeNil())
+			Expect(utxos.Outputs).Should(HaveLen(1))
+			Expect(utxos.Outputs[0].Confirmations).Should(Equal(int64(3)))
+			pkScript, err := txscript.PayToAddrScript(addr)
+			Expect(err).Should(BeNil())
+			Expect(utxos.Outputs[0].ScriptPubKey).Should(Equal(hex.EncodeToString(pkScript)))
+
+			err = client.PublishTransaction(context.Background(), []byte{})
+			Expect(err).Should(Equal(NewErrBitcoinSubmitTx("bad-txns-inputs-missingorspent")))
+		})
+
+		It("should report the status of transactions", func() {
+			confirmed := "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+			unconfirmed := "0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098"
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/tx/" + confirmed:
+					fmt.Fprintf(w, `{"txid":"%s","status":{"confirmed":true,"block_height":100}}`, confirmed)
+				case "/tx/" + unconfirmed:
+					fmt.Fprintf(w, `{"txid":"%s","status":{"confirmed":false}}`, unconfirmed)
+				case "/blocks/tip/height":
+					fmt.Fprint(w, "102")
+				default:
+					w.WriteHeader(http.StatusNotFound)
+					fmt.Fprint(w, "Transaction not found")
+				}
+			}))
+			defer server.Close()
+			noRetry := func(attempt int, err error, resp *http.Response) (bool, time.Duration) {
+				return false, 0
+			}
+			client := NewEsploraClient(server.URL, &chaincfg.TestNet3Params, WithRetryPolicy(noRetry))
+
+			status, err := client.GetTransactionStatus(context.Background(), confirmed)
+			Expect(err).Should(BeNil())
+			Expect(status).Should(Equal(TxStatus{Found: true, Confirmations: 3, BlockHeight: 100}))
+			status, err = client.GetTransactionStatus(context.Background(), unconfirmed)
+			Expect(err).Should(BeNil())
+			Expect(status).Should(Equal(TxStatus{Found: true, InMempool: true}))
+			status, err = client.GetTransactionStatus(context.Background(), strings.Repeat("00", 32))
+			Expect(err).Should(BeNil())
+			Expect(status).Should(Equal(TxStatus{}))
+
+			_, err = client.GetRawTransaction(context.Background(), strings.Repeat("00", 32))
+			Expect(errors.Is(err, ErrNotFound)).Should(BeTrue())
+		})
+	})
+
+	Context("when talking to the BlockCypher API", func() {
+		It("should convert unspent outputs and wait when rate limited", func() {
+			txid := "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				Expect(r.URL.Query().Get("token")).Should(Equal("token"))
+				switch r.URL.Path {
+				case "/addrs/addr":
+					if requests == 1 {
+						w.Header().Set("Retry-After", "1")
+						w.WriteHeader(http.StatusTooManyRequests)
+						return
+					}
+					Expect(r.URL.Query().Get("unspentOnly")).Should(Equal("true"))
+					w.Header().Set("X-Ratelimit-Remaining", "0")
+					fmt.Fprintf(w, `{"txrefs":[{"tx_hash":"%s","tx_output_n":1,"value":50000,"confirmations":3,"script":"abcd"}],"unconfirmed_txrefs":[{"tx_hash":"%s","tx_output_n":2,"value":20000,"confirmations":0}]}`, txid, txid)
+				case "/txs/push":
+					w.WriteHeader(http.StatusBadRequest)
+					fmt.Fprint(w, `{"error":"bad-txns-inputs-missingorspent"}`)
+				}
+			}))
+			defer server.Close()
+			retryNow := func(attempt int, err error, resp *http.Response) (bool, time.Duration) {
+				return attempt == 0, 0
+			}
+			client := NewBlockCypherClient("token", &chaincfg.TestNet3Params, WithURL(server.URL), WithRetryPolicy(retryNow))
+
+			start := time.Now()
+			utxos, err := client.GetUnspentOutputs(context.Background(), "addr", 0, 1)
+			Expect(err).Should(BeNil())
+			Expect(time.Since(start)).Should(BeNumerically(">=", time.Second))
+			Expect(utxos.Outputs).Should(HaveLen(1))
+			hash, err := chainhash.NewHashFromStr(txid)
+			Expect(err).Should(BeNil())
+			Expect(utxos.Outputs[0].TransactionHash).Should(Equal(hex.EncodeToString(hash[:])))
+			Expect(utxos.Outputs[0].TransactionOutputNumber).Should(Equal(uint32(1)))
+			Expect(utxos.Outputs[0].ScriptPubKey).Should(Equal("abcd"))
+
+			start = time.Now()
+			err = client.PublishTransaction(context.Background(), []byte{})
+			Expect(err).Should(Equal(NewErrBitcoinSubmitTx(`{"error":"bad-txns-inputs-missingorspent"}`)))
+			Expect(time.Since(start)).Should(BeNumerically(">=", 900*time.Millisecond))
+		})
+
+		It("should page transactions without requesting the same page forever", func() {
+			fullRequests := []string{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/addrs/addr/balance":
+					fmt.Fprint(w, `{"address":"addr","final_n_tx":4}`)
+				case "/addrs/addr/full":
+					before := r.URL.Query().Get("before")
+					fullRequests = append(fullRequests, before)
+					switch before {
+					case "":
+						fmt.Fprint(w, `{"txs":[{"hash":"u1","block_height":-1},{"hash":"u2","block_height":-1}],"hasMore":true}`)
+					case "101":
+						fmt.Fprint(w, `{"txs":[{"hash":"c1","block_height":100},{"hash":"c2","block_height":90}],"hasMore":true}`)
+					default:
+						// Every page of the block at height 90 is the same.
+						fmt.Fprint(w, `{"txs":[{"hash":"c2","block_height":90},{"hash":"c3","block_height":90}],"hasMore":true}`)
+					}
+				default:
+					fmt.Fprint(w, `{"height":100}`)
+				}
+			}))
+			defer server.Close()
+			client := NewBlockCypherClient("token", &chaincfg.TestNet3Params, WithURL(server.URL))
+
+			info, err := client.GetRawAddressInformation(context.Background(), "addr")
+			Expect(err).Should(BeNil())
+			hashes := []string{}
+			for _, tx := range info.Transactions {
+				hashes = append(hashes, tx.TransactionHash)
+			}
+			Expect(hashes).Should(Equal([]string{"u1", "u2", "c1", "c2", "c3"}))
+			Expect(fullRequests).Should(Equal([]string{"", "101", "91", "91"}))
+		})
+	})
+
+	Context("when failing over between clients", func() {
+		It("should move on to the next client and publish to all of them", func() {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer server.Close()
+			noRetry := func(attempt int, err error, resp *http.Response) (bool, time.Duration) {
+				return false, 0
+			}
+			esplora := NewEsploraClient(server.URL, &chaincfg.TestNet3Params, WithRetryPolicy(noRetry))
+			mockClient := mock.NewClient(&chaincfg.TestNet3Params)
+			client := NewFailoverClient(esplora, mockClient)
+			addr, err := btcutil.NewAddressPubKeyHash(make([]byte, 20), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			txid, err := mockClient.Fund(addr.EncodeAddress(), 20000)
+			Expect(err).Should(BeNil())
+			hash, err := chainhash.NewHashFromStr(txid)
+			Expect(err).Should(BeNil())
+
+			_, err = client.GetBlockHeight(context.Background())
+			Expect(err).Should(BeNil())
+			Expect(requests).Should(Equal(1))
+
+			msgTx := wire.NewMsgTx(2)
+			msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(hash, 0), nil, nil))
+			msgTx.AddTxOut(wire.NewTxOut(10000, []byte{txscript.OP_TRUE}))
+			buf := new(bytes.Buffer)
+			Expect(msgTx.Serialize(buf)).Should(BeNil())
+			Expect(client.PublishTransaction(context.Background(), buf.Bytes())).Should(BeNil())
+			Expect(requests).Should(Equal(2))
+
+			_, err = NewFailoverClientWithError(esplora, mock.NewClient(&chaincfg.MainNetParams))
+			Expect(err).Should(Equal(NewErrNetworkParamsMismatch(chaincfg.TestNet3Params.Name, chaincfg.MainNetParams.Name)))
+			_, err = NewFailoverClientWithError()
+			Expect(err).Should(Equal(ErrNoClients))
+		})
+	})
+
+	Context("when logging retries", func() {
+		It("should log failed requests to the Logger", func() {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				fmt.Fprint(w, "102")
+			}))
+			defer server.Close()
+			logger := &recordingLogger{}
+			retryNow := func(attempt int, err error, resp *http.Response) (bool, time.Duration) {
+				return attempt == 0, 0
+			}
+			client := NewEsploraClient(server.URL, &chaincfg.TestNet3Params, WithRetryPolicy(retryNow), WithLogger(logger))
+			_, err := client.Confirmations(context.Background(), "txid")
+			Expect(err).ShouldNot(BeNil())
+			Expect(logger.messages).Should(HaveLen(1))
+		})
+	})
+
+	Context("when a request exhausts its retry budget", func() {
+		It("should give up before the context is done", func() {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprint(w, "unavailable")
+			}))
+			defer server.Close()
+			retryNow := func(attempt int, err error, resp *http.Response) (bool, time.Duration) {
+				return true, time.Millisecond
+			}
+			client := NewEsploraClient(server.URL, &chaincfg.TestNet3Params, WithRetryPolicy(retryNow), WithRetryBudget(RetryBudget{MaxAttempts: 3}))
+			_, err := client.GetRawTransaction(context.Background(), "txid")
+			Expect(errors.Is(err, ErrRetryBudgetExhausted)).Should(BeTrue())
+			Expect(errors.Unwrap(err)).Should(Equal(NewErrUnexpectedStatus(http.StatusServiceUnavailable, "unavailable")))
+			Expect(requests).Should(Equal(3))
+
+			client = NewEsploraClient(server.URL, &chaincfg.TestNet3Params, WithRetryPolicy(retryNow), WithRetryBudget(RetryBudget{MaxDuration: 50 * time.Millisecond}))
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			start := time.Now()
+			err = client.PublishTransaction(ctx, []byte{})
+			Expect(errors.Is(err, ErrRetryBudgetExhausted)).Should(BeTrue())
+			Expect(time.Since(start)).Should(BeNumerically("<", time.Second))
+		})
+	})
+
+	Context("when backing off", func() {
+		It("should cap and jitter the delay between retries", func() {
+			policy := BackoffConfig{
+				InitialDelay: time.Second,
+				Multiplier:   2,
+				MaxDelay:     10 * time.Second,
+				Jitter:       0.2,
+				MaxRetries:   20,
+			}.RetryPolicy()
+			for attempt := 0; attempt < 20; attempt++ {
+				retry, delay := policy(attempt, nil, nil)
+				Expect(retry).Should(BeTrue())
+				expected := time.Duration(math.Min(float64(time.Second)*math.Pow(2, float64(attempt)), float64(10*time.Second)))
+				Expect(delay).Should(BeNumerically(">=", expected*8/10))
+				Expect(delay).Should(BeNumerically("<=", expected*12/10))
+			}
+			retry, _ := policy(20, nil, nil)
+			Expect(retry).Should(BeFalse())
+		})
+	})
+
+	Context("when requests fail", func() {
+		It("should only retry server errors and rate limits", func() {
+			statuses := []int{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if len(statuses) == 0 {
+					fmt.Fprint(w, "102")
+					return
+				}
+				w.WriteHeader(statuses[0])
+				fmt.Fprint(w, http.StatusText(statuses[0]))
+				statuses = statuses[1:]
+			}))
+			defer server.Close()
+			client := NewEsploraClient(server.URL, &chaincfg.TestNet3Params, WithBackoffConfig(BackoffConfig{InitialDelay: time.Millisecond}))
+
+			statuses = []int{http.StatusTooManyRequests, http.StatusInternalServerError}
+			header, err := client.GetBlockHeaderByHeight(context.Background(), 102)
+			Expect(err).ShouldNot(BeNil())
+			Expect(header.BlockHash).Should(BeEmpty())
+			Expect(statuses).Should(BeEmpty())
+
+			statuses = []int{http.StatusNotFound, http.StatusInternalServerError}
+			_, err = client.GetRawTransaction(context.Background(), "txid")
+			Expect(err).Should(Equal(NewErrUnexpectedStatus(http.StatusNotFound, "Not Found")))
+			Expect(statuses).Should(HaveLen(1))
+		})
+
+		It("should time out using the given HTTP client", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			}))
+			defer server.Close()
+			noRetry := func(int, error, *http.Response) (bool, time.Duration) { return false, 0 }
+			client := NewBlockchainInfoClient("testnet", WithURL(server.URL), WithRetryPolicy(noRetry), WithHTTPClient(&http.Client{Timeout: 10 * time.Millisecond}))
+
+			start := time.Now()
+			_, err := client.GetUnspentOutputs(context.Background(), "address", 0, 0)
+			Expect(err).ShouldNot(BeNil())
+			_, err = client.GetRawTransaction(context.Background(), "txid")
+			Expect(err).ShouldNot(BeNil())
+			Expect(time.Since(start)).Should(BeNumerically("<", time.Second))
+		})
+	})
+
+	Context("when paying many recipients", func() {
+		It("should report every invalid address", func() {
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(&unspentClient{Client: NewBlockchainInfoClient("testnet")}, key.ToECDSA())
+			_, err = account.SendMany(context.Background(), map[string]int64{
+				"1LqBGSKuX5yYUonjxT5qGfpUsXKYYWeabA": 10000,
+				"not an address":                     10000,
+			}, 1000)
+			Expect(err).Should(Equal(NewErrInvalidAddresses([]string{"1LqBGSKuX5yYUonjxT5qGfpUsXKYYWeabA", "not an address"})))
+		})
+
+		It("should pay every recipient in one transaction", func() {
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			client := &unspentClient{Client: NewBlockchainInfoClient("testnet")}
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			pkScript, err := txscript.PayToAddrScript(addr)
+			Expect(err).Should(BeNil())
+			client.utxos = Unspent{Outputs: []UnspentOutput{{
+				TransactionHash: hex.EncodeToString(chainhash.DoubleHashB([]byte("funding"))),
+				ScriptPubKey:    hex.EncodeToString(pkScript),
+				Amount:          100000,
+			}}}
+
+			outputs := map[string]int64{}
+			for i := 0; i < 3; i++ {
+				recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte{byte(i)}), &chaincfg.TestNet3Params)
+				Expect(err).Should(BeNil())
+				outputs[recipient.EncodeAddress()] = 10000
+			}
+			stx, _, err := account.BuildAndSign(context.Background(), outputs, 1000)
+			Expect(err).Should(BeNil())
+			msgTx := wire.NewMsgTx(2)
+			Expect(msgTx.Deserialize(bytes.NewReader(stx))).Should(BeNil())
+			Expect(msgTx.TxIn).Should(HaveLen(1))
+			// One output for each recipient, and one for the change.
+			Expect(msgTx.TxOut).Should(HaveLen(4))
+		})
+	})
+
+	Context("when sweeping", func() {
+		It("should refuse to sweep dust", func() {
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			client := &unspentClient{Client: NewBlockchainInfoClient("testnet")}
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			pkScript, err := txscript.PayToAddrScript(addr)
+			Expect(err).Should(BeNil())
+			client.utxos = Unspent{Outputs: []UnspentOutput{{
+				TransactionHash: hex.EncodeToString(chainhash.DoubleHashB([]byte("funding"))),
+				ScriptPubKey:    hex.EncodeToString(pkScript),
+				Amount:          700,
+			}}}
+
+			_, err = account.Sweep(context.Background(), addr.EncodeAddress(), 1)
+			Expect(err).Should(MatchError(ContainSubstring("dust")))
+		})
+	})
+
+	Context("when waiting for confirmations", func() {
+		It("should return once the transaction is deep enough", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/tx/txid/status":
+					fmt.Fprint(w, `{"confirmed":true,"block_height":100}`)
+				case "/blocks/tip/height":
+					fmt.Fprint(w, "105")
+				}
+			}))
+			defer server.Close()
+			client := NewEsploraClient(server.URL, &chaincfg.TestNet3Params)
+
+			height, err := client.GetBlockHeight(context.Background())
+			Expect(err).Should(BeNil())
+			Expect(height).Should(Equal(int64(105)))
+			Expect(client.WaitForConfirmations(context.Background(), "txid", 6)).Should(BeNil())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			Expect(client.WaitForConfirmations(ctx, "txid", 7)).Should(Equal(ErrTimedOut))
+		})
+
+		It("should cache the block height briefly", func() {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				fmt.Fprint(w, "105")
+			}))
+			defer server.Close()
+			client := NewEsploraClient(server.URL, &chaincfg.TestNet3Params)
+			for i := 0; i < 3; i++ {
+				height, err := client.GetBlockHeight(context.Background())
+				Expect(err).Should(BeNil())
+				Expect(height).Should(Equal(int64(105)))
+			}
+			Expect(requests).Should(Equal(1))
+		})
+	})
+
+	Context("when using the mock client", func() {
+		It("should reflect published transactions in balances", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			txHash, err := account.Transfer(context.Background(), recipient.EncodeAddress(), 40000, 1000, false)
+			Expect(err).Should(BeNil())
+			Expect(client.Published()).Should(HaveLen(1))
+
+			funded, value, err := client.ScriptFunded(context.Background(), recipient.EncodeAddress(), 40000)
+			Expect(err).Should(BeNil())
+			Expect(funded).Should(BeTrue())
+			Expect(value).Should(Equal(int64(40000)))
+			balance, err := client.Balance(context.Background(), addr.EncodeAddress(), 0)
+			Expect(err).Should(BeNil())
+			Expect(balance).Should(Equal(int64(59000)))
+			confirmed, unconfirmed, err := client.BalanceDetails(context.Background(), addr.EncodeAddress())
+			Expect(err).Should(BeNil())
+			Expect(confirmed).Should(Equal(int64(0)))
+			Expect(unconfirmed).Should(Equal(int64(59000)))
+
+			confirmations, err := client.Confirmations(context.Background(), txHash)
+			Expect(err).Should(BeNil())
+			Expect(confirmations).Should(Equal(int64(0)))
+			client.Mine(2)
+			confirmations, err = client.Confirmations(context.Background(), txHash)
+			Expect(err).Should(BeNil())
+			Expect(confirmations).Should(Equal(int64(2)))
+			confirmed, unconfirmed, err = client.BalanceDetails(context.Background(), addr.EncodeAddress())
+			Expect(err).Should(BeNil())
+			Expect(confirmed).Should(Equal(int64(59000)))
+			Expect(unconfirmed).Should(Equal(int64(0)))
+		})
+
+		It("should let outputs be moved once the transaction is funded", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			inputs := 0
+			moveChange := WithPostFund(func(msgTx *wire.MsgTx) {
+				inputs = len(msgTx.TxIn)
+				last := len(msgTx.TxOut) - 1
+				msgTx.TxOut = append(msgTx.TxOut[last:], msgTx.TxOut[:last]...)
+			})
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 40000, 1000, false, moveChange)
+			Expect(err).Should(BeNil())
+			Expect(inputs).Should(Equal(1))
+
+			msgTx := wire.NewMsgTx(2)
+			Expect(msgTx.Deserialize(bytes.NewReader(client.Published()[0]))).Should(BeNil())
+			Expect(msgTx.TxOut).Should(HaveLen(2))
+			changeScript, err := txscript.PayToAddrScript(addr)
+			Expect(err).Should(BeNil())
+			Expect(msgTx.TxOut[0].PkScript).Should(Equal(changeScript))
+			Expect(msgTx.TxOut[0].Value).Should(Equal(int64(59000)))
+		})
+
+		It("should sort inputs and outputs according to BIP69", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			txids := []string{}
+			for i := 0; i < 3; i++ {
+				txid, err := client.Fund(addr.EncodeAddress(), 30000)
+				Expect(err).Should(BeNil())
+				txids = append(txids, txid)
+			}
+			sort.Strings(txids)
+
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 80000, 1000, false, AllowUnconfirmed(), BIP69Sort())
+			Expect(err).Should(BeNil())
+
+			// Inputs are sorted by the hash of the transaction they spend,
+			// as it is displayed, and outputs by their value.
+			msgTx := wire.NewMsgTx(2)
+			Expect(msgTx.Deserialize(bytes.NewReader(client.Published()[0]))).Should(BeNil())
+			Expect(msgTx.TxIn).Should(HaveLen(3))
+			for i, txIn := range msgTx.TxIn {
+				Expect(txIn.PreviousOutPoint.Hash.String()).Should(Equal(txids[i]))
+			}
+			Expect(msgTx.TxOut).Should(HaveLen(2))
+			Expect(msgTx.TxOut[0].Value).Should(Equal(int64(9000)))
+			Expect(msgTx.TxOut[1].Value).Should(Equal(int64(80000)))
+		})
+
+		It("should refuse to sign for outputs misreported by the client", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			addr, err := NewAccount(client, key.ToECDSA()).Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+			utxos, err := client.GetUnspentOutputs(context.Background(), addr.EncodeAddress(), 0, 0)
+			Expect(err).Should(BeNil())
+			Expect(utxos.Outputs).Should(HaveLen(1))
+			utxos.Outputs[0].Amount = 200000
+
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			account := NewAccount(&unspentClient{Client: client, utxos: utxos}, key.ToECDSA())
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 150000, 1000, false, AllowUnconfirmed(), VerifyPrevOuts())
+			Expect(errors.Is(err, ErrPrevOutMismatch)).Should(BeTrue())
+			Expect(client.Published()).Should(BeEmpty())
+
+			account = NewAccount(client, key.ToECDSA())
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 40000, 1000, false, AllowUnconfirmed(), VerifyPrevOuts())
+			Expect(err).Should(BeNil())
+			Expect(client.Published()).Should(HaveLen(1))
+		})
+
+		It("should refuse to publish transactions with absurd fees", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000000)
+			Expect(err).Should(BeNil())
+
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 40000, 5000000, false, AllowUnconfirmed())
+			Expect(errors.Is(err, ErrFeeTooHigh)).Should(BeTrue())
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 40000, 1000, false, AllowUnconfirmed(), MaxFee(500))
+			Expect(errors.Is(err, ErrFeeTooHigh)).Should(BeTrue())
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 40000, 1000, false, AllowUnconfirmed(), MaxFeeRate(1))
+			Expect(errors.Is(err, ErrFeeTooHigh)).Should(BeTrue())
+			Expect(client.Published()).Should(BeEmpty())
+
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 40000, 5000000, false, AllowUnconfirmed(), MaxFeeRate(-1))
+			Expect(err).Should(BeNil())
+			Expect(client.Published()).Should(HaveLen(1))
+		})
+
+		It("should reject transactions spending missing outputs", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			msgTx := wire.NewMsgTx(2)
+			msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), nil, nil))
+			msgTx.AddTxOut(wire.NewTxOut(1000, nil))
+			buf := new(bytes.Buffer)
+			Expect(msgTx.Serialize(buf)).Should(BeNil())
+			Expect(client.PublishTransaction(context.Background(), buf.Bytes())).ShouldNot(BeNil())
+			Expect(client.Published()).Should(BeEmpty())
+		})
+	})
+
+	Context("when spending from a contract", func() {
+		It("should return the change to the signer", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			contract, err := txscript.PayToAddrScript(addr)
+			Expect(err).Should(BeNil())
+			contractAddr, err := account.ContractAddress(contract)
+			Expect(err).Should(BeNil())
+			Expect(contractAddr.IsForNet(&chaincfg.TestNet3Params)).Should(BeTrue())
+			Expect(contractAddr.ScriptAddress()).Should(Equal(btcutil.Hash160(contract)))
+			_, err = client.Fund(contractAddr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			recipientScript, err := txscript.PayToAddrScript(recipient)
+			Expect(err).Should(BeNil())
+			result := TxResult{}
+			summary := TxSummary{}
+			_, err = account.SendTransaction(context.Background(), contract, 1000, nil, func(msgTx *wire.MsgTx) bool {
+				msgTx.AddTxOut(wire.NewTxOut(40000, recipientScript))
+				return true
+			}, nil, nil, WithTxResult(&result), WithTxSummary(&summary))
+			Expect(err).Should(BeNil())
+			Expect(result.Change).Should(Equal(int64(59000)))
+			Expect(summary).Should(Equal(result.TxSummary))
+			Expect(summary.Inputs).Should(HaveLen(1))
+			Expect(summary.TotalInput).Should(Equal(int64(100000)))
+			Expect(summary.OutputValues).Should(ConsistOf(int64(40000), int64(59000)))
+			Expect(summary.TotalOutput).Should(Equal(int64(99000)))
+			Expect(summary.Fee).Should(Equal(int64(1000)))
+
+			contractBalance, err := client.Balance(context.Background(), contractAddr.EncodeAddress(), 0)
+			Expect(err).Should(BeNil())
+			Expect(contractBalance).Should(Equal(int64(0)))
+			balance, err := client.Balance(context.Background(), addr.EncodeAddress(), 0)
+			Expect(err).Should(BeNil())
+			Expect(balance).Should(Equal(int64(59000)))
+		})
+
+		It("should spend a hashlock without a signature", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			secret := []byte("secret")
+			secretHash := sha256.Sum256(secret)
+			contract, err := txscript.NewScriptBuilder().AddOp(txscript.OP_SHA256).AddData(secretHash[:]).AddOp(txscript.OP_EQUAL).Script()
+			Expect(err).Should(BeNil())
+			contractAddr, err := btcutil.NewAddressScriptHash(contract, &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(contractAddr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+
+			_, err = account.SendTransaction(context.Background(), contract, 1000, nil, nil, nil, nil, WithScriptSig(func(sig, pubKey []byte, builder *txscript.ScriptBuilder) {
+				builder.AddData(secret)
+			}))
+			Expect(err).Should(BeNil())
+			contractBalance, err := client.Balance(context.Background(), contractAddr.EncodeAddress(), 0)
+			Expect(err).Should(BeNil())
+			Expect(contractBalance).Should(Equal(int64(0)))
+			published, err := DecodeTransaction(client.Published()[0])
+			Expect(err).Should(BeNil())
+			pushes, err := txscript.PushedData(published.TxIn[0].SignatureScript)
+			Expect(err).Should(BeNil())
+			Expect(pushes).Should(Equal([][]byte{secret, contract}))
+
+			extracted, err := account.ExtractSecret(context.Background(), contractAddr.EncodeAddress(), secretHash)
+			Expect(err).Should(BeNil())
+			Expect(extracted).Should(Equal(secret))
+			_, err = account.ExtractSecret(context.Background(), contractAddr.EncodeAddress(), sha256.Sum256([]byte("other")))
+			Expect(errors.Is(err, ErrSecretNotFound)).Should(BeTrue())
+		})
+	})
+
+	Context("when creating dust outputs", func() {
+		It("should compute the dust threshold of each script type", func() {
+			p2pkh, err := btcutil.NewAddressPubKeyHash(make([]byte, 20), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			p2pkhScript, err := txscript.PayToAddrScript(p2pkh)
+			Expect(err).Should(BeNil())
+			Expect(DustThreshold(p2pkhScript)).Should(Equal(int64(546)))
+			p2wpkh, err := btcutil.NewAddressWitnessPubKeyHash(make([]byte, 20), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			p2wpkhScript, err := txscript.PayToAddrScript(p2wpkh)
+			Expect(err).Should(BeNil())
+			Expect(DustThreshold(p2wpkhScript)).Should(Equal(int64(294)))
+		})
+
+		It("should refuse to send dust and drop dust change", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 500, 1000, false)
+			Expect(err).Should(Equal(NewErrDustOutput(500, 546)))
+
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 98800, 1000, false)
+			Expect(err).Should(BeNil())
+			msgTx := wire.NewMsgTx(2)
+			Expect(msgTx.Deserialize(bytes.NewReader(client.Published()[0]))).Should(BeNil())
+			Expect(msgTx.TxOut).Should(HaveLen(1))
+		})
+	})
+
+	Context("when embedding data", func() {
+		It("should add an OP_RETURN output", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+
+			data := sha256.Sum256([]byte("anchor"))
+			_, err = account.SendWithData(context.Background(), addr.EncodeAddress(), 10000, data[:], 1000)
+			Expect(err).Should(BeNil())
+			msgTx := wire.NewMsgTx(2)
+			Expect(msgTx.Deserialize(bytes.NewReader(client.Published()[0]))).Should(BeNil())
+			pushes, err := txscript.PushedData(msgTx.TxOut[1].PkScript)
+			Expect(err).Should(BeNil())
+			Expect(txscript.GetScriptClass(msgTx.TxOut[1].PkScript)).Should(Equal(txscript.NullDataTy))
+			Expect(pushes).Should(Equal([][]byte{data[:]}))
+			Expect(msgTx.TxOut[1].Value).Should(Equal(int64(0)))
+		})
+
+		It("should refuse data over the relay limit", func() {
+			Expect(AddDataOutput(wire.NewMsgTx(2), make([]byte, 81))).Should(Equal(NewErrDataTooLarge(81)))
+		})
+	})
+
+	Context("when bumping fees", func() {
+		It("should replace the transaction with a higher fee", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+
+			txHash, err := account.Transfer(context.Background(), recipient.EncodeAddress(), 40000, 1000, false, ReplaceByFee())
+			Expect(err).Should(BeNil())
+			replaceable, err := account.IsReplaceable(context.Background(), txHash)
+			Expect(err).Should(BeNil())
+			Expect(replaceable).Should(BeTrue())
+			bumpedHash, err := account.BumpFee(context.Background(), txHash, 20)
+			Expect(err).Should(BeNil())
+			Expect(bumpedHash).ShouldNot(Equal(txHash))
+
+			bumped, err := client.GetRawTransaction(context.Background(), bumpedHash)
+			Expect(err).Should(BeNil())
+			var in, out int64
+			for _, input := range bumped.Inputs {
+				in = in + int64(input.PrevOut.Value)
+			}
+			for _, output := range bumped.Outputs {
+				out = out + int64(output.Value)
+			}
+			// The size of a signature varies by a byte between signings.
+			Expect(in - out).Should(BeNumerically("~", 20*bumped.Size, 40))
+			funded, value, err := client.ScriptFunded(context.Background(), recipient.EncodeAddress(), 40000)
+			Expect(err).Should(BeNil())
+			Expect(funded).Should(BeTrue())
+			Expect(value).Should(Equal(int64(40000)))
+			_, err = client.GetRawTransaction(context.Background(), txHash)
+			Expect(err).ShouldNot(BeNil())
+			client.Mine(1)
+			replaceable, err = account.IsReplaceable(context.Background(), bumpedHash)
+			Expect(err).Should(BeNil())
+			Expect(replaceable).Should(BeFalse())
+		})
+
+		It("should refuse to bump transactions without opt-in", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+
+			txHash, err := account.Transfer(context.Background(), addr.EncodeAddress(), 40000, 1000, false)
+			Expect(err).Should(BeNil())
+			replaceable, err := account.IsReplaceable(context.Background(), txHash)
+			Expect(err).Should(BeNil())
+			Expect(replaceable).Should(BeFalse())
+			_, err = account.BumpFee(context.Background(), txHash, 20)
+			Expect(err).Should(Equal(ErrNotReplaceable))
+		})
+	})
+
+	Context("when bumping fees with a child", func() {
+		It("should pay for the parent and the child", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			senderKey, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			sender := NewAccount(client, senderKey.ToECDSA())
+			senderAddr, err := sender.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(senderAddr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+
+			parentHash, err := sender.Transfer(context.Background(), addr.EncodeAddress(), 50000, 100, false)
+			Expect(err).Should(BeNil())
+			_, err = account.BumpWithChild(context.Background(), parentHash, 1, 10)
+			Expect(err).Should(Equal(NewErrForeignInput(senderAddr.EncodeAddress())))
+			childHash, err := account.BumpWithChild(context.Background(), parentHash, 0, 10)
+			Expect(err).Should(BeNil())
+
+			parent, err := client.GetRawTransaction(context.Background(), parentHash)
+			Expect(err).Should(BeNil())
+			child, err := client.GetRawTransaction(context.Background(), childHash)
+			Expect(err).Should(BeNil())
+			childFee := 50000 - int64(child.Outputs[0].Value)
+			// The size of a signature varies by a byte between signings.
+			Expect(100 + childFee).Should(BeNumerically("~", 10*(parent.Size+child.Size), 20))
+		})
+	})
+
+	Context("when importing a WIF", func() {
+		It("should honour the compression flag", func() {
+			client := mock.NewClient(&chaincfg.MainNetParams)
+			compressed, err := NewAccountFromWIF(client, "KwDiBf89QgGbjEhKnhXJuH7LrciVrZi3qYjgd9M7rFU73sVHnoWn")
+			Expect(err).Should(BeNil())
+			addr, err := compressed.Address()
+			Expect(err).Should(BeNil())
+			Expect(addr.EncodeAddress()).Should(Equal("1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH"))
+
+			uncompressed, err := NewAccountFromWIF(client, "5HpHagT65TZzG1PH3CSu63k8DbpvD8s5ip4nEB3kEsreAnchuDf")
+			Expect(err).Should(BeNil())
+			addr, err = uncompressed.Address()
+			Expect(err).Should(BeNil())
+			Expect(addr.EncodeAddress()).Should(Equal("1EHNa6Q4Jz2uvNExL497mE43ikXhwF6kZm"))
+		})
+
+		It("should refuse a WIF for another network", func() {
+			_, err := NewAccountFromWIF(mock.NewClient(&chaincfg.TestNet3Params), "KwDiBf89QgGbjEhKnhXJuH7LrciVrZi3qYjgd9M7rFU73sVHnoWn")
+			Expect(err).Should(Equal(NewErrNetworkMismatch("testnet3")))
+		})
+	})
+
+	Context("when serializing public keys", func() {
+		It("should recognise copies of the network params", func() {
+			params := chaincfg.MainNetParams
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(mock.NewClient(&params), key.ToECDSA())
+			pubKey, err := account.SerializedPublicKey()
+			Expect(err).Should(BeNil())
+			Expect(pubKey).Should(Equal(key.PubKey().SerializeCompressed()))
+		})
+
+		It("should derive the same address on every network by default", func() {
+			key, _ := btcec.PrivKeyFromBytes(btcec.S256(), []byte{1})
+			mainnet, err := NewAccount(mock.NewClient(&chaincfg.MainNetParams), key.ToECDSA()).Address()
+			Expect(err).Should(BeNil())
+			Expect(mainnet.EncodeAddress()).Should(Equal("1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH"))
+			testnet, err := NewAccount(mock.NewClient(&chaincfg.TestNet3Params), key.ToECDSA()).Address()
+			Expect(err).Should(BeNil())
+			Expect(testnet.EncodeAddress()).Should(Equal("mrCDrCybB6J1vRfbwM5hemdJz73FwDBC8r"))
+			Expect(testnet.ScriptAddress()).Should(Equal(mainnet.ScriptAddress()))
+		})
+
+		It("should derive uncompressed addresses when configured", func() {
+			key, _ := btcec.PrivKeyFromBytes(btcec.S256(), []byte{1})
+			addr, err := NewAccount(mock.NewClient(&chaincfg.MainNetParams), key.ToECDSA(), Compressed(false)).Address()
+			Expect(err).Should(BeNil())
+			Expect(addr.EncodeAddress()).Should(Equal("1EHNa6Q4Jz2uvNExL497mE43ikXhwF6kZm"))
+		})
+
+		It("should use the configured compression", func() {
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(mock.NewClient(&chaincfg.MainNetParams), key.ToECDSA(), Compressed(false))
+			pubKey, err := account.SerializedPublicKey()
+			Expect(err).Should(BeNil())
+			Expect(pubKey).Should(Equal(key.PubKey().SerializeUncompressed()))
+		})
+	})
+
+	Context("when scanning blocks", func() {
+		It("should call back with each block on the main chain", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				height := strings.TrimPrefix(r.URL.Path, "/block-height/")
+				fmt.Fprintf(w, `{"blocks":[{"hash":"stale%s","height":%s,"main_chain":false},{"hash":"block%s","height":%s,"main_chain":true,"tx":[{"hash":"tx%s"}]}]}`, height, height, height, height, height)
+			}))
+			defer server.Close()
+			client, ok := NewClientWithParams(server.URL, &chaincfg.MainNetParams).(BlockClient)
+			Expect(ok).Should(BeTrue())
+
+			hashes := []string{}
+			err := client.ScanBlocks(context.Background(), 100, 102, func(block Block) error {
+				Expect(block.Transactions).Should(HaveLen(1))
+				Expect(block.Transactions[0].TransactionHash).Should(Equal(fmt.Sprintf("tx%d", block.Height)))
+				hashes = append(hashes, block.BlockHash)
+				return nil
+			})
+			Expect(err).Should(BeNil())
+			Expect(hashes).Should(Equal([]string{"block100", "block101", "block102"}))
+
+			stop := errors.New("stop")
+			hashes = []string{}
+			err = client.ScanBlocks(context.Background(), 100, 102, func(block Block) error {
+				hashes = append(hashes, block.BlockHash)
+				return stop
+			})
+			Expect(err).Should(Equal(stop))
+			Expect(hashes).Should(Equal([]string{"block100"}))
+		})
+
+		It("should fetch blocks by hash and by height", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/rawblock/block100":
+					fmt.Fprint(w, `{"hash":"block100","prev_block":"block99","height":100,"main_chain":true,"n_tx":1,"tx":[{"hash":"tx100"}]}`)
+				case "/block-height/100":
+					fmt.Fprint(w, `{"blocks":[{"hash":"block100","height":100,"main_chain":true}]}`)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+					fmt.Fprint(w, "Block not found")
+				}
+			}))
+			defer server.Close()
+			client := NewBlockchainInfoClient("mainnet", WithURL(server.URL)).(BlockClient)
+
+			block, err := client.GetBlock(context.Background(), "block100")
+			Expect(err).Should(BeNil())
+			Expect(block.PreviousBlockHash).Should(Equal("block99"))
+			Expect(block.TransactionCount).Should(Equal(1))
+			Expect(block.Transactions[0].TransactionHash).Should(Equal("tx100"))
+			block, err = client.GetBlockByHeight(context.Background(), 100)
+			Expect(err).Should(BeNil())
+			Expect(block.BlockHash).Should(Equal("block100"))
+
+			_, err = client.GetBlock(context.Background(), "block101")
+			Expect(errors.Is(err, ErrNotFound)).Should(BeTrue())
+		})
+	})
+
+	Context("when using regtest", func() {
+		It("should talk to a local API", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.URL.Path).Should(Equal("/latestblock"))
+				w.Write([]byte(`{"height": 101}`))
+			}))
+			defer server.Close()
+
+			client := NewBlockchainInfoClient("regtest", WithURL(server.URL))
+			Expect(client.NetworkParams()).Should(Equal(&chaincfg.RegressionNetParams))
+			height, err := client.GetBlockHeight(context.Background())
+			Expect(err).Should(BeNil())
+			Expect(height).Should(Equal(int64(101)))
+			Expect(client.FormatTransactionView("sent", "abcd")).Should(Equal("sent, transaction abcd"))
+		})
+	})
+
+	Context("when using the params of another network", func() {
+		It("should derive and decode addresses of the network", func() {
+			// Litecoin reuses the name of the Bitcoin mainnet, but has its
+			// own magic and address prefixes.
+			params := chaincfg.MainNetParams
+			params.Net = 0xdbb6c0fb
+			params.PubKeyHashAddrID = 0x30
+			params.ScriptHashAddrID = 0x32
+			params.Bech32HRPSegwit = "ltc"
+			params.HDCoinType = 2
+			client := NewClientWithParams("http://localhost:3000", &params)
+			Expect(client.NetworkParams()).Should(Equal(&params))
+			Expect(client.FormatTransactionView("sent", "abcd")).Should(Equal("sent, transaction abcd"))
+
+			key, _ := btcec.PrivKeyFromBytes(btcec.S256(), []byte{1})
+			addr, err := NewAccount(client, key.ToECDSA()).Address()
+			Expect(err).Should(BeNil())
+			Expect(addr.EncodeAddress()).Should(HavePrefix("L"))
+			Expect(addr.ScriptAddress()).Should(Equal(btcutil.Hash160(key.PubKey().SerializeCompressed())))
+			decoded, err := btcutil.DecodeAddress(addr.EncodeAddress(), &params)
+			Expect(err).Should(BeNil())
+			Expect(decoded.IsForNet(&params)).Should(BeTrue())
+		})
+	})
+
+	Context("when using an unsupported network", func() {
+		It("should return an error instead of panicking", func() {
+			_, err := NewBlockchainInfoClientWithError("simnet")
+			Expect(err).Should(Equal(NewErrUnsupportedNetwork("simnet")))
+			Expect(NewEsploraClient("http://localhost:3000", &chaincfg.SimNetParams).FormatTransactionView("sent", "abcd")).Should(Equal("sent, transaction abcd"))
+		})
+	})
+
+	Context("when signing with a PSBT", func() {
+		for _, addressType := range []AddressType{AddressTypeLegacy, AddressTypeP2SHSegWit, AddressTypeNativeSegWit} {
+			addressType := addressType
+			It(fmt.Sprintf("should sign address type %d elsewhere and publish", addressType), func() {
+				client := mock.NewClient(&chaincfg.TestNet3Params)
+				key, err := btcec.NewPrivateKey(btcec.S256())
+				Expect(err).Should(BeNil())
+				account := NewAccount(client, key.ToECDSA(), WithAddressType(addressType))
+				addr, err := account.Address()
+				Expect(err).Should(BeNil())
+				_, err = client.Fund(addr.EncodeAddress(), 100000)
+				Expect(err).Should(BeNil())
+				recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+				Expect(err).Should(BeNil())
+
+				packet, err := account.BuildPSBT(context.Background(), map[string]int64{recipient.EncodeAddress(): 40000}, 10)
+				Expect(err).Should(BeNil())
+				encoded, err := packet.B64Encode()
+				Expect(err).Should(BeNil())
+
+				// Sign the decoded PSBT, as an offline signer would.
+				packet, err = psbt.NewFromRawBytes(bytes.NewReader([]byte(encoded)), true)
+				Expect(err).Should(BeNil())
+				updater, err := psbt.NewUpdater(packet)
+				Expect(err).Should(BeNil())
+				sigHashes := txscript.NewTxSigHashes(packet.UnsignedTx)
+				for i, input := range packet.Inputs {
+					var sig []byte
+					if input.WitnessUtxo == nil {
+						prevOut := input.NonWitnessUtxo.TxOut[packet.UnsignedTx.TxIn[i].PreviousOutPoint.Index]
+						sig, err = txscript.RawTxInSignature(packet.UnsignedTx, i, prevOut.PkScript, txscript.SigHashAll, key)
+					} else {
+						witnessProgram := input.WitnessUtxo.PkScript
+						if input.RedeemScript != nil {
+							witnessProgram = input.RedeemScript
+						}
+						sig, err = txscript.RawTxInWitnessSignature(packet.UnsignedTx, sigHashes, i, input.WitnessUtxo.Value, witnessProgram, txscript.SigHashAll, key)
+					}
+					Expect(err).Should(BeNil())
+					outcome, err := updater.Sign(i, sig, key.PubKey().SerializeCompressed(), nil, nil)
+					Expect(err).Should(BeNil())
+					Expect(outcome).Should(BeEquivalentTo(psbt.SignSuccesful))
+				}
+
+				txHash, err := account.FinalizeAndSubmitPSBT(context.Background(), packet)
+				Expect(err).Should(BeNil())
+				Expect(client.Published()).Should(HaveLen(1))
+				_, err = client.GetRawTransaction(context.Background(), txHash)
+				Expect(err).Should(BeNil())
+			})
+		}
+	})
+
+	Context("when offline signing", func() {
+		It("should sign the given inputs without a client", func() {
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(&unspentClient{Client: NewBlockchainInfoClient("testnet")}, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			pkScript, err := txscript.PayToAddrScript(addr)
+			Expect(err).Should(BeNil())
+			hash := chainhash.DoubleHashH([]byte("funding"))
+			inputs := []UnspentOutput{{
+				TransactionHash:         hex.EncodeToString(hash[:]),
+				TransactionOutputNumber: 1,
+				ScriptPubKey:            hex.EncodeToString(pkScript),
+				Amount:                  100000,
+			}}
+
+			msgTx := wire.NewMsgTx(2)
+			msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&hash, 1), nil, nil))
+			msgTx.AddTxOut(wire.NewTxOut(99000, pkScript))
+			Expect(account.SignTransaction(msgTx, inputs, nil, nil)).Should(BeNil())
+			Expect(msgTx.TxIn[0].SignatureScript).ShouldNot(BeEmpty())
+
+			inputs[0].TransactionOutputNumber = 0
+			Expect(account.SignTransaction(msgTx, inputs, nil, nil)).Should(Equal(NewErrInputMismatch(0)))
+		})
+
+		It("should sign with the chosen sighash type", func() {
+			for _, addressType := range []AddressType{AddressTypeLegacy, AddressTypeNativeSegWit} {
+				key, err := btcec.NewPrivateKey(btcec.S256())
+				Expect(err).Should(BeNil())
+				account := NewAccount(&unspentClient{Client: NewBlockchainInfoClient("testnet")}, key.ToECDSA(), WithAddressType(addressType))
+				addr, err := account.Address()
+				Expect(err).Should(BeNil())
+				pkScript, err := txscript.PayToAddrScript(addr)
+				Expect(err).Should(BeNil())
+				inputs := []UnspentOutput{}
+				msgTx := wire.NewMsgTx(2)
+				for i := uint32(0); i < 2; i++ {
+					hash := chainhash.DoubleHashH([]byte("funding"))
+					inputs = append(inputs, UnspentOutput{
+						TransactionHash:         hex.EncodeToString(hash[:]),
+						TransactionOutputNumber: i,
+						ScriptPubKey:            hex.EncodeToString(pkScript),
+						Amount:                  100000,
+					})
+					msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&hash, i), nil, nil))
+				}
+				msgTx.AddTxOut(wire.NewTxOut(99000, pkScript))
+
+				hashType := txscript.SigHashSingle | txscript.SigHashAnyOneCanPay
+				Expect(account.SignTransaction(msgTx, inputs, nil, nil, WithSigHashType(hashType))).Should(Equal(NewErrSigHashSingleOutputs(2, 1)))
+				msgTx.AddTxOut(wire.NewTxOut(99000, pkScript))
+				Expect(account.SignTransaction(msgTx, inputs, nil, nil, WithSigHashType(hashType))).Should(BeNil())
+				for _, txIn := range msgTx.TxIn {
+					sig := txIn.Witness
+					if addressType == AddressTypeLegacy {
+						sig, err = txscript.PushedData(txIn.SignatureScript)
+						Expect(err).Should(BeNil())
+					}
+					Expect(txscript.SigHashType(sig[0][len(sig[0])-1])).Should(Equal(hashType))
+				}
+				Expect(account.SignTransaction(msgTx, inputs, nil, nil, WithSigHashType(0x04))).Should(Equal(NewErrInvalidSigHashType(0x04)))
+			}
+		})
+	})
+
+	Context("when funding with unconfirmed outputs", func() {
+		It("should only spend confirmed outputs by default", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 40000, 1000, false)
+			Expect(err).Should(BeNil())
+			// The change of the first transfer is unconfirmed.
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 10000, 1000, false)
+			Expect(err).Should(Equal(NewErrInsufficientBalance(addr.EncodeAddress(), 11000, 0)))
+			Expect(errors.Is(err, ErrInsufficientBalance)).Should(BeTrue())
+			Expect(errors.Is(err, ErrMismatchedPubKeys)).Should(BeFalse())
+			var balanceErr *InsufficientBalanceError
+			Expect(errors.As(err, &balanceErr)).Should(BeTrue())
+			Expect(balanceErr.Shortfall()).Should(Equal(int64(11000)))
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 10000, 1000, false, AllowUnconfirmed())
+			Expect(err).Should(BeNil())
+		})
+
+		It("should only send the confirmed balance when sending everything", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			redeemScript, err := txscript.PayToAddrScript(addr)
+			Expect(err).Should(BeNil())
+			scriptAddr, err := account.ContractAddress(redeemScript)
+			Expect(err).Should(BeNil())
+			for _, address := range []string{addr.EncodeAddress(), scriptAddr.EncodeAddress()} {
+				_, err = client.Fund(address, 100000)
+				Expect(err).Should(BeNil())
+			}
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+
+			// Leave unconfirmed outputs on both addresses, next to confirmed
+			// outputs.
+			_, err = account.Transfer(context.Background(), scriptAddr.EncodeAddress(), 20000, 1000, false)
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 50000)
+			Expect(err).Should(BeNil())
+			confirmed, unconfirmed, err := account.BalanceDetails(context.Background(), addr.EncodeAddress())
+			Expect(err).Should(BeNil())
+			Expect(confirmed).Should(Equal(int64(50000)))
+			Expect(unconfirmed).Should(Equal(int64(79000)))
+
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 0, 1000, true)
+			Expect(err).Should(BeNil())
+			_, err = account.RecoverFromScript(context.Background(), redeemScript, recipient.EncodeAddress(), 10, nil)
+			Expect(err).Should(BeNil())
+			balance, err := client.Balance(context.Background(), recipient.EncodeAddress(), 0)
+			Expect(err).Should(BeNil())
+			Expect(balance).Should(BeNumerically(">", 49000+100000-3000))
+			balance, err = client.Balance(context.Background(), scriptAddr.EncodeAddress(), 0)
+			Expect(err).Should(BeNil())
+			Expect(balance).Should(Equal(int64(20000)))
+		})
+	})
+
+	Context("when spending a P2WSH HTLC", func() {
+		It("should redeem and refund the contract using the witness", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			secret := bytes.Repeat([]byte{0x42}, 32)
+			contract, err := BuildHTLC(sha256.Sum256(secret), addr, addr, 500)
+			Expect(err).Should(BeNil())
+			contractAddr, err := account.ContractP2WSHAddress(contract)
+			Expect(err).Should(BeNil())
+			witnessAddr, err := WitnessScriptAddress(contract, &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			Expect(contractAddr.EncodeAddress()).Should(Equal(witnessAddr.EncodeAddress()))
+			spend := func(msgTx *wire.MsgTx) bool {
+				script, err := txscript.PayToAddrScript(addr)
+				if err != nil {
+					return false
+				}
+				msgTx.AddTxOut(wire.NewTxOut(90000, script))
+				return true
+			}
+
+			_, err = client.Fund(contractAddr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+			_, err = account.SendTransaction(context.Background(), contract, 1000, nil, spend, HTLCRedeem(secret), nil, WitnessContract())
+			Expect(err).Should(BeNil())
+			msgTx, err := DecodeTransaction(client.Published()[0])
+			Expect(err).Should(BeNil())
+			Expect(msgTx.TxIn[0].SignatureScript).Should(BeEmpty())
+			Expect(msgTx.TxIn[0].Witness).Should(HaveLen(5))
+			Expect(msgTx.TxIn[0].Witness[2]).Should(Equal(secret))
+			Expect(msgTx.TxIn[0].Witness[3]).Should(Equal([]byte{1}))
+			Expect(msgTx.TxIn[0].Witness[4]).Should(Equal(contract))
+
+			_, err = client.Fund(contractAddr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+			_, err = account.SendTransaction(context.Background(), contract, 1000, nil, spend, HTLCRefund(), nil, WitnessContract(), WithLockTime(500))
+			Expect(err).Should(BeNil())
+			msgTx, err = DecodeTransaction(client.Published()[1])
+			Expect(err).Should(BeNil())
+			Expect(msgTx.TxIn[0].Witness).Should(HaveLen(4))
+			Expect(msgTx.TxIn[0].Witness[2]).Should(BeEmpty())
+			balance, err := client.Balance(context.Background(), contractAddr.EncodeAddress(), 0)
+			Expect(err).Should(BeNil())
+			Expect(balance).Should(Equal(int64(0)))
+		})
+	})
+
+	Context("when redeeming and consolidating", func() {
+		It("should spend the contract and the smallest outputs of the account to a single output", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			secret := bytes.Repeat([]byte{0x42}, 32)
+			contract, err := BuildHTLC(sha256.Sum256(secret), addr, addr, 500)
+			Expect(err).Should(BeNil())
+			contractAddr, err := account.ContractP2WSHAddress(contract)
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(contractAddr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+			for _, value := range []int64{30000, 10000, 20000} {
+				_, err = client.Fund(addr.EncodeAddress(), value)
+				Expect(err).Should(BeNil())
+			}
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+
+			_, err = account.RedeemAndConsolidate(context.Background(), contract, -1, recipient.EncodeAddress(), 10, HTLCRedeem(secret), WitnessContract())
+			Expect(err).ShouldNot(BeNil())
+			Expect(client.Published()).Should(BeEmpty())
+
+			txHash, err := account.RedeemAndConsolidate(context.Background(), contract, 2, recipient.EncodeAddress(), 10, HTLCRedeem(secret), WitnessContract())
+			Expect(err).Should(BeNil())
+			msgTx, err := DecodeTransaction(client.Published()[0])
+			Expect(err).Should(BeNil())
+			Expect(msgTx.TxHash().String()).Should(Equal(txHash))
+			Expect(msgTx.TxIn).Should(HaveLen(3))
+			Expect(msgTx.TxOut).Should(HaveLen(1))
+			recipientScript, err := txscript.PayToAddrScript(recipient)
+			Expect(err).Should(BeNil())
+			Expect(msgTx.TxOut[0].PkScript).Should(Equal(recipientScript))
+			Expect(msgTx.TxOut[0].Value).Should(BeNumerically("<", 130000))
+			Expect(msgTx.TxOut[0].Value).Should(BeNumerically(">", 130000-10*int64(msgTx.SerializeSize())))
+
+			balance, err := client.Balance(context.Background(), contractAddr.EncodeAddress(), 0)
+			Expect(err).Should(BeNil())
+			Expect(balance).Should(Equal(int64(0)))
+			balance, err = client.Balance(context.Background(), addr.EncodeAddress(), 0)
+			Expect(err).Should(BeNil())
+			Expect(balance).Should(Equal(int64(30000)))
+		})
+	})
+
+	Context("when refunding an HTLC", func() {
+		It("should spend the refund path once the locktime is set", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			redeemer, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("redeemer")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			contract, err := BuildHTLC(sha256.Sum256([]byte("secret")), redeemer, addr, 500)
+			Expect(err).Should(BeNil())
+			contractAddr, err := btcutil.NewAddressScriptHash(contract, &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(contractAddr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+			refund := func(msgTx *wire.MsgTx) bool {
+				script, err := txscript.PayToAddrScript(addr)
+				if err != nil {
+					return false
+				}
+				msgTx.AddTxOut(wire.NewTxOut(90000, script))
+				return true
+			}
+
+			// Without a locktime, OP_CHECKLOCKTIMEVERIFY fails.
+			_, err = account.SendTransaction(context.Background(), contract, 1000, nil, refund, HTLCRefund(), nil)
+			Expect(err).ShouldNot(BeNil())
+
+			_, err = account.SendTransaction(context.Background(), contract, 1000, nil, refund, HTLCRefund(), nil, WithLockTime(500))
+			Expect(err).Should(BeNil())
+			msgTx := wire.NewMsgTx(2)
+			Expect(msgTx.Deserialize(bytes.NewReader(client.Published()[0]))).Should(BeNil())
+			Expect(msgTx.LockTime).Should(Equal(uint32(500)))
+			Expect(msgTx.TxIn[0].Sequence).Should(Equal(uint32(wire.MaxTxInSequenceNum - 1)))
+		})
+
+		It("should enable a locktime set by the pre-condition", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			pkScript, err := txscript.PayToAddrScript(addr)
+			Expect(err).Should(BeNil())
+			// A contract that the account can only spend after block 500.
+			contract, err := txscript.NewScriptBuilder().
+				AddInt64(500).AddOp(txscript.OP_CHECKLOCKTIMEVERIFY).AddOp(txscript.OP_DROP).
+				AddOps(pkScript).Script()
+			Expect(err).Should(BeNil())
+			contractAddr, err := btcutil.NewAddressScriptHash(contract, &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(contractAddr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+
+			_, err = account.SendTransaction(context.Background(), contract, 1000, nil, func(msgTx *wire.MsgTx) bool {
+				msgTx.LockTime = 499
+				msgTx.AddTxOut(wire.NewTxOut(90000, pkScript))
+				return true
+			}, nil, nil)
+			Expect(err).ShouldNot(BeNil())
+			_, err = account.SendTransaction(context.Background(), contract, 1000, nil, func(msgTx *wire.MsgTx) bool {
+				msgTx.LockTime = 500
+				msgTx.AddTxOut(wire.NewTxOut(90000, pkScript))
+				return true
+			}, nil, nil)
+			Expect(err).Should(BeNil())
+		})
+	})
+	Context("when decoding transactions", func() {
+		It("should parse a signed transaction without the client", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA(), WithAddressType(AddressTypeNativeSegWit))
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			fundingHash, err := client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			raw, txHash, err := account.BuildAndSign(context.Background(), map[string]int64{recipient.EncodeAddress(): 40000}, 1000)
+			Expect(err).Should(BeNil())
+
+			msgTx, err := DecodeTransaction(raw)
+			Expect(err).Should(BeNil())
+			Expect(msgTx.TxHash().String()).Should(Equal(txHash))
+			Expect(msgTx.HasWitness()).Should(BeTrue())
+
+			tx, err := account.ParseTransaction(raw)
+			Expect(err).Should(BeNil())
+			Expect(tx.TransactionHash).Should(Equal(txHash))
+			Expect(tx.Inputs).Should(HaveLen(1))
+			Expect(tx.Inputs[0].PrevOut.TransactionHash).Should(Equal(fundingHash))
+			Expect(tx.Outputs).Should(HaveLen(2))
+			paid := map[string]uint64{}
+			for _, output := range tx.Outputs {
+				paid[output.Address] = output.Value
+			}
+			Expect(paid).Should(Equal(map[string]uint64{recipient.EncodeAddress(): 40000, addr.EncodeAddress(): 59000}))
+
+			_, err = DecodeTransaction(raw[:len(raw)-1])
+			Expect(err).ShouldNot(BeNil())
+
+			serialized, hexTx, err := SerializeTransaction(msgTx)
+			Expect(err).Should(BeNil())
+			Expect(serialized).Should(Equal(raw))
+			Expect(hexTx).Should(Equal(hex.EncodeToString(raw)))
+			publishedHash, err := account.PublishRawHex(context.Background(), hexTx)
+			Expect(err).Should(BeNil())
+			Expect(publishedHash).Should(Equal(txHash))
+			Expect(client.Published()).Should(Equal([][]byte{raw}))
+			_, err = account.PublishRawHex(context.Background(), "zz")
+			Expect(err).ShouldNot(BeNil())
+		})
+	})
+
+	Context("when estimating transaction sizes", func() {
+		It("should estimate common transactions", func() {
+			Expect(EstimateTxSize(1, 1, ScriptTypeP2PKH)).Should(Equal(193))
+			Expect(EstimateTxSize(1, 2, ScriptTypeP2PKH)).Should(Equal(227))
+			Expect(EstimateTxSize(2, 2, ScriptTypeP2PKH)).Should(Equal(376))
+			Expect(EstimateTxSize(1, 1, ScriptTypeP2WPKH)).Should(Equal(113))
+			Expect(EstimateTxSize(1, 2, ScriptTypeP2WPKH)).Should(Equal(147))
+			Expect(EstimateTxSize(2, 2, ScriptTypeP2WPKH)).Should(Equal(215))
+			Expect(EstimateTxSize(1, 2, ScriptTypeP2SHP2WPKH)).Should(Equal(170))
+		})
+
+		It("should not underestimate signed transactions", func() {
+			scriptTypes := map[AddressType]ScriptType{
+				AddressTypeLegacy:       ScriptTypeP2PKH,
+				AddressTypeP2SHSegWit:   ScriptTypeP2SHP2WPKH,
+				AddressTypeNativeSegWit: ScriptTypeP2WPKH,
+			}
+			for addressType, scriptType := range scriptTypes {
+				client := mock.NewClient(&chaincfg.TestNet3Params)
+				key, err := btcec.NewPrivateKey(btcec.S256())
+				Expect(err).Should(BeNil())
+				account := NewAccount(client, key.ToECDSA(), WithAddressType(addressType))
+				addr, err := account.Address()
+				Expect(err).Should(BeNil())
+				for i := 0; i < 2; i++ {
+					_, err = client.Fund(addr.EncodeAddress(), 30000)
+					Expect(err).Should(BeNil())
+				}
+				recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+				Expect(err).Should(BeNil())
+				raw, _, err := account.BuildAndSign(context.Background(), map[string]int64{recipient.EncodeAddress(): 40000}, 1000)
+				Expect(err).Should(BeNil())
+				msgTx, err := DecodeTransaction(raw)
+				Expect(err).Should(BeNil())
+				Expect(msgTx.TxIn).Should(HaveLen(2))
+
+				weight := msgTx.SerializeSizeStripped()*3 + msgTx.SerializeSize()
+				estimate := EstimateTxSize(2, len(msgTx.TxOut), scriptType)
+				Expect(estimate).Should(BeNumerically(">=", (weight+3)/4))
+				// Signatures are at most 2 bytes shorter than estimated.
+				Expect(estimate).Should(BeNumerically("<=", (weight+3)/4+4))
+			}
+		})
+	})
+
+	Context("when estimating fee rates", func() {
+		It("should map targets to mempool.space tiers", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.URL.Path).Should(Equal("/v1/fees/recommended"))
+				fmt.Fprint(w, `{"fastestFee":30,"halfHourFee":20,"hourFee":10,"economyFee":5,"minimumFee":1}`)
+			}))
+			defer server.Close()
+			client := NewBlockchainInfoClient("testnet", WithFeeURL(server.URL))
+			for target, expected := range map[int]int64{1: 30, 2: 20, 3: 20, 6: 10, 7: 5, 144: 5, 145: 1, 1008: 1} {
+				feeRate, err := client.EstimateFeeRate(context.Background(), target)
+				Expect(err).Should(BeNil())
+				Expect(feeRate).Should(Equal(expected))
+			}
+
+			_, err := NewBlockchainInfoClient("regtest").EstimateFeeRate(context.Background(), 1)
+			Expect(err).Should(Equal(ErrFeeEstimateUnavailable))
+		})
+
+		It("should use the closest Esplora target", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.URL.Path).Should(Equal("/fee-estimates"))
+				fmt.Fprint(w, `{"2":20.5,"6":10.1,"144":0.5}`)
+			}))
+			defer server.Close()
+			client := NewEsploraClient(server.URL, &chaincfg.TestNet3Params)
+			for target, expected := range map[int]int64{1: 21, 2: 21, 5: 21, 6: 11, 200: 1} {
+				feeRate, err := client.EstimateFeeRate(context.Background(), target)
+				Expect(err).Should(BeNil())
+				Expect(feeRate).Should(Equal(expected))
+			}
+		})
+
+		It("should estimate the confirmation time of a fee rate", func() {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				fmt.Fprint(w, `{"fastestFee":20,"halfHourFee":10,"hourFee":5,"economyFee":3,"minimumFee":2}`)
+			}))
+			defer server.Close()
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(NewBlockchainInfoClient("testnet", WithFeeURL(server.URL)), key.ToECDSA())
+			for feeRate, expected := range map[int64]time.Duration{25: 10 * time.Minute, 10: 20 * time.Minute, 7: time.Hour, 4: 2 * time.Hour, 2: 7 * 24 * time.Hour} {
+				duration, err := account.EstimateConfirmationTime(context.Background(), feeRate)
+				Expect(err).Should(BeNil())
+				Expect(duration).Should(Equal(expected))
+			}
+			Expect(requests).Should(Equal(5))
+			_, err = account.EstimateConfirmationTime(context.Background(), 1)
+			Expect(err).Should(Equal(ErrFeeRateTooLow))
+		})
+	})
+
+	Context("when an address has many unspent outputs", func() {
+		It("should fetch every page from blockchain.info", func() {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+				Expect(err).Should(BeNil())
+				limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+				Expect(err).Should(BeNil())
+				utxos := Unspent{Outputs: []UnspentOutput{}}
+				for i := offset; i < offset+limit && i < 2500; i++ {
+					utxos.Outputs = append(utxos.Outputs, UnspentOutput{TransactionOutputNumber: uint32(i), Amount: 10, Confirmations: 1})
+				}
+				Expect(json.NewEncoder(w).Encode(utxos)).Should(BeNil())
+			}))
+			defer server.Close()
+			client := NewBlockchainInfoClient("testnet", WithURL(server.URL))
+
+			utxos, err := client.GetUnspentOutputs(context.Background(), "address", 0, 1)
+			Expect(err).Should(BeNil())
+			Expect(utxos.Outputs).Should(HaveLen(2500))
+			Expect(utxos.Outputs[2499].TransactionOutputNumber).Should(Equal(uint32(2499)))
+			Expect(requests).Should(Equal(3))
+
+			requests = 0
+			utxos, err = client.GetUnspentOutputs(context.Background(), "address", 1200, 1)
+			Expect(err).Should(BeNil())
+			Expect(utxos.Outputs).Should(HaveLen(1200))
+			Expect(requests).Should(Equal(2))
+
+			balance, err := client.Balance(context.Background(), "address", 1)
+			Expect(err).Should(BeNil())
+			Expect(balance).Should(Equal(int64(25000)))
+		})
+	})
+
+	Context("when unspent outputs pay to different scripts", func() {
+		It("should only fund from outputs paying to the address", func() {
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			client := &unspentClient{Client: NewBlockchainInfoClient("testnet")}
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			pkScript, err := txscript.PayToAddrScript(addr)
+			Expect(err).Should(BeNil())
+			pubKey, err := btcutil.NewAddressPubKey(key.PubKey().SerializeCompressed(), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			p2pkScript, err := txscript.PayToAddrScript(pubKey)
+			Expect(err).Should(BeNil())
+			other, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("other")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			otherScript, err := txscript.PayToAddrScript(other)
+			Expect(err).Should(BeNil())
+			for i, script := range [][]byte{p2pkScript, otherScript, pkScript} {
+				hash := chainhash.DoubleHashH([]byte{byte(i)})
+				client.utxos.Outputs = append(client.utxos.Outputs, UnspentOutput{
+					TransactionHash: hex.EncodeToString(hash[:]),
+					ScriptPubKey:    hex.EncodeToString(script),
+					Amount:          30000,
+					Confirmations:   1,
+				})
+			}
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+
+			_, _, err = account.BuildAndSign(context.Background(), map[string]int64{recipient.EncodeAddress(): 40000}, 1000)
+			Expect(err).Should(Equal(NewErrMismatchedPubKeys(11000)))
+			Expect(errors.Is(err, ErrMismatchedPubKeys)).Should(BeTrue())
+			Expect(errors.Is(err, ErrInsufficientBalance)).Should(BeFalse())
+
+			raw, _, err := account.BuildAndSign(context.Background(), map[string]int64{recipient.EncodeAddress(): 20000}, 1000)
+			Expect(err).Should(BeNil())
+			msgTx, err := DecodeTransaction(raw)
+			Expect(err).Should(BeNil())
+			Expect(msgTx.TxIn).Should(HaveLen(1))
+			Expect(msgTx.TxIn[0].PreviousOutPoint.Hash).Should(Equal(chainhash.DoubleHashH([]byte{2})))
+		})
+	})
+
+	Context("when an address has many transactions", func() {
+		It("should find a spend beyond the first page", func() {
+			txs := []Transaction{}
+			for i := 0; i < 120; i++ {
+				tx := Transaction{TransactionHash: fmt.Sprintf("%d", i), Inputs: []Input{{PrevOut: PreviousOut{Address: "other"}, Script: "00"}}}
+				if i == 110 {
+					tx.Inputs = append(tx.Inputs, Input{PrevOut: PreviousOut{Address: "address"}, Script: "abcd"})
+				}
+				txs = append(txs, tx)
+			}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.URL.Path).Should(Equal("/rawaddr/address"))
+				offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+				Expect(err).Should(BeNil())
+				limit := 50
+				if r.URL.Query().Get("limit") != "" {
+					limit, err = strconv.Atoi(r.URL.Query().Get("limit"))
+					Expect(err).Should(BeNil())
+				}
+				page := []Transaction{}
+				for i := offset; i < offset+limit && i < len(txs); i++ {
+					page = append(page, txs[i])
+				}
+				Expect(json.NewEncoder(w).Encode(SingleAddress{Address: "address", TransactionCount: int64(len(txs)), Sent: 1, Transactions: page})).Should(BeNil())
+			}))
+			defer server.Close()
+			client := NewBlockchainInfoClient("testnet", WithURL(server.URL))
+
+			page, err := client.GetAddressTransactions(context.Background(), "address", 100, 10)
+			Expect(err).Should(BeNil())
+			Expect(page).Should(HaveLen(10))
+			Expect(page[0].TransactionHash).Should(Equal("100"))
+
+			script, err := client.GetScriptFromSpentP2SH(context.Background(), "address")
+			Expect(err).Should(BeNil())
+			Expect(script).Should(Equal([]byte{0xab, 0xcd}))
+		})
+	})
+
+	Context("when waiting for the post-condition", func() {
+		It("should poll at the given interval until the timeout", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+
+			checks := 0
+			postCond := func(*wire.MsgTx) bool {
+				checks++
+				return checks == 3
+			}
+			_, err = account.SendTransaction(context.Background(), nil, 1000, nil, nil, nil, postCond, WithPollInterval(time.Millisecond))
+			Expect(err).Should(BeNil())
+			Expect(checks).Should(Equal(3))
+
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+			never := func(*wire.MsgTx) bool { return false }
+			_, err = account.SendTransaction(context.Background(), nil, 1000, nil, nil, nil, never, WithPollInterval(time.Millisecond), WithConfirmationTimeout(20*time.Millisecond))
+			Expect(errors.Is(err, ErrConfirmationTimeout)).Should(BeTrue())
+			Expect(errors.Is(err, context.DeadlineExceeded)).Should(BeTrue())
+		})
+
+		It("should stop rebroadcasting after the maximum", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+
+			never := func(*wire.MsgTx) bool { return false }
+			_, err = account.SendTransaction(context.Background(), nil, 1000, nil, nil, nil, never, WithPollInterval(time.Millisecond), WithRebroadcastInterval(5*time.Millisecond), WithMaxRebroadcasts(2))
+			Expect(errors.Is(err, ErrRebroadcastLimit)).Should(BeTrue())
+			// The mock client rejects the transaction it already has, which
+			// is not fatal until the limit is reached.
+			Expect(err.Error()).Should(ContainSubstring(mock.ErrMissingInput.Error()))
+			Expect(client.Published()).Should(HaveLen(1))
+		})
+	})
+
+	Context("when consolidating unspent outputs", func() {
+		It("should merge the smallest confirmed outputs", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			for _, value := range []int64{50000, 10000, 40000, 20000, 30000} {
+				_, err = client.Fund(addr.EncodeAddress(), value)
+				Expect(err).Should(BeNil())
+			}
+			client.Mine(1)
+
+			_, err = account.Consolidate(context.Background(), 10, 3)
+			Expect(err).Should(BeNil())
+			msgTx := wire.NewMsgTx(2)
+			Expect(msgTx.Deserialize(bytes.NewReader(client.Published()[0]))).Should(BeNil())
+			Expect(msgTx.TxIn).Should(HaveLen(3))
+			Expect(msgTx.TxOut).Should(HaveLen(1))
+			script, err := txscript.PayToAddrScript(addr)
+			Expect(err).Should(BeNil())
+			Expect(msgTx.TxOut[0].PkScript).Should(Equal(script))
+			Expect(msgTx.TxOut[0].Value).Should(BeNumerically("<", 60000))
+			Expect(msgTx.TxOut[0].Value).Should(BeNumerically(">", 55000))
+
+			// The fee of consolidating the two remaining outputs exceeds their
+			// value, and the unconfirmed output is not consolidated.
+			_, err = account.Consolidate(context.Background(), 1000, 3)
+			Expect(err).ShouldNot(BeNil())
+			_, err = account.Consolidate(context.Background(), 10, 1)
+			Expect(err).Should(Equal(ErrNothingToConsolidate))
+			Expect(client.Published()).Should(HaveLen(1))
+		})
+
+		It("should estimate the benefit of merging the smallest unreserved outputs", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 5000)
+			Expect(err).Should(BeNil())
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			// Reserve the smallest output.
+			_, _, err = account.BuildAndSign(context.Background(), map[string]int64{recipient.EncodeAddress(): 3000}, 1000)
+			Expect(err).Should(BeNil())
+			for _, value := range []int64{50000, 10000, 30000, 20000} {
+				_, err = client.Fund(addr.EncodeAddress(), value)
+				Expect(err).Should(BeNil())
+			}
+
+			_, _, _, err = account.EstimateConsolidationBenefit(context.Background(), -1, 10)
+			Expect(err).ShouldNot(BeNil())
+			recovered, fee, worthwhile, err := account.EstimateConsolidationBenefit(context.Background(), 2, 10)
+			Expect(err).Should(BeNil())
+			Expect(recovered).Should(Equal(int64(30000)))
+			Expect(fee).Should(BeNumerically(">=", 10*EstimateTxSize(2, 1, ScriptTypeP2PKH)))
+			Expect(worthwhile).Should(BeTrue())
+			recovered, _, _, err = account.EstimateConsolidationBenefit(context.Background(), 10, 10)
+			Expect(err).Should(BeNil())
+			Expect(recovered).Should(Equal(int64(110000)))
+			_, _, worthwhile, err = account.EstimateConsolidationBenefit(context.Background(), 2, 1000)
+			Expect(err).Should(BeNil())
+			Expect(worthwhile).Should(BeFalse())
+		})
+
+		It("should consolidate the outputs that the estimate selects", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 5000)
+			Expect(err).Should(BeNil())
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			stx, _, err := account.BuildAndSign(context.Background(), map[string]int64{recipient.EncodeAddress(): 3000}, 1000)
+			Expect(err).Should(BeNil())
+			reserved := wire.NewMsgTx(2)
+			Expect(reserved.Deserialize(bytes.NewReader(stx))).Should(BeNil())
+			for _, value := range []int64{20000, 10000} {
+				_, err = client.Fund(addr.EncodeAddress(), value)
+				Expect(err).Should(BeNil())
+			}
+
+			// A single output is not worth consolidating.
+			_, _, worthwhile, err := account.EstimateConsolidationBenefit(context.Background(), 1, 10)
+			Expect(err).Should(BeNil())
+			Expect(worthwhile).Should(BeFalse())
+			recovered, fee, worthwhile, err := account.EstimateConsolidationBenefit(context.Background(), 3, 10)
+			Expect(err).Should(BeNil())
+			Expect(recovered).Should(Equal(int64(30000)))
+			Expect(worthwhile).Should(BeTrue())
+
+			_, err = account.Consolidate(context.Background(), 10, 3)
+			Expect(err).Should(BeNil())
+			msgTx := wire.NewMsgTx(2)
+			Expect(msgTx.Deserialize(bytes.NewReader(client.Published()[0]))).Should(BeNil())
+			Expect(msgTx.TxIn).Should(HaveLen(2))
+			for _, txIn := range msgTx.TxIn {
+				Expect(txIn.PreviousOutPoint).ShouldNot(Equal(reserved.TxIn[0].PreviousOutPoint))
+			}
+			// The estimate assumes the largest signatures.
+			Expect(msgTx.TxOut[0].Value).Should(BeNumerically(">=", recovered-fee))
+		})
+	})
+
+	Context("when reserving unspent outputs", func() {
+		It("should not fund two transactions with the same output", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			outputs := map[string]int64{recipient.EncodeAddress(): 60000}
+			spent := map[wire.OutPoint]bool{}
+			for i := 0; i < 2; i++ {
+				stx, _, err := account.BuildAndSign(context.Background(), outputs, 1000)
+				Expect(err).Should(BeNil())
+				msgTx := wire.NewMsgTx(2)
+				Expect(msgTx.Deserialize(bytes.NewReader(stx))).Should(BeNil())
+				Expect(msgTx.TxIn).Should(HaveLen(1))
+				Expect(spent[msgTx.TxIn[0].PreviousOutPoint]).Should(BeFalse())
+				spent[msgTx.TxIn[0].PreviousOutPoint] = true
+			}
+			// The account is not broke, its outputs are busy.
+			_, _, err = account.BuildAndSign(context.Background(), outputs, 1000)
+			Expect(errors.Is(err, ErrUTXOsReserved)).Should(BeTrue())
+			Expect(errors.Is(err, ErrInsufficientBalance)).Should(BeFalse())
+			_, _, err = account.BuildAndSign(context.Background(), map[string]int64{recipient.EncodeAddress(): 300000}, 1000)
+			Expect(errors.Is(err, ErrInsufficientBalance)).Should(BeTrue())
+
+			account.ReleaseReservations()
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 60000, 1000, false)
+			Expect(err).Should(BeNil())
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 60000, 1000, false)
+			Expect(err).Should(BeNil())
+			Expect(client.Published()).Should(HaveLen(2))
+		})
+
+		It("should not sweep or bump outputs reserved by other transactions", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			parentTxid, err := account.Transfer(context.Background(), recipient.EncodeAddress(), 20000, 1000, false)
+			Expect(err).Should(BeNil())
+			parent, err := client.GetRawTransaction(context.Background(), parentTxid)
+			Expect(err).Should(BeNil())
+			vout := uint32(0)
+			for i, output := range parent.Outputs {
+				if output.Address == addr.EncodeAddress() {
+					vout = uint32(i)
+				}
+			}
+			_, err = client.Fund(addr.EncodeAddress(), 50000)
+			Expect(err).Should(BeNil())
+
+			// Only the unconfirmed change of the parent can cover it.
+			_, _, err = account.BuildAndSign(context.Background(), map[string]int64{recipient.EncodeAddress(): 75000}, 1000, AllowUnconfirmed())
+			Expect(err).Should(BeNil())
+			_, err = account.BumpWithChild(context.Background(), parentTxid, vout, 20)
+			Expect(errors.Is(err, ErrUTXOsReserved)).Should(BeTrue())
+
+			// The only confirmed output is reserved, so there is nothing to
+			// sweep.
+			account.ReleaseReservations()
+			stx, _, err := account.BuildAndSign(context.Background(), map[string]int64{recipient.EncodeAddress(): 40000}, 1000)
+			Expect(err).Should(BeNil())
+			msgTx := wire.NewMsgTx(2)
+			Expect(msgTx.Deserialize(bytes.NewReader(stx))).Should(BeNil())
+			Expect(msgTx.TxIn).Should(HaveLen(1))
+			_, err = account.Sweep(context.Background(), recipient.EncodeAddress(), 10)
+			Expect(errors.Is(err, ErrUTXOsReserved)).Should(BeTrue())
+			Expect(client.Published()).Should(HaveLen(1))
+
+			account.ReleaseReservations()
+			_, err = account.BumpWithChild(context.Background(), parentTxid, vout, 20)
+			Expect(err).Should(BeNil())
+		})
+	})
+
+	Context("when recovering funds from a redeem script", func() {
+		It("should spend every output of the script to the address", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			redeemScript, err := txscript.PayToAddrScript(addr)
+			Expect(err).Should(BeNil())
+			scriptAddr, err := account.ContractAddress(redeemScript)
+			Expect(err).Should(BeNil())
+			for _, value := range []int64{60000, 40000} {
+				_, err = client.Fund(scriptAddr.EncodeAddress(), value)
+				Expect(err).Should(BeNil())
+			}
+
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			txHash, err := account.RecoverFromScript(context.Background(), redeemScript, recipient.EncodeAddress(), 10, nil)
+			Expect(err).Should(BeNil())
+			recovered, err := client.GetRawTransaction(context.Background(), txHash)
+			Expect(err).Should(BeNil())
+			Expect(recovered.Inputs).Should(HaveLen(2))
+			Expect(recovered.Outputs).Should(HaveLen(1))
+			Expect(recovered.Outputs[0].Address).Should(Equal(recipient.EncodeAddress()))
+			// The size of a signature varies by a byte between signings.
+			Expect(100000 - int64(recovered.Outputs[0].Value)).Should(BeNumerically("~", 10*recovered.Size, 20))
+
+			balance, err := client.Balance(context.Background(), scriptAddr.EncodeAddress(), 0)
+			Expect(err).Should(BeNil())
+			Expect(balance).Should(Equal(int64(0)))
+		})
+	})
+
+	Context("when caching unspent outputs", func() {
+		It("should fund, and compute balances, from the cache until it expires or is invalidated", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			counting := &countingClient{Client: client}
+			store := &fakeCacheStore{values: map[string][]byte{}}
+			cached := NewCachedClient(counting, store, time.Hour)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(cached, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+
+			balance, err := account.Balance(context.Background(), addr.EncodeAddress(), 1)
+			Expect(err).Should(BeNil())
+			Expect(balance).Should(Equal(int64(100000)))
+			confirmed, unconfirmed, err := account.BalanceDetails(context.Background(), addr.EncodeAddress())
+			Expect(err).Should(BeNil())
+			Expect(confirmed).Should(Equal(int64(100000)))
+			Expect(unconfirmed).Should(Equal(int64(0)))
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 50000, 1000, false)
+			Expect(err).Should(BeNil())
+			Expect(counting.unspentRequests).Should(Equal(1))
+
+			// Publishing invalidates the addresses that were spent from, so
+			// a client restarted with the same store fetches them again,
+			// and is then served from the store.
+			restarted := &countingClient{Client: client}
+			for i := 0; i < 2; i++ {
+				balance, err = NewCachedClient(restarted, store, time.Hour).Balance(context.Background(), addr.EncodeAddress(), 0)
+				Expect(err).Should(BeNil())
+				Expect(balance).Should(Equal(int64(49000)))
+				Expect(restarted.unspentRequests).Should(Equal(1))
+			}
+
+			// Confirmations are recomputed as blocks are mined, and outputs
+			// that were unconfirmed are fetched again.
+			balance, err = account.Balance(context.Background(), addr.EncodeAddress(), 1)
+			Expect(err).Should(BeNil())
+			Expect(balance).Should(Equal(int64(0)))
+			client.Mine(1)
+			balance, err = account.Balance(context.Background(), addr.EncodeAddress(), 1)
+			Expect(err).Should(BeNil())
+			Expect(balance).Should(Equal(int64(49000)))
+			balance, err = account.Balance(context.Background(), addr.EncodeAddress(), 2)
+			Expect(err).Should(BeNil())
+			Expect(balance).Should(Equal(int64(0)))
+			client.Mine(1)
+			balance, err = account.Balance(context.Background(), addr.EncodeAddress(), 2)
+			Expect(err).Should(BeNil())
+			Expect(balance).Should(Equal(int64(49000)))
+			Expect(counting.unspentRequests).Should(Equal(2))
+
+			// Expired entries are fetched again.
+			balance, err = NewCachedClient(restarted, store, time.Nanosecond).Balance(context.Background(), addr.EncodeAddress(), 0)
+			Expect(err).Should(BeNil())
+			Expect(balance).Should(Equal(int64(49000)))
+			Expect(restarted.unspentRequests).Should(Equal(2))
+
+			// Invalidated entries are fetched again.
+			Expect(cached.InvalidateAddress(addr.EncodeAddress())).Should(BeNil())
+			balance, err = account.Balance(context.Background(), addr.EncodeAddress(), 0)
+			Expect(err).Should(BeNil())
+			Expect(balance).Should(Equal(int64(49000)))
+			Expect(counting.unspentRequests).Should(Equal(3))
+		})
+
+		It("should only cache confirmed transactions", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			cached := NewCachedClient(client, NewMemoryCacheStore(), time.Hour)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(cached, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			txHash, err := account.Transfer(context.Background(), recipient.EncodeAddress(), 50000, 1000, false)
+			Expect(err).Should(BeNil())
+
+			transaction, err := cached.GetRawTransaction(context.Background(), txHash)
+			Expect(err).Should(BeNil())
+			Expect(transaction.BlockHeight).Should(Equal(int64(0)))
+			client.Mine(1)
+			transaction, err = cached.GetRawTransaction(context.Background(), txHash)
+			Expect(err).Should(BeNil())
+			Expect(transaction.BlockHeight).Should(Equal(int64(2)))
+			client.Mine(1)
+			transaction, err = cached.GetRawTransaction(context.Background(), txHash)
+			Expect(err).Should(BeNil())
+			Expect(transaction.BlockHeight).Should(Equal(int64(2)))
+		})
+	})
+
+	Context("when sending with a deadline", func() {
+		It("should bump the fee until a replacement confirms", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			client.SetFeeRate(5)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 42000)
+			Expect(err).Should(BeNil())
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+
+			done := make(chan string)
+			go func() {
+				defer GinkgoRecover()
+				txHash, err := account.SendWithDeadline(context.Background(), map[string]int64{recipient.EncodeAddress(): 40000}, time.Now().Add(2*time.Second))
+				Expect(err).Should(BeNil())
+				done <- txHash
+			}()
+			Eventually(func() int { return len(client.Published()) }, "2s", "10ms").Should(Equal(2))
+			client.Mine(1)
+			var txHash string
+			Eventually(done, "2s").Should(Receive(&txHash))
+
+			// The first transaction pays the estimated fee rate, and its
+			// replacement drops the change once it would be dust.
+			first, err := DecodeTransaction(client.Published()[0])
+			Expect(err).Should(BeNil())
+			Expect(first.TxOut).Should(HaveLen(2))
+			Expect(42000 - first.TxOut[0].Value - first.TxOut[1].Value).Should(BeNumerically("~", 5*first.SerializeSize(), 10))
+			_, err = client.GetRawTransaction(context.Background(), first.TxHash().String())
+			Expect(errors.Is(err, ErrNotFound)).Should(BeTrue())
+			replacement, err := DecodeTransaction(client.Published()[1])
+			Expect(err).Should(BeNil())
+			Expect(replacement.TxHash().String()).Should(Equal(txHash))
+			Expect(replacement.TxOut).Should(HaveLen(1))
+			Expect(replacement.TxOut[0].Value).Should(Equal(int64(40000)))
+		})
+
+		It("should bump low fee rates by at least the minimum relay fee", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+
+			done := make(chan string)
+			go func() {
+				defer GinkgoRecover()
+				txHash, err := account.SendWithDeadline(context.Background(), map[string]int64{recipient.EncodeAddress(): 40000}, time.Now().Add(2*time.Second))
+				Expect(err).Should(BeNil())
+				done <- txHash
+			}()
+			Eventually(func() int { return len(client.Published()) }, "2s", "10ms").Should(Equal(2))
+			client.Mine(1)
+			Eventually(done, "2s").Should(Receive())
+
+			fee := func(msgTx *wire.MsgTx) int64 {
+				fee := int64(100000)
+				for _, txOut := range msgTx.TxOut {
+					fee = fee - txOut.Value
+				}
+				return fee
+			}
+			first, err := DecodeTransaction(client.Published()[0])
+			Expect(err).Should(BeNil())
+			replacement, err := DecodeTransaction(client.Published()[1])
+			Expect(err).Should(BeNil())
+			Expect(fee(first)).Should(BeNumerically("~", first.SerializeSize(), 10))
+			Expect(fee(replacement)).Should(BeNumerically(">=", fee(first)+int64(replacement.SerializeSize())))
+		})
+	})
+
+	Context("when cancelling transactions", func() {
+		It("should spend the same outpoints back to the account", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			script, err := txscript.PayToAddrScript(addr)
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 4000000)
+			Expect(err).Should(BeNil())
+
+			// Spend an output whose index does not fit in a byte.
+			fundHash, err := account.SendTransaction(context.Background(), nil, 10000, nil, func(msgTx *wire.MsgTx) bool {
+				for i := 0; i < 300; i++ {
+					msgTx.AddTxOut(wire.NewTxOut(10000, script))
+				}
+				return true
+			}, nil, nil)
+			Expect(err).Should(BeNil())
+			client.Mine(1)
+			hash, err := chainhash.NewHashFromStr(fundHash)
+			Expect(err).Should(BeNil())
+			outpoint := wire.NewOutPoint(hash, 299)
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			recipientScript, err := txscript.PayToAddrScript(recipient)
+			Expect(err).Should(BeNil())
+			msgTx := wire.NewMsgTx(2)
+			txIn := wire.NewTxIn(outpoint, nil, nil)
+			txIn.Sequence = 0xfffffffd
+			msgTx.AddTxIn(txIn)
+			msgTx.AddTxOut(wire.NewTxOut(9000, recipientScript))
+			Expect(account.SignTransaction(msgTx, []UnspentOutput{{
+				TransactionHash:         hex.EncodeToString(hash[:]),
+				TransactionOutputNumber: 299,
+				ScriptPubKey:            hex.EncodeToString(script),
+				Amount:                  10000,
+			}}, nil, nil)).Should(BeNil())
+			stx, _, err := SerializeTransaction(msgTx)
+			Expect(err).Should(BeNil())
+			Expect(client.PublishTransaction(context.Background(), stx)).Should(BeNil())
+
+			cancelHash, err := account.CancelTransaction(context.Background(), msgTx.TxHash().String(), 20)
+			Expect(err).Should(BeNil())
+			stx, err = client.GetSerializedTransaction(context.Background(), cancelHash)
+			Expect(err).Should(BeNil())
+			cancel, err := DecodeTransaction(stx)
+			Expect(err).Should(BeNil())
+			Expect(cancel.TxIn).Should(HaveLen(1))
+			Expect(cancel.TxIn[0].PreviousOutPoint).Should(Equal(*outpoint))
+			Expect(cancel.TxOut).Should(HaveLen(1))
+			Expect(cancel.TxOut[0].PkScript).Should(Equal(script))
+			// The size of a signature varies by a byte between signings.
+			Expect(10000 - cancel.TxOut[0].Value).Should(BeNumerically("~", 20*cancel.SerializeSize(), 20))
+			funded, _, err := client.ScriptFunded(context.Background(), recipient.EncodeAddress(), 9000)
+			Expect(err).Should(BeNil())
+			Expect(funded).Should(BeFalse())
+		})
+	})
+
+	Context("when rate limiting requests", func() {
+		It("should wait for the limiter before every request", func() {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				fmt.Fprint(w, `{"unspent_outputs":[]}`)
+			}))
+			defer server.Close()
+			client := NewBlockchainInfoClient("testnet", WithURL(server.URL), RateLimit(20, 1))
+
+			start := time.Now()
+			for i := 0; i < 4; i++ {
+				_, err := client.GetUnspentOutputsPage(context.Background(), "address", 0, 10, 0)
+				Expect(err).Should(BeNil())
+			}
+			Expect(requests).Should(Equal(4))
+			// The first request uses the burst, and each other request
+			// waits for a token.
+			Expect(time.Since(start)).Should(BeNumerically(">=", 140*time.Millisecond))
+
+			// Requests that cannot get a token before the context is done
+			// are not made.
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+			defer cancel()
+			_, err := client.GetUnspentOutputsPage(ctx, "address", 0, 10, 0)
+			Expect(err).Should(Equal(ErrTimedOut))
+			Expect(requests).Should(Equal(4))
+		})
+	})
+
+	Context("when fetching block headers", func() {
+		It("should decode the header fields of blockchain.info blocks", func() {
+			hash := "000000000000000000024c4a35f0485bab79ce341cdd5cc6b15186d9b5b57bf3"
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/rawblock/" + hash:
+					fmt.Fprintf(w, `{"hash":"%s","ver":2,"prev_block":"00","mrkl_root":"11","time":1600000000,"bits":386863986,"nonce":42,"height":100,"main_chain":true,"tx":[{"hash":"22"}]}`, hash)
+				case "/block-height/100":
+					fmt.Fprintf(w, `{"blocks":[{"hash":"33","height":100,"main_chain":false},{"hash":"%s","height":100,"main_chain":true}]}`, hash)
+				case "/block-height/101":
+					fmt.Fprint(w, `{"blocks":[{"hash":"33","height":101,"main_chain":false}]}`)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+			client := NewBlockchainInfoClient("testnet", WithURL(server.URL))
+
+			header, err := client.GetBlockHeader(context.Background(), hash)
+			Expect(err).Should(BeNil())
+			Expect(header).Should(Equal(BlockHeader{
+				BlockHash:         hash,
+				Version:           2,
+				PreviousBlockHash: "00",
+				MerkleRoot:        "11",
+				Time:              1600000000,
+				Bits:              386863986,
+				Nonce:             42,
+				Height:            100,
+				MainChain:         true,
+			}))
+
+			// Only the block on the main chain is returned.
+			header, err = client.GetBlockHeaderByHeight(context.Background(), 100)
+			Expect(err).Should(BeNil())
+			Expect(header.BlockHash).Should(Equal(hash))
+			_, err = client.GetBlockHeaderByHeight(context.Background(), 101)
+			Expect(err).ShouldNot(BeNil())
+		})
+	})
+
+	Context("when choosing the broadcast encoding", func() {
+		It("should only serialize witness transactions with the witness encoding", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA(), WithAddressType(AddressTypeNativeSegWit))
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 10000, 1000, false, WithEncoding(wire.BaseEncoding))
+			Expect(err).Should(Equal(ErrWitnessEncodingRequired))
+			Expect(client.Published()).Should(BeEmpty())
+
+			txHash, err := account.Transfer(context.Background(), recipient.EncodeAddress(), 10000, 1000, false)
+			Expect(err).Should(BeNil())
+			Expect(client.Published()).Should(HaveLen(1))
+			msgTx := wire.NewMsgTx(2)
+			Expect(msgTx.BtcDecode(bytes.NewReader(client.Published()[0]), wire.ProtocolVersion, wire.WitnessEncoding)).Should(BeNil())
+			Expect(msgTx.HasWitness()).Should(BeTrue())
+			Expect(msgTx.TxHash().String()).Should(Equal(txHash))
+		})
+	})
+
+	Context("when listing spendable outputs", func() {
+		It("should describe every output with enough confirmations", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			confirmedTxid, err := client.Fund(addr.EncodeAddress(), 50000)
+			Expect(err).Should(BeNil())
+			client.Mine(1)
+			_, err = client.Fund(addr.EncodeAddress(), 20000)
+			Expect(err).Should(BeNil())
+
+			spendable, err := account.ListSpendable(context.Background(), 0)
+			Expect(err).Should(BeNil())
+			Expect(spendable).Should(HaveLen(2))
+			spendable, err = account.ListSpendable(context.Background(), 2)
+			Expect(err).Should(BeNil())
+			Expect(spendable).Should(Equal([]SpendableUTXO{{
+				TransactionHash: confirmedTxid,
+				Vout:            0,
+				Value:           50000,
+				Confirmations:   2,
+				Address:         addr.EncodeAddress(),
+				ScriptType:      txscript.PubKeyHashTy.String(),
+			}}))
+		})
+	})
+
+	Context("when injecting a retry policy", func() {
+		It("should consult the policy after every failed request", func() {
+			statuses := []int{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if len(statuses) == 0 {
+					fmt.Fprint(w, `{"unspent_outputs":[]}`)
+					return
+				}
+				w.WriteHeader(statuses[0])
+				statuses = statuses[1:]
+			}))
+			defer server.Close()
+			attempts := []int{}
+			seen := []int{}
+			// Retry rate limits forever, and give up on anything else.
+			policy := func(attempt int, err error, resp *http.Response) (bool, time.Duration) {
+				attempts = append(attempts, attempt)
+				seen = append(seen, resp.StatusCode)
+				return resp.StatusCode == http.StatusTooManyRequests, time.Millisecond
+			}
+			client := NewBlockchainInfoClient("testnet", WithURL(server.URL), WithRetryPolicy(policy))
+
+			statuses = []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests}
+			_, err := client.GetUnspentOutputsPage(context.Background(), "address", 0, 10, 0)
+			Expect(err).Should(BeNil())
+			Expect(attempts).Should(Equal([]int{0, 1, 2}))
+			Expect(seen).Should(Equal([]int{429, 429, 429}))
+
+			attempts, seen = nil, nil
+			statuses = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+			_, err = client.GetUnspentOutputsPage(context.Background(), "address", 0, 10, 0)
+			Expect(err).Should(Equal(NewErrUnexpectedStatus(http.StatusServiceUnavailable, "")))
+			Expect(attempts).Should(Equal([]int{0}))
+			Expect(seen).Should(Equal([]int{503}))
+			Expect(statuses).Should(HaveLen(1))
+		})
+	})
+
+	Context("when funding needs more than a page of unspent outputs", func() {
+		It("should fetch further pages until the target is covered", func() {
+			mockClient := mock.NewClient(&chaincfg.TestNet3Params)
+			client := &countingClient{Client: mockClient}
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			// SegWit signatures keep signing a thousand inputs fast.
+			account := NewAccount(client, key.ToECDSA(), WithAddressType(AddressTypeNativeSegWit))
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			for i := 0; i < 1001; i++ {
+				_, err = mockClient.Fund(addr.EncodeAddress(), 1000)
+				Expect(err).Should(BeNil())
+			}
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 1000000, 1000, false)
+			Expect(err).Should(BeNil())
+			Expect(client.unspentRequests).Should(Equal(2))
+			msgTx, err := DecodeTransaction(mockClient.Published()[0])
+			Expect(err).Should(BeNil())
+			Expect(msgTx.TxIn).Should(HaveLen(1001))
+			Expect(msgTx.TxOut).Should(HaveLen(1))
+		})
+	})
+
+	Context("when sending to a used address", func() {
+		It("should warn about the reuse without blocking the transfer", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			warnings := []string{}
+			warn := func(address string) {
+				warnings = append(warnings, address)
+			}
+
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 10000, 1000, false, AllowUnconfirmed(), WithReuseWarning(warn))
+			Expect(err).Should(BeNil())
+			Expect(warnings).Should(BeEmpty())
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 10000, 1000, false, AllowUnconfirmed(), WithReuseWarning(warn))
+			Expect(err).Should(BeNil())
+			Expect(warnings).Should(Equal([]string{recipient.EncodeAddress()}))
+			Expect(client.Published()).Should(HaveLen(2))
+		})
+	})
+
+	Context("when building a transaction for external broadcast", func() {
+		It("should sign with the absolute fee without publishing", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+
+			raw, txHash, err := account.BuildAndSign(context.Background(), map[string]int64{recipient.EncodeAddress(): 40000}, 2500)
+			Expect(err).Should(BeNil())
+			Expect(client.Published()).Should(BeEmpty())
+			msgTx, err := DecodeTransaction(raw)
+			Expect(err).Should(BeNil())
+			Expect(msgTx.TxHash().String()).Should(Equal(txHash))
+			var out int64
+			for _, txOut := range msgTx.TxOut {
+				out = out + txOut.Value
+			}
+			Expect(100000 - out).Should(Equal(int64(2500)))
+
+			// A gateway broadcasts the transaction.
+			Expect(client.PublishTransaction(context.Background(), raw)).Should(BeNil())
+			client.Mine(1)
+			confirmations, err := client.Confirmations(context.Background(), txHash)
+			Expect(err).Should(BeNil())
+			Expect(confirmations).Should(Equal(int64(1)))
+			balance, err := client.Balance(context.Background(), recipient.EncodeAddress(), 1)
+			Expect(err).Should(BeNil())
+			Expect(balance).Should(Equal(int64(40000)))
+		})
+	})
+
+	Context("when limiting the fee to a share of the amount", func() {
+		It("should refuse fees above the percentage, excluding the change", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 4000, 1000, false, MaxFeePercent(20))
+			Expect(err).Should(Equal(ErrFeeExceedsPercentOfAmount))
+			Expect(client.Published()).Should(BeEmpty())
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 5000, 1000, false, MaxFeePercent(20))
+			Expect(err).Should(BeNil())
+			Expect(client.Published()).Should(HaveLen(1))
+		})
+
+		It("should still exclude the change after a post fund hook adds outputs", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = client.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			nullData, err := txscript.NullDataScript([]byte("memo"))
+			Expect(err).Should(BeNil())
+			addMemo := WithPostFund(func(msgTx *wire.MsgTx) {
+				msgTx.AddTxOut(wire.NewTxOut(0, nullData))
+			})
+
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 4000, 1000, false, addMemo, MaxFeePercent(20))
+			Expect(err).Should(Equal(ErrFeeExceedsPercentOfAmount))
+			Expect(client.Published()).Should(BeEmpty())
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 5000, 1000, false, addMemo, MaxFeePercent(20))
+			Expect(err).Should(BeNil())
+			Expect(client.Published()).Should(HaveLen(1))
+		})
+	})
+
+	Context("when reporting the inputs of a transaction", func() {
+		It("should expose the value spent by every input", func() {
+			client := mock.NewClient(&chaincfg.TestNet3Params)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			values := map[string]int64{}
+			for _, value := range []int64{30000, 40000} {
+				txid, err := client.Fund(addr.EncodeAddress(), value)
+				Expect(err).Should(BeNil())
+				values[txid] = value
+			}
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+
+			result := TxResult{}
+			txHash, err := account.Transfer(context.Background(), recipient.EncodeAddress(), 60000, 1500, false, WithTxResult(&result), BIP69Sort())
+			Expect(err).Should(BeNil())
+			Expect(result.TxHash).Should(Equal(txHash))
+			msgTx, err := DecodeTransaction(client.Published()[0])
+			Expect(err).Should(BeNil())
+			Expect(result.Inputs).Should(HaveLen(2))
+			Expect(result.InputValues).Should(HaveLen(2))
+			for i, txIn := range msgTx.TxIn {
+				Expect(result.Inputs[i]).Should(Equal(txIn.PreviousOutPoint))
+				Expect(result.InputValues[i]).Should(Equal(values[txIn.PreviousOutPoint.Hash.String()]))
+			}
+			var out int64
+			for _, txOut := range msgTx.TxOut {
+				out = out + txOut.Value
+			}
+			Expect(result.TotalInput).Should(Equal(int64(70000)))
+			Expect(result.TotalInput - out).Should(Equal(int64(1500)))
+			Expect(result.Fee).Should(Equal(int64(1500)))
+		})
+	})
+
+	Context("when verifying the txid of a broadcast transaction", func() {
+		It("should return ErrTxidMismatch if the transaction that landed differs", func() {
+			mockClient := mock.NewClient(&chaincfg.TestNet3Params)
+			client := &malleatingClient{Client: mockClient}
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			Expect(err).Should(BeNil())
+			account := NewAccount(client, key.ToECDSA())
+			addr, err := account.Address()
+			Expect(err).Should(BeNil())
+			_, err = mockClient.Fund(addr.EncodeAddress(), 100000)
+			Expect(err).Should(BeNil())
+			recipient, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("recipient")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+
+			txHash, err := account.Transfer(context.Background(), recipient.EncodeAddress(), 10000, 1000, false, AllowUnconfirmed(), VerifyTxid(""))
+			Expect(err).Should(BeNil())
+
+			// A transaction with a different txid is not broadcast.
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 10000, 1000, false, AllowUnconfirmed(), VerifyTxid(txHash))
+			Expect(err).Should(Equal(ErrTxidMismatch))
+			Expect(mockClient.Published()).Should(HaveLen(1))
+
+			client.malleate = true
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 10000, 1000, false, AllowUnconfirmed(), VerifyTxid(""))
+			Expect(err).Should(Equal(ErrTxidMismatch))
+			Expect(mockClient.Published()).Should(HaveLen(2))
+
+			// A transaction that cannot be found is not reported as a
+			// mismatch.
+			client.malleate = false
+			client.hide = true
+			_, err = account.Transfer(context.Background(), recipient.EncodeAddress(), 10000, 1000, false, AllowUnconfirmed(), VerifyTxid(""), WithPollInterval(time.Millisecond))
+			Expect(err).Should(Equal(ErrTxidUnverified))
+			Expect(mockClient.Published()).Should(HaveLen(3))
+		})
+	})
+
+	Context("when computing the balance change over a block range", func() {
+		It("should only count confirmed transactions in range", func() {
+			addr, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("address")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			script, err := txscript.PayToAddrScript(addr)
+			Expect(err).Should(BeNil())
+			other, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160([]byte("other")), &chaincfg.TestNet3Params)
+			Expect(err).Should(BeNil())
+			otherScript, err := txscript.PayToAddrScript(other)
+			Expect(err).Should(BeNil())
+			receive := func(height int64, value uint64) Transaction {
+				return Transaction{
+					BlockHeight: height,
+					Inputs:      []Input{{PrevOut: PreviousOut{Address: other.EncodeAddress(), Value: value}}},
+					Outputs:     []Output{{Value: value, Script: hex.EncodeToString(script)}},
+				}
+			}
+			// Newest first, as returned by blockchain.info.
+			txs := []Transaction{
+				receive(0, 1000),
+				receive(120, 2000),
+				receive(105, 50000),
+				{
+					BlockHeight: 102,
+					Inputs:      []Input{{PrevOut: PreviousOut{Address: addr.EncodeAddress(), Value: 30000}}},
+					Outputs: []Output{
+						{Value: 19000, Script: hex.EncodeToString(otherScript)},
+						{Value: 10000, Script: hex.EncodeToString(script)},
+					},
+				},
+				receive(99, 4000),
+			}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.URL.Path).Should(Equal("/rawaddr/" + addr.EncodeAddress()))
+				offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+				Expect(err).Should(BeNil())
+				end := offset + 2
+				if end > len(txs) {
+					end = len(txs)
+				}
+				Expect(json.NewEncoder(w).Encode(SingleAddress{TransactionCount: int64(len(txs)), Transactions: txs[offset:end]})).Should(BeNil())
+			}))
+			defer server.Close()
+			client := NewBlockchainInfoClient("testnet", WithURL(server.URL))
+
+			delta, err := client.BalanceDelta(context.Background(), addr.EncodeAddress(), 100, 110)
+			Expect(err).Should(BeNil())
+			Expect(delta).Should(Equal(int64(50000 - 30000 + 10000)))
+			delta, err = client.BalanceDelta(context.Background(), addr.EncodeAddress(), 0, 200)
+			Expect(err).Should(BeNil())
+			Expect(delta).Should(Equal(int64(2000 + 50000 - 30000 + 10000 + 4000)))
+		})
+	})
+
+})
+
+type countingSigner struct {
+	key   *btcec.PrivateKey
+	signs int
+}
+
+func (signer *countingSigner) Sign(hash []byte) ([]byte, error) {
+	signer.signs++
+	sig, err := signer.key.Sign(hash)
+	if err != nil {
+		return nil, err
+	}
+	return sig.Serialize(), nil
+}
+
+func (signer *countingSigner) PublicKey() []byte {
+	return signer.key.PubKey().SerializeCompressed()
+}
+
+type unspentClient struct {
+	Client
+	utxos Unspent
+}
+
+func (client *unspentClient) GetUnspentOutputsPage(ctx context.Context, address string, offset, limit, confirmations int64) (Unspent, error) {
+	if offset > 0 {
+		return Unspent{}, nil
+	}
+	return client.utxos, nil
+}
+
+type recordingLogger struct {
+	messages []string
+}
+
+func (logger *recordingLogger) Debugf(format string, args ...interface{}) {
+	logger.messages = append(logger.messages, fmt.Sprintf(format, args...))
+}
+
+type countingClient struct {
+	Client
+	unspentRequests int
+}
+
+func (client *countingClient) GetUnspentOutputs(ctx context.Context, address string, limit, confirmations int64) (Unspent, error) {
+	client.unspentRequests++
+	return client.Client.GetUnspentOutputs(ctx, address, limit, confirmations)
+}
+
+func (client *countingClient) GetUnspentOutputsPage(ctx context.Context, address string, offset, limit, confirmations int64) (Unspent, error) {
+	client.unspentRequests++
+	return client.Client.GetUnspentOutputsPage(ctx, address, offset, limit, confirmations)
+}
+
+type fakeCacheStore struct {
+	values map[string][]byte
+	sets   int
+}
+
+func (store *fakeCacheStore) Get(key string) ([]byte, bool, error) {
+	value, ok := store.values[key]
+	return value, ok, nil
+}
+
+func (store *fakeCacheStore) Set(key string, value []byte) error {
+	store.sets++
+	store.values[key] = value
+	return nil
+}
+
+func (store *fakeCacheStore) Delete(key string) error {
+	delete(store.values, key)
+	return nil
+}
+
+// malleatingClient reports a different hash for every transaction it returns
+// once malleate is set, as if the transactions had been malleated.
+type malleatingClient struct {
+	Client
+	malleate bool
+	hide     bool
+}
+
+// GetAddressTransactions changes the txids of the transactions of the address
+// if malleate is true, and hides them if hide is true.
+func (client *malleatingClient) GetAddressTransactions(ctx context.Context, addr string, offset, limit int) ([]Transaction, error) {
+	txs, err := client.Client.GetAddressTransactions(ctx, addr, offset, limit)
+	if client.hide {
+		return nil, err
+	}
+	if client.malleate {
+		for i := range txs {
+			txs[i].TransactionHash = strings.Repeat("0", 64)
+		}
+	}
+	return txs, err
+}
@@ -0,0 +1,612 @@
+// Package mock provides an in-memory libbtc.Client, so that transactions can
+// be built, signed and published in tests without network access.
+package mock
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/republicprotocol/libbtc-go"
+)
+
+// ErrMissingInput is returned when a published transaction spends an output
+// that does not exist, or has already been spent.
+var ErrMissingInput = errors.New("transaction spends a missing or spent output")
+
+// pollInterval is the interval at which the Client checks for confirmations
+// while waiting for them.
+const pollInterval = 10 * time.Millisecond
+
+// Client is an in-memory libbtc.Client. Outputs are created using Fund, and
+// by publishing transactions, which are confirmed using Mine.
+type Client struct {
+	mu        *sync.RWMutex
+	params    *chaincfg.Params
+	height    int64
+	txs       map[chainhash.Hash]*wire.MsgTx
+	txHeights map[chainhash.Hash]int64
+	unspent   map[wire.OutPoint]bool
+	spentBy   map[wire.OutPoint]chainhash.Hash
+	published [][]byte
+	feeRate   int64
+}
+
+// NewClient returns an empty Client for the given network.
+func NewClient(params *chaincfg.Params) *Client {
+	return &Client{
+		mu:        new(sync.RWMutex),
+		params:    params,
+		height:    1,
+		txs:       map[chainhash.Hash]*wire.MsgTx{},
+		txHeights: map[chainhash.Hash]int64{},
+		unspent:   map[wire.OutPoint]bool{},
+		spentBy:   map[wire.OutPoint]chainhash.Hash{},
+		feeRate:   1,
+	}
+}
+
+// SetFeeRate sets the fee rate returned by EstimateFeeRate, which is 1 SAT
+// per byte by default.
+func (client *Client) SetFeeRate(feeRate int64) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.feeRate = feeRate
+}
+
+// Fund creates a confirmed output paying the value to the address, and
+// returns the hash of the transaction that created it.
+func (client *Client) Fund(address string, value int64) (string, error) {
+	addr, err := btcutil.DecodeAddress(address, client.params)
+	if err != nil {
+		return "", err
+	}
+	script, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return "", err
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	msgTx := wire.NewMsgTx(2)
+	// Every funding transaction spends a unique coinbase-like input, so that
+	// funding the same address twice creates two transactions.
+	var index [4]byte
+	binary.LittleEndian.PutUint32(index[:], uint32(len(client.txs)))
+	msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex), index[:], nil))
+	msgTx.AddTxOut(wire.NewTxOut(value, script))
+	client.addTx(msgTx, client.height)
+	return msgTx.TxHash().String(), nil
+}
+
+// Mine confirms all unconfirmed transactions in a new block, and then adds
+// n-1 empty blocks.
+func (client *Client) Mine(n int64) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if n <= 0 {
+		return
+	}
+	client.height++
+	for hash, height := range client.txHeights {
+		if height == 0 {
+			client.txHeights[hash] = client.height
+		}
+	}
+	client.height += n - 1
+}
+
+// Published returns the serialized transactions published to the Client, in
+// the order they were published.
+func (client *Client) Published() [][]byte {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	return append([][]byte{}, client.published...)
+}
+
+func (client *Client) NetworkParams() *chaincfg.Params {
+	return client.params
+}
+
+func (client *Client) GetUnspentOutputs(ctx context.Context, address string, limit, confirmations int64) (libbtc.Unspent, error) {
+	return client.GetUnspentOutputsPage(ctx, address, 0, limit, confirmations)
+}
+
+func (client *Client) GetUnspentOutputsPage(ctx context.Context, address string, offset, limit, confirmations int64) (libbtc.Unspent, error) {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	utxos := libbtc.Unspent{}
+	outPoints := make([]wire.OutPoint, 0, len(client.unspent))
+	for outPoint := range client.unspent {
+		outPoints = append(outPoints, outPoint)
+	}
+	sort.Slice(outPoints, func(i, j int) bool {
+		if outPoints[i].Hash != outPoints[j].Hash {
+			return bytes.Compare(outPoints[i].Hash[:], outPoints[j].Hash[:]) < 0
+		}
+		return outPoints[i].Index < outPoints[j].Index
+	})
+	for _, outPoint := range outPoints {
+		txOut := client.txs[outPoint.Hash].TxOut[outPoint.Index]
+		if !client.paysTo(txOut, address) {
+			continue
+		}
+		utxoConfirmations := client.confirmations(outPoint.Hash)
+		if utxoConfirmations < confirmations {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if limit > 0 && int64(len(utxos.Outputs)) >= limit {
+			break
+		}
+		utxos.Outputs = append(utxos.Outputs, libbtc.UnspentOutput{
+			TransactionHash:         hex.EncodeToString(outPoint.Hash[:]),
+			TransactionOutputNumber: outPoint.Index,
+			ScriptPubKey:            hex.EncodeToString(txOut.PkScript),
+			Amount:                  txOut.Value,
+			Confirmations:           utxoConfirmations,
+		})
+	}
+	return utxos, nil
+}
+
+func (client *Client) GetRawTransaction(ctx context.Context, txhash string) (libbtc.Transaction, error) {
+	hash, err := chainhash.NewHashFromStr(txhash)
+	if err != nil {
+		return libbtc.Transaction{}, err
+	}
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	msgTx, ok := client.txs[*hash]
+	if !ok {
+		return libbtc.Transaction{}, libbtc.NewErrUnexpectedStatus(404, "Transaction not found")
+	}
+	return client.transaction(msgTx), nil
+}
+
+func (client *Client) GetSerializedTransaction(ctx context.Context, txhash string) ([]byte, error) {
+	hash, err := chainhash.NewHashFromStr(txhash)
+	if err != nil {
+		return nil, err
+	}
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	msgTx, ok := client.txs[*hash]
+	if !ok {
+		return nil, libbtc.NewErrUnexpectedStatus(404, "Transaction not found")
+	}
+	buf := new(bytes.Buffer)
+	if err := msgTx.Serialize(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (client *Client) GetRawAddressInformation(ctx context.Context, addr string) (libbtc.SingleAddress, error) {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	addressInfo := libbtc.SingleAddress{Address: addr}
+	for _, msgTx := range client.txs {
+		touched := false
+		for _, txIn := range msgTx.TxIn {
+			if prevOut, ok := client.prevOut(txIn); ok && client.paysTo(prevOut, addr) {
+				addressInfo.Sent = addressInfo.Sent + prevOut.Value
+				touched = true
+			}
+		}
+		for _, txOut := range msgTx.TxOut {
+			if client.paysTo(txOut, addr) {
+				addressInfo.Received = addressInfo.Received + txOut.Value
+				touched = true
+			}
+		}
+		if touched {
+			addressInfo.Transactions = append(addressInfo.Transactions, client.transaction(msgTx))
+		}
+	}
+	// Order the transactions newest first, like blockchain.info.
+	sort.SliceStable(addressInfo.Transactions, func(i, j int) bool {
+		hi, hj := addressInfo.Transactions[i].BlockHeight, addressInfo.Transactions[j].BlockHeight
+		if hi == 0 || hj == 0 {
+			return hi == 0 && hj != 0
+		}
+		return hi > hj
+	})
+	addressInfo.TransactionCount = int64(len(addressInfo.Transactions))
+	addressInfo.Balance = addressInfo.Received - addressInfo.Sent
+	return addressInfo, nil
+}
+
+// GetAddressTransactions returns up to limit transactions of the address,
+// newest first, skipping the first offset transactions.
+func (client *Client) GetAddressTransactions(ctx context.Context, addr string, offset, limit int) ([]libbtc.Transaction, error) {
+	addressInfo, err := client.GetRawAddressInformation(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	txs := addressInfo.Transactions
+	if offset >= len(txs) {
+		return []libbtc.Transaction{}, nil
+	}
+	txs = txs[offset:]
+	if limit > 0 && limit < len(txs) {
+		txs = txs[:limit]
+	}
+	return txs, nil
+}
+
+// PublishTransaction spends the outputs spent by the transaction, and creates
+// its outputs as unconfirmed outputs. Unconfirmed transactions that signal
+// BIP125 replace-by-fee are replaced by transactions spending the same
+// outputs.
+func (client *Client) PublishTransaction(ctx context.Context, signedTransaction []byte) error {
+	msgTx := wire.NewMsgTx(2)
+	if err := msgTx.Deserialize(bytes.NewReader(signedTransaction)); err != nil {
+		return libbtc.NewErrBitcoinSubmitTx(err.Error())
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	replaced := map[chainhash.Hash]bool{}
+	for _, txIn := range msgTx.TxIn {
+		if client.unspent[txIn.PreviousOutPoint] {
+			continue
+		}
+		spender, ok := client.spentBy[txIn.PreviousOutPoint]
+		if !ok || !client.replaceable(spender) {
+			return libbtc.NewErrBitcoinSubmitTx(ErrMissingInput.Error())
+		}
+		replaced[spender] = true
+	}
+	for hash := range replaced {
+		client.removeTx(hash)
+	}
+	for _, txIn := range msgTx.TxIn {
+		delete(client.unspent, txIn.PreviousOutPoint)
+		client.spentBy[txIn.PreviousOutPoint] = msgTx.TxHash()
+	}
+	client.addTx(msgTx, 0)
+	client.published = append(client.published, signedTransaction)
+	return nil
+}
+
+func (client *Client) Balance(ctx context.Context, address string, confirmations int64) (int64, error) {
+	utxos, err := client.GetUnspentOutputs(ctx, address, 0, confirmations)
+	if err != nil {
+		return 0, err
+	}
+	balance := int64(0)
+	for _, utxo := range utxos.Outputs {
+		balance = balance + utxo.Amount
+	}
+	return balance, nil
+}
+
+// BalanceDetails returns the value of the confirmed and unconfirmed unspent
+// outputs of the address.
+func (client *Client) BalanceDetails(ctx context.Context, address string) (int64, int64, error) {
+	utxos, err := client.GetUnspentOutputs(ctx, address, 0, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	confirmed, unconfirmed := int64(0), int64(0)
+	for _, utxo := range utxos.Outputs {
+		if utxo.Confirmations > 0 {
+			confirmed = confirmed + utxo.Amount
+		} else {
+			unconfirmed = unconfirmed + utxo.Amount
+		}
+	}
+	return confirmed, unconfirmed, nil
+}
+
+func (client *Client) ScriptSpent(ctx context.Context, address string) (bool, error) {
+	rawAddress, err := client.GetRawAddressInformation(ctx, address)
+	if err != nil {
+		return false, err
+	}
+	return rawAddress.Sent > 0, nil
+}
+
+func (client *Client) HasBeenUsed(ctx context.Context, address string) (bool, error) {
+	rawAddress, err := client.GetRawAddressInformation(ctx, address)
+	if err != nil {
+		return false, err
+	}
+	return rawAddress.TransactionCount > 0, nil
+}
+
+func (client *Client) BalanceDelta(ctx context.Context, address string, fromHeight, toHeight int64) (int64, error) {
+	rawAddress, err := client.GetRawAddressInformation(ctx, address)
+	if err != nil {
+		return 0, err
+	}
+	delta := int64(0)
+	for _, tx := range rawAddress.Transactions {
+		if tx.BlockHeight == 0 || tx.BlockHeight < fromHeight || tx.BlockHeight > toHeight {
+			continue
+		}
+		for _, input := range tx.Inputs {
+			if input.PrevOut.Address == address {
+				delta = delta - int64(input.PrevOut.Value)
+			}
+		}
+		for _, output := range tx.Outputs {
+			addrs, err := output.Addresses(client.params)
+			if err != nil {
+				return 0, err
+			}
+			if len(addrs) > 0 && addrs[0] == address {
+				delta = delta + int64(output.Value)
+			}
+		}
+	}
+	return delta, nil
+}
+
+func (client *Client) ScriptFunded(ctx context.Context, address string, value int64) (bool, int64, error) {
+	rawAddress, err := client.GetRawAddressInformation(ctx, address)
+	if err != nil {
+		return false, 0, err
+	}
+	return rawAddress.Received >= value, rawAddress.Received, nil
+}
+
+func (client *Client) ScriptRedeemed(ctx context.Context, address string, value int64) (bool, int64, error) {
+	rawAddress, err := client.GetRawAddressInformation(ctx, address)
+	if err != nil {
+		return false, 0, err
+	}
+	return rawAddress.Received >= value && rawAddress.Balance == 0, rawAddress.Balance, nil
+}
+
+// GetScriptFromSpentP2SH returns the signature script of the first input
+// spending from the address. Unlike the other clients, it does not wait for
+// the address to be spent from.
+func (client *Client) GetScriptFromSpentP2SH(ctx context.Context, address string) ([]byte, error) {
+	rawAddress, err := client.GetRawAddressInformation(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	for _, tx := range rawAddress.Transactions {
+		for _, input := range tx.Inputs {
+			if input.PrevOut.Address == address {
+				return hex.DecodeString(input.Script)
+			}
+		}
+	}
+	return nil, libbtc.ErrNoSpendingTransactions
+}
+
+func (client *Client) Confirmations(ctx context.Context, txHash string) (int64, error) {
+	hash, err := chainhash.NewHashFromStr(txHash)
+	if err != nil {
+		return 0, err
+	}
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	if _, ok := client.txs[*hash]; !ok {
+		return 0, libbtc.NewErrUnexpectedStatus(404, "Transaction not found")
+	}
+	return client.confirmations(*hash), nil
+}
+
+// GetTransactionStatus returns the status of a transaction that has been
+// funded or published, and is not found otherwise.
+func (client *Client) GetTransactionStatus(ctx context.Context, txHash string) (libbtc.TxStatus, error) {
+	hash, err := chainhash.NewHashFromStr(txHash)
+	if err != nil {
+		return libbtc.TxStatus{}, err
+	}
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	if _, ok := client.txs[*hash]; !ok {
+		return libbtc.TxStatus{}, nil
+	}
+	height := client.txHeights[*hash]
+	if height == 0 {
+		return libbtc.TxStatus{Found: true, InMempool: true}, nil
+	}
+	return libbtc.TxStatus{
+		Found:         true,
+		Confirmations: client.confirmations(*hash),
+		BlockHeight:   height,
+	}, nil
+}
+
+func (client *Client) WaitForConfirmations(ctx context.Context, txHash string, n int64) error {
+	for {
+		confirmations, err := client.Confirmations(ctx, txHash)
+		if err != nil {
+			return err
+		}
+		if confirmations >= n {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return libbtc.ErrTimedOut
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func (client *Client) GetBlockHeight(ctx context.Context) (int64, error) {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	return client.height, nil
+}
+
+// EstimateFeeRate returns the fee rate set using SetFeeRate, for any target.
+func (client *Client) EstimateFeeRate(ctx context.Context, targetBlocks int) (int64, error) {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	return client.feeRate, nil
+}
+
+func (client *Client) GetBlockHeader(ctx context.Context, hash string) (libbtc.BlockHeader, error) {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	for height := int64(0); height <= client.height; height++ {
+		if blockHash(height).String() == hash {
+			return header(height), nil
+		}
+	}
+	return libbtc.BlockHeader{}, libbtc.NewErrBlockNotFound(hash)
+}
+
+func (client *Client) GetBlockHeaderByHeight(ctx context.Context, height int64) (libbtc.BlockHeader, error) {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	if height < 0 || height > client.height {
+		return libbtc.BlockHeader{}, libbtc.NewErrBlockNotFound(fmt.Sprintf("%d", height))
+	}
+	return header(height), nil
+}
+
+func (client *Client) FormatTransactionView(msg, txhash string) string {
+	return fmt.Sprintf("%s, transaction %s", msg, txhash)
+}
+
+// addTx stores the transaction at the given height (zero if unconfirmed),
+// and marks its outputs as unspent.
+func (client *Client) addTx(msgTx *wire.MsgTx, height int64) {
+	hash := msgTx.TxHash()
+	client.txs[hash] = msgTx
+	client.txHeights[hash] = height
+	for i := range msgTx.TxOut {
+		client.unspent[*wire.NewOutPoint(&hash, uint32(i))] = true
+	}
+}
+
+// replaceable returns true if the transaction is unconfirmed, and signals
+// BIP125 replace-by-fee.
+func (client *Client) replaceable(hash chainhash.Hash) bool {
+	if client.txHeights[hash] != 0 {
+		return false
+	}
+	for _, txIn := range client.txs[hash].TxIn {
+		if txIn.Sequence < wire.MaxTxInSequenceNum-1 {
+			return true
+		}
+	}
+	return false
+}
+
+// removeTx removes an unconfirmed transaction, making the outputs it spends
+// unspent again.
+func (client *Client) removeTx(hash chainhash.Hash) {
+	msgTx := client.txs[hash]
+	for i := range msgTx.TxOut {
+		delete(client.unspent, *wire.NewOutPoint(&hash, uint32(i)))
+	}
+	for _, txIn := range msgTx.TxIn {
+		delete(client.spentBy, txIn.PreviousOutPoint)
+		client.unspent[txIn.PreviousOutPoint] = true
+	}
+	delete(client.txs, hash)
+	delete(client.txHeights, hash)
+}
+
+func (client *Client) confirmations(hash chainhash.Hash) int64 {
+	height := client.txHeights[hash]
+	if height == 0 {
+		return 0
+	}
+	return client.height - height + 1
+}
+
+func (client *Client) prevOut(txIn *wire.TxIn) (*wire.TxOut, bool) {
+	prevTx, ok := client.txs[txIn.PreviousOutPoint.Hash]
+	if !ok || int(txIn.PreviousOutPoint.Index) >= len(prevTx.TxOut) {
+		return nil, false
+	}
+	return prevTx.TxOut[txIn.PreviousOutPoint.Index], true
+}
+
+func (client *Client) paysTo(txOut *wire.TxOut, address string) bool {
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(txOut.PkScript, client.params)
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if addr.EncodeAddress() == address {
+			return true
+		}
+	}
+	return false
+}
+
+// transaction converts the transaction into a libbtc.Transaction.
+func (client *Client) transaction(msgTx *wire.MsgTx) libbtc.Transaction {
+	hash := msgTx.TxHash()
+	transaction := libbtc.Transaction{
+		TransactionHash: hash.String(),
+		Version:         uint8(msgTx.Version),
+		VinSize:         uint32(len(msgTx.TxIn)),
+		VoutSize:        uint32(len(msgTx.TxOut)),
+		Size:            int64(msgTx.SerializeSize()),
+		BlockHeight:     client.txHeights[hash],
+	}
+	for _, txIn := range msgTx.TxIn {
+		input := libbtc.Input{
+			Script:   hex.EncodeToString(txIn.SignatureScript),
+			Sequence: txIn.Sequence,
+		}
+		if prevOut, ok := client.prevOut(txIn); ok {
+			input.PrevOut = libbtc.PreviousOut{
+				TransactionHash: txIn.PreviousOutPoint.Hash.String(),
+				Value:           uint64(prevOut.Value),
+				VoutNumber:      txIn.PreviousOutPoint.Index,
+			}
+			if _, addrs, _, err := txscript.ExtractPkScriptAddrs(prevOut.PkScript, client.params); err == nil && len(addrs) > 0 {
+				input.PrevOut.Address = addrs[0].EncodeAddress()
+			}
+		}
+		transaction.Inputs = append(transaction.Inputs, input)
+	}
+	for _, txOut := range msgTx.TxOut {
+		output := libbtc.Output{
+			Value:           uint64(txOut.Value),
+			TransactionHash: hash.String(),
+			Script:          hex.EncodeToString(txOut.PkScript),
+		}
+		if _, addrs, _, err := txscript.ExtractPkScriptAddrs(txOut.PkScript, client.params); err == nil && len(addrs) > 0 {
+			output.Address = addrs[0].EncodeAddress()
+		}
+		transaction.Outputs = append(transaction.Outputs, output)
+	}
+	return transaction
+}
+
+func blockHash(height int64) chainhash.Hash {
+	var heightBytes [8]byte
+	binary.LittleEndian.PutUint64(heightBytes[:], uint64(height))
+	return chainhash.DoubleHashH(heightBytes[:])
+}
+
+func header(height int64) libbtc.BlockHeader {
+	header := libbtc.BlockHeader{
+		BlockHash: blockHash(height).String(),
+		Height:    height,
+		MainChain: true,
+	}
+	if height > 0 {
+		header.PreviousBlockHash = blockHash(height - 1).String()
+	}
+	return header
+}
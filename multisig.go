@@ -0,0 +1,160 @@
+package libbtc
+
+import (
+	"bytes"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// maxMultisigKeys is the largest number of public keys in a standard multisig
+// script.
+const maxMultisigKeys = 16
+
+// BuildMultisigScript returns an m-of-n multisig redeem script for the
+// serialized public keys, in the given order.
+func BuildMultisigScript(pubKeys [][]byte, m int) ([]byte, error) {
+	if m < 1 || m > len(pubKeys) || len(pubKeys) > maxMultisigKeys {
+		return nil, NewErrInvalidMultisig(m, len(pubKeys))
+	}
+	addrs := make([]*btcutil.AddressPubKey, len(pubKeys))
+	for i, pubKey := range pubKeys {
+		// The network is not part of the script.
+		addr, err := btcutil.NewAddressPubKey(pubKey, &chaincfg.MainNetParams)
+		if err != nil {
+			return nil, err
+		}
+		addrs[i] = addr
+	}
+	return txscript.MultiSigScript(addrs, m)
+}
+
+// MultisigAddress returns the P2SH address of a multisig redeem script on the
+// given network.
+func MultisigAddress(redeemScript []byte, params *chaincfg.Params) (btcutil.Address, error) {
+	if txscript.GetScriptClass(redeemScript) != txscript.MultiSigTy {
+		return nil, ErrNotMultisig
+	}
+	return btcutil.NewAddressScriptHash(redeemScript, params)
+}
+
+// SignMultisigInput returns the signature of the account for the input of the
+// transaction, which spends the P2SH address of the multisig redeem script.
+// Each signer can sign independently, and the signatures are combined using
+// CombineSignatures.
+func (account *account) SignMultisigInput(msgTx *wire.MsgTx, inputIdx int, redeemScript []byte) ([]byte, error) {
+	if inputIdx < 0 || inputIdx >= len(msgTx.TxIn) {
+		return nil, NewErrInputIndex(inputIdx, len(msgTx.TxIn))
+	}
+	return account.rawTxInSignature(msgTx, inputIdx, redeemScript, txscript.SigHashAll)
+}
+
+// AddSignature signs the input of the transaction against the subscript, and
+// appends the signature to the signatures already in its signature script.
+// This allows several parties to sign the same input, one after another,
+// before the signature script is finalized with FinalizeSignatures. Adding a
+// signature that is already present has no effect.
+func (account *account) AddSignature(msgTx *wire.MsgTx, inputIdx int, subscript []byte) error {
+	if inputIdx < 0 || inputIdx >= len(msgTx.TxIn) {
+		return NewErrInputIndex(inputIdx, len(msgTx.TxIn))
+	}
+	sig, err := account.rawTxInSignature(msgTx, inputIdx, subscript, txscript.SigHashAll)
+	if err != nil {
+		return err
+	}
+	sigs, err := txscript.PushedData(msgTx.TxIn[inputIdx].SignatureScript)
+	if err != nil {
+		return err
+	}
+	builder := txscript.NewScriptBuilder()
+	for _, existing := range sigs {
+		if bytes.Equal(existing, sig) {
+			return nil
+		}
+		builder.AddData(existing)
+	}
+	builder.AddData(sig)
+	sigScript, err := builder.Script()
+	if err != nil {
+		return err
+	}
+	msgTx.TxIn[inputIdx].SignatureScript = sigScript
+	return nil
+}
+
+// FinalizeSignatures replaces the signatures accumulated in the signature
+// script of the input by AddSignature with the final signature script for
+// the multisig redeem script. Signatures are ordered to match the order of
+// the public keys in the redeem script, as required by OP_CHECKMULTISIG, and
+// the redeem script is pushed last. An error is returned if
+// fewer signatures than required by the redeem script are present.
+func FinalizeSignatures(msgTx *wire.MsgTx, inputIdx int, redeemScript []byte) error {
+	if inputIdx < 0 || inputIdx >= len(msgTx.TxIn) {
+		return NewErrInputIndex(inputIdx, len(msgTx.TxIn))
+	}
+	sigs, err := txscript.PushedData(msgTx.TxIn[inputIdx].SignatureScript)
+	if err != nil {
+		return err
+	}
+	return CombineSignatures(msgTx, inputIdx, redeemScript, sigs)
+}
+
+// CombineSignatures sets the signature script of the input to spend the
+// multisig redeem script using the given signatures, usually returned by
+// SignMultisigInput. The signatures can be in any order, and signatures that
+// do not match a public key of the redeem script are ignored.
+func CombineSignatures(msgTx *wire.MsgTx, inputIdx int, redeemScript []byte, sigs [][]byte) error {
+	_, required, err := txscript.CalcMultiSigStats(redeemScript)
+	if err != nil {
+		return err
+	}
+	pubKeys, err := txscript.PushedData(redeemScript)
+	if err != nil {
+		return err
+	}
+	hash, err := txscript.CalcSignatureHash(redeemScript, txscript.SigHashAll, msgTx, inputIdx)
+	if err != nil {
+		return err
+	}
+
+	builder := txscript.NewScriptBuilder()
+	// OP_CHECKMULTISIG pops one more item than it uses.
+	builder.AddOp(txscript.OP_0)
+	found := 0
+	for _, pubKeyBytes := range pubKeys {
+		if found == required {
+			break
+		}
+		pubKey, err := btcec.ParsePubKey(pubKeyBytes, btcec.S256())
+		if err != nil {
+			return err
+		}
+		for _, sig := range sigs {
+			if len(sig) == 0 {
+				continue
+			}
+			signature, err := btcec.ParseDERSignature(sig[:len(sig)-1], btcec.S256())
+			if err != nil {
+				continue
+			}
+			if signature.Verify(hash, pubKey) {
+				builder.AddData(sig)
+				found++
+				break
+			}
+		}
+	}
+	if found < required {
+		return NewErrNotEnoughSignatures(required, found)
+	}
+	builder.AddData(redeemScript)
+	sigScript, err := builder.Script()
+	if err != nil {
+		return err
+	}
+	msgTx.TxIn[inputIdx].SignatureScript = sigScript
+	return nil
+}
@@ -0,0 +1,351 @@
+package libbtc
+
+import (
+	"time"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// SendOption configures how a transaction is built by SendTransaction and
+// Transfer.
+type SendOption func(*sendOptions)
+
+type sendOptions struct {
+	bip69         bool
+	rbf           bool
+	selector      CoinSelector
+	encoding      wire.MessageEncoding
+	encodingSet   bool
+	change        ChangeAddressSelector
+	changeAddress string
+	reuse         func(address string)
+	maxFeePercent float64
+	maxFeeRate    int64
+	maxFee        int64
+	result        *TxResult
+	summary       *TxSummary
+	verifyTxid    bool
+	expectedTxid  string
+	verifyPrevOut bool
+
+	minConfirmations int64
+	allowUnconfirmed bool
+
+	lockTime    uint32
+	sigHashType txscript.SigHashType
+	scriptSig   func(sig, pubKey []byte, builder *txscript.ScriptBuilder)
+	witness     bool
+	postFund    func(*wire.MsgTx)
+
+	pollInterval        time.Duration
+	confirmationTimeout time.Duration
+	rebroadcastInterval time.Duration
+	maxRebroadcasts     int
+	maxRebroadcastsSet  bool
+}
+
+func newSendOptions(opts []SendOption) sendOptions {
+	options := sendOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// BIP69Sort sorts the inputs and outputs of the transaction lexicographically
+// as defined in BIP69, before the transaction is signed. Sorting gives the
+// transaction a canonical structure and a deterministic txid for a given set
+// of inputs and outputs.
+func BIP69Sort() SendOption {
+	return func(options *sendOptions) {
+		options.bip69 = true
+	}
+}
+
+// ReplaceByFee signals BIP125 opt-in replace-by-fee on every input of the
+// transaction, so that its fee can later be increased using BumpFee.
+func ReplaceByFee() SendOption {
+	return func(options *sendOptions) {
+		options.rbf = true
+	}
+}
+
+// MinConfirmations only funds the transaction using unspent outputs with at
+// least n confirmations. By default, unspent outputs need one confirmation.
+// If n is zero, unconfirmed outputs (such as the change of a previous
+// transaction) can be spent.
+func MinConfirmations(n int64) SendOption {
+	return func(options *sendOptions) {
+		options.minConfirmations = n
+		options.allowUnconfirmed = n <= 0
+	}
+}
+
+// AllowUnconfirmed funds the transaction using unconfirmed outputs too, so
+// that transactions can be chained. It is the same as MinConfirmations(0).
+func AllowUnconfirmed() SendOption {
+	return MinConfirmations(0)
+}
+
+// confirmations returns the number of confirmations needed by the unspent
+// outputs that fund the transaction.
+func (options sendOptions) confirmations() int64 {
+	if options.allowUnconfirmed {
+		return 0
+	}
+	if options.minConfirmations < 1 {
+		return 1
+	}
+	return options.minConfirmations
+}
+
+// WithLockTime sets the locktime of the transaction, which cannot be mined
+// before the given block height (or Unix timestamp, if it is at least
+// 500000000). The inputs of the transaction are made non-final, as required
+// for the locktime to be enforced, so that it can spend the refund path of an
+// HTLC built by BuildHTLC once the HTLC expires.
+func WithLockTime(lockTime uint32) SendOption {
+	return func(options *sendOptions) {
+		options.lockTime = lockTime
+	}
+}
+
+// WithSigHashType signs the inputs of the transaction with the given sighash
+// type, instead of txscript.SigHashAll. SigHashSingle requires an output for
+// every input, at the same index.
+func WithSigHashType(hashType txscript.SigHashType) SendOption {
+	return func(options *sendOptions) {
+		options.sigHashType = hashType
+	}
+}
+
+// WithScriptSig builds the signature scripts of inputs spending from the
+// contract of SendTransaction using f, which is given the signature and the
+// serialized public key of the account, instead of pushing them before the
+// data added by the f of SendTransaction. f can push data in any order, or
+// leave out the signature, so that scripts such as pure hashlocks can be
+// spent. The contract is still pushed last.
+func WithScriptSig(f func(sig, pubKey []byte, builder *txscript.ScriptBuilder)) SendOption {
+	return func(options *sendOptions) {
+		options.scriptSig = f
+	}
+}
+
+// WitnessContract spends the contract of SendTransaction as a P2WSH witness
+// script, instead of a P2SH redeem script, so that it is funded by outputs
+// paying to its WitnessScriptAddress. The data pushed for the contract is put
+// in the witness, followed by the contract, and it is signed with the
+// compressed public key of the account.
+func WitnessContract() SendOption {
+	return func(options *sendOptions) {
+		options.witness = true
+	}
+}
+
+// WithPostFund calls f with the transaction once it has been funded, so that
+// its outputs can be adjusted knowing which inputs were selected, and whether
+// a change output was added. f is called after the inputs and change are
+// added, and before the fee is checked, BIP69Sort sorts the transaction, and
+// the inputs are signed. When paying a fee rate, the transaction is funded
+// again until the fee covers its signed size, and f is called every time.
+// The value of the outputs must not be increased beyond what the inputs pay
+// for, since the transaction is not funded again to cover it.
+func WithPostFund(f func(*wire.MsgTx)) SendOption {
+	return func(options *sendOptions) {
+		options.postFund = f
+	}
+}
+
+// hashType returns the sighash type used to sign the transaction.
+func (options sendOptions) hashType() txscript.SigHashType {
+	if options.sigHashType == 0 {
+		return txscript.SigHashAll
+	}
+	return options.sigHashType
+}
+
+// WithPollInterval checks the post-condition of SendTransaction at the given
+// interval, instead of every DefaultPollInterval.
+func WithPollInterval(interval time.Duration) SendOption {
+	return func(options *sendOptions) {
+		options.pollInterval = interval
+	}
+}
+
+// WithConfirmationTimeout stops waiting for the post-condition of
+// SendTransaction after the given duration, and returns an error wrapping
+// ErrConfirmationTimeout. By default, it waits until the context is done.
+func WithConfirmationTimeout(timeout time.Duration) SendOption {
+	return func(options *sendOptions) {
+		options.confirmationTimeout = timeout
+	}
+}
+
+// WithRebroadcastInterval publishes the transaction of SendTransaction again
+// at the given interval while its post-condition does not hold, instead of
+// every DefaultRebroadcastInterval.
+func WithRebroadcastInterval(interval time.Duration) SendOption {
+	return func(options *sendOptions) {
+		options.rebroadcastInterval = interval
+	}
+}
+
+// WithMaxRebroadcasts publishes the transaction of SendTransaction again at
+// most n times, instead of DefaultMaxRebroadcasts times, before giving up on
+// its post-condition.
+func WithMaxRebroadcasts(n int) SendOption {
+	return func(options *sendOptions) {
+		options.maxRebroadcasts = n
+		options.maxRebroadcastsSet = true
+	}
+}
+
+// rebroadcasts returns the interval at which the transaction is published
+// again, and the maximum number of times it is.
+func (options sendOptions) rebroadcasts() (time.Duration, int) {
+	interval := options.rebroadcastInterval
+	if interval <= 0 {
+		interval = DefaultRebroadcastInterval
+	}
+	if !options.maxRebroadcastsSet {
+		return interval, DefaultMaxRebroadcasts
+	}
+	return interval, options.maxRebroadcasts
+}
+
+// interval returns the interval at which the post-condition is checked.
+func (options sendOptions) interval() time.Duration {
+	if options.pollInterval <= 0 {
+		return DefaultPollInterval
+	}
+	return options.pollInterval
+}
+
+// WithCoinSelector selects the unspent outputs that fund the transaction
+// using the given CoinSelector. By default, unspent outputs are spent in the
+// order they are returned by the Client.
+func WithCoinSelector(selector CoinSelector) SendOption {
+	return func(options *sendOptions) {
+		options.selector = selector
+	}
+}
+
+// WithEncoding forces the transaction to be serialized for broadcast using
+// the given encoding, either wire.BaseEncoding (legacy) or
+// wire.WitnessEncoding. By default, the witness encoding is used if, and only
+// if, the transaction has witness data. A transaction with witness data
+// cannot be serialized with the legacy encoding.
+func WithEncoding(encoding wire.MessageEncoding) SendOption {
+	return func(options *sendOptions) {
+		options.encoding = encoding
+		options.encodingSet = true
+	}
+}
+
+// WithChangeAddresses sends the change of the transaction to the addresses
+// chosen by the given ChangeAddressSelector. By default, the change is sent
+// back to the account's address.
+func WithChangeAddresses(selector ChangeAddressSelector) SendOption {
+	return func(options *sendOptions) {
+		options.change = selector
+	}
+}
+
+// WithChangeAddress sends the change of the transaction to the given address.
+// By default, the change is sent to the account's address, including when the
+// transaction spends from a contract. It is ignored if a
+// ChangeAddressSelector is also given.
+func WithChangeAddress(address string) SendOption {
+	return func(options *sendOptions) {
+		options.changeAddress = address
+	}
+}
+
+// WithReuseWarning calls warn with the destination address of a Transfer if
+// the address already has transaction history, so that the user can be
+// alerted to the address reuse. The transfer is not blocked.
+func WithReuseWarning(warn func(address string)) SendOption {
+	return func(options *sendOptions) {
+		options.reuse = warn
+	}
+}
+
+// MaxFeePercent refuses to sign the transaction if its fee exceeds the given
+// percentage of the amount being sent (excluding change). This guards
+// against fee estimation bugs, and against sending amounts so small that the
+// fee dwarfs them.
+func MaxFeePercent(percent float64) SendOption {
+	return func(options *sendOptions) {
+		options.maxFeePercent = percent
+	}
+}
+
+// DefaultMaxFeeRate is the highest fee rate (in SAT per byte) that a
+// transaction is published with, unless MaxFeeRate is used. It is far above
+// the fee rates paid on the network, so that it only catches fees that are
+// wrong by orders of magnitude.
+const DefaultMaxFeeRate = 10000
+
+// MaxFeeRate refuses to publish the transaction if its fee exceeds feeRate
+// SAT per byte of the signed transaction, instead of the DefaultMaxFeeRate.
+// A negative feeRate disables the check.
+func MaxFeeRate(feeRate int64) SendOption {
+	return func(options *sendOptions) {
+		options.maxFeeRate = feeRate
+	}
+}
+
+// MaxFee refuses to publish the transaction if its fee exceeds the given
+// absolute fee (in SAT), in addition to checking its fee rate.
+func MaxFee(fee int64) SendOption {
+	return func(options *sendOptions) {
+		options.maxFee = fee
+	}
+}
+
+// WithTxResult populates the given TxResult once the transaction is signed,
+// so that the exact fee can be computed without fetching the transactions
+// spent by its inputs.
+func WithTxResult(result *TxResult) SendOption {
+	return func(options *sendOptions) {
+		options.result = result
+	}
+}
+
+// WithTxSummary populates the given TxSummary once the transaction is signed,
+// with the outputs it spends, the values of its outputs, and the fee it
+// actually pays, which can differ from the fee that was asked for.
+func WithTxSummary(summary *TxSummary) SendOption {
+	return func(options *sendOptions) {
+		options.summary = summary
+	}
+}
+
+// VerifyTxid refuses to broadcast the transaction if its txid differs from
+// the expected txid, and after it is broadcast, finds the transaction that
+// spends its first input and returns ErrTxidMismatch if that transaction has a
+// different txid. If the expected txid is empty, the txid computed locally
+// before broadcasting is expected. This detects transactions that were
+// malleated, or altered by a signer, before landing on the blockchain. The
+// spending transaction is polled for at the poll interval, and if it cannot
+// be found, ErrTxidUnverified is returned even though the transaction was
+// broadcast.
+func VerifyTxid(expected string) SendOption {
+	return func(options *sendOptions) {
+		options.verifyTxid = true
+		options.expectedTxid = expected
+	}
+}
+
+// VerifyPrevOuts fetches the transaction spent by every input before it is
+// signed, and returns an error wrapping ErrPrevOutMismatch if the output it
+// spends does not have the value and script reported by the client. This
+// protects against signing for the wrong value (which SegWit signatures
+// commit to) when an API is faulty or has been tampered with, at the cost of
+// a request per input.
+func VerifyPrevOuts() SendOption {
+	return func(options *sendOptions) {
+		options.verifyPrevOut = true
+	}
+}
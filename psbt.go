@@ -0,0 +1,115 @@
+package libbtc
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil/psbt"
+)
+
+// BuildPSBT funds a transaction paying the outputs at feeRate SAT per byte,
+// and returns it as an unsigned BIP174 PSBT, so that it can be signed
+// elsewhere (for example, by a hardware wallet). Every input carries the
+// output it spends, and the redeem script of nested SegWit inputs. The outputs
+// it spends stay reserved until ReleaseReservations is called.
+func (account *account) BuildPSBT(ctx context.Context, outputs map[string]int64, feeRate int64) (_ *psbt.Packet, err error) {
+	me, err := account.Address()
+	if err != nil {
+		return nil, err
+	}
+
+	// Current Bitcoin Transaction Version (2).
+	tx := account.newTx(ctx, wire.NewMsgTx(2), sendOptions{})
+	defer func() {
+		if err != nil {
+			tx.release()
+		}
+	}()
+	if err := tx.addOutputs(outputs); err != nil {
+		return nil, err
+	}
+	if err := tx.fundWithFeeRate(me, feeRate, nil, nil); err != nil {
+		return nil, err
+	}
+	// Funding signs the transaction to learn its size, so the signatures
+	// are removed before it is exported.
+	for _, txIn := range tx.msgTx.TxIn {
+		txIn.SignatureScript = nil
+		txIn.Witness = nil
+	}
+
+	packet, err := psbt.NewFromUnsignedTx(tx.msgTx)
+	if err != nil {
+		return nil, err
+	}
+	updater, err := psbt.NewUpdater(packet)
+	if err != nil {
+		return nil, err
+	}
+	for i, txIn := range tx.msgTx.TxIn {
+		if account.addressType == AddressTypeLegacy {
+			// Signers need the whole previous transaction of a legacy
+			// input to be sure of the value it spends.
+			stx, err := account.GetSerializedTransaction(ctx, txIn.PreviousOutPoint.Hash.String())
+			if err != nil {
+				return nil, err
+			}
+			prevTx := wire.NewMsgTx(2)
+			if err := prevTx.Deserialize(bytes.NewReader(stx)); err != nil {
+				return nil, err
+			}
+			if err := updater.AddInNonWitnessUtxo(prevTx, i); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := updater.AddInWitnessUtxo(wire.NewTxOut(tx.receiveValues[i], tx.prevScripts[i]), i); err != nil {
+			return nil, err
+		}
+		if account.addressType == AddressTypeP2SHSegWit {
+			script, err := account.nestedSegWitScript()
+			if err != nil {
+				return nil, err
+			}
+			if err := updater.AddInRedeemScript(script, i); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return packet, nil
+}
+
+// FinalizeAndSubmitPSBT finalizes a signed PSBT, verifies the resulting
+// transaction against the outputs it spends, and publishes it. It returns the
+// hash of the published transaction.
+func (account *account) FinalizeAndSubmitPSBT(ctx context.Context, p *psbt.Packet) (string, error) {
+	if err := psbt.MaybeFinalizeAll(p); err != nil {
+		return "", err
+	}
+	msgTx, err := psbt.Extract(p)
+	if err != nil {
+		return "", err
+	}
+
+	tx := account.newTx(ctx, msgTx, sendOptions{})
+	for i, txIn := range msgTx.TxIn {
+		prevOut := p.Inputs[i].WitnessUtxo
+		if prevOut == nil {
+			prevTx := p.Inputs[i].NonWitnessUtxo
+			if prevTx == nil || int(txIn.PreviousOutPoint.Index) >= len(prevTx.TxOut) {
+				return "", psbt.ErrNotFinalizable
+			}
+			prevOut = prevTx.TxOut[txIn.PreviousOutPoint.Index]
+		}
+		tx.receiveValues = append(tx.receiveValues, prevOut.Value)
+		tx.prevScripts = append(tx.prevScripts, prevOut.PkScript)
+	}
+	if err := tx.verify(); err != nil {
+		return "", err
+	}
+	if err := tx.submit(); err != nil {
+		return "", err
+	}
+	return msgTx.TxHash().String(), nil
+}
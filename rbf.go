@@ -0,0 +1,235 @@
+package libbtc
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// rbfSequence is the input sequence number that signals BIP125 opt-in
+// replace-by-fee.
+const rbfSequence = 0xfffffffd
+
+// isReplaceable returns true if any input of the transaction signals BIP125
+// opt-in replace-by-fee.
+func isReplaceable(tx Transaction) bool {
+	for _, input := range tx.Inputs {
+		if input.Sequence < wire.MaxTxInSequenceNum-1 {
+			return true
+		}
+	}
+	return false
+}
+
+// IsReplaceable returns true if the transaction is unconfirmed, and it or any
+// of its unconfirmed ancestors signals BIP125 opt-in replace-by-fee. Such a
+// transaction can still be replaced, so a payment made by it should not be
+// trusted until it is confirmed.
+func (account *account) IsReplaceable(ctx context.Context, txhash string) (bool, error) {
+	return account.isReplaceable(ctx, txhash, map[string]bool{})
+}
+
+func (account *account) isReplaceable(ctx context.Context, txhash string, seen map[string]bool) (bool, error) {
+	if seen[txhash] {
+		return false, nil
+	}
+	seen[txhash] = true
+	tx, err := account.GetRawTransaction(ctx, txhash)
+	if err != nil {
+		return false, err
+	}
+	if tx.BlockHeight != 0 {
+		return false, nil
+	}
+	if isReplaceable(tx) {
+		return true, nil
+	}
+	// Replacing an unconfirmed ancestor also replaces the transaction.
+	for _, input := range tx.Inputs {
+		replaceable, err := account.isReplaceable(ctx, input.PrevOut.TransactionHash, seen)
+		if err != nil || replaceable {
+			return replaceable, err
+		}
+	}
+	return false, nil
+}
+
+// CancelTransaction replaces an unconfirmed transaction that signals BIP125
+// replace-by-fee with one that spends all of its inputs back to the account
+// at the given fee rate (in SAT per byte), and returns the hash of the
+// replacement. All the inputs of the original transaction must belong to the
+// account. The fee of the replacement is always higher than the fee of the
+// original, as required by BIP125.
+func (account *account) CancelTransaction(ctx context.Context, txid string, feeRate int64) (string, error) {
+	original, err := account.GetRawTransaction(ctx, txid)
+	if err != nil {
+		return "", err
+	}
+	if original.BlockHeight != 0 {
+		return "", ErrAlreadyConfirmed
+	}
+	if !isReplaceable(original) {
+		return "", ErrNotReplaceable
+	}
+
+	me, err := account.Address()
+	if err != nil {
+		return "", err
+	}
+	P2PKHScript, err := txscript.PayToAddrScript(me)
+	if err != nil {
+		return "", err
+	}
+
+	// Current Bitcoin Transaction Version (2).
+	tx := account.newTx(ctx, wire.NewMsgTx(2), sendOptions{rbf: true})
+	defer tx.release()
+	in, err := tx.addReplacementInputs(original, me, P2PKHScript)
+	if err != nil {
+		return "", err
+	}
+	var out int64
+	for _, output := range original.Outputs {
+		out = out + int64(output.Value)
+	}
+	tx.msgTx.AddTxOut(wire.NewTxOut(in, P2PKHScript))
+	tx.changeIndex = 0
+
+	// BIP125 requires the replacement to pay a higher fee than the
+	// original, which is approximated by adding 1 SAT per byte of the
+	// original transaction.
+	minFee := (in - out) + int64(original.Size)
+	if err := tx.deductFee(0, feeRate, minFee, nil, nil); err != nil {
+		return "", err
+	}
+
+	if err := tx.verify(); err != nil {
+		return "", err
+	}
+	if err := tx.submit(); err != nil {
+		return "", err
+	}
+	return tx.msgTx.TxHash().String(), nil
+}
+
+// BumpFee replaces an unconfirmed transaction that signals BIP125
+// replace-by-fee with one that spends the same inputs to the same outputs,
+// paying a fee of newFeeRate SAT per byte, and returns the hash of the
+// replacement. The fee is increased by reducing the output paying back to the
+// account, which must exist. All the inputs of the original transaction must
+// belong to the account.
+func (account *account) BumpFee(ctx context.Context, txhash string, newFeeRate int64) (string, error) {
+	original, err := account.GetRawTransaction(ctx, txhash)
+	if err != nil {
+		return "", err
+	}
+	if original.BlockHeight != 0 {
+		return "", ErrAlreadyConfirmed
+	}
+	if !isReplaceable(original) {
+		return "", ErrNotReplaceable
+	}
+
+	me, err := account.Address()
+	if err != nil {
+		return "", err
+	}
+	P2PKHScript, err := txscript.PayToAddrScript(me)
+	if err != nil {
+		return "", err
+	}
+
+	// Current Bitcoin Transaction Version (2).
+	tx := account.newTx(ctx, wire.NewMsgTx(2), sendOptions{rbf: true})
+	defer tx.release()
+	in, err := tx.addReplacementInputs(original, me, P2PKHScript)
+	if err != nil {
+		return "", err
+	}
+	var out int64
+	for i, output := range original.Outputs {
+		script, err := hex.DecodeString(output.Script)
+		if err != nil {
+			return "", err
+		}
+		if tx.changeIndex < 0 && bytes.Equal(script, P2PKHScript) {
+			tx.changeIndex = i
+		}
+		tx.msgTx.AddTxOut(wire.NewTxOut(int64(output.Value), script))
+		out = out + int64(output.Value)
+	}
+	if tx.changeIndex < 0 {
+		return "", ErrNoChangeOutput
+	}
+
+	// The fee of the original is returned to the change output, so that
+	// deducting the new fee from it leaves every other output untouched.
+	// BIP125 requires the replacement to pay a higher fee than the
+	// original, which is approximated by adding 1 SAT per byte of the
+	// original transaction.
+	tx.msgTx.TxOut[tx.changeIndex].Value += in - out
+	minFee := (in - out) + int64(original.Size)
+	if err := tx.deductFee(tx.changeIndex, newFeeRate, minFee, nil, nil); err != nil {
+		return "", err
+	}
+	if err := tx.checkDust(); err != nil {
+		return "", err
+	}
+
+	if err := tx.verify(); err != nil {
+		return "", err
+	}
+	if err := tx.submit(); err != nil {
+		return "", err
+	}
+	return tx.msgTx.TxHash().String(), nil
+}
+
+// addReplacementInputs adds the inputs of the original transaction to the
+// replacement, signalling BIP125 replace-by-fee, and returns their total
+// value. The outpoints are read from the serialized transaction, since the
+// output indices reported by some APIs are truncated. All the inputs must
+// spend outputs of the account, and none can be reserved by another
+// transaction of the account that has not been published.
+func (tx *tx) addReplacementInputs(original Transaction, me btcutil.Address, script []byte) (int64, error) {
+	stx, err := tx.account.GetSerializedTransaction(tx.ctx, original.TransactionHash)
+	if err != nil {
+		return 0, err
+	}
+	msgTx, err := DecodeTransaction(stx)
+	if err != nil {
+		return 0, err
+	}
+	if msgTx.TxHash().String() != original.TransactionHash {
+		return 0, ErrTxidMismatch
+	}
+	if len(msgTx.TxIn) != len(original.Inputs) {
+		return 0, NewErrInputCount(len(msgTx.TxIn), len(original.Inputs))
+	}
+	var in int64
+	for i, input := range original.Inputs {
+		if input.PrevOut.Address != me.EncodeAddress() {
+			return 0, NewErrForeignInput(input.PrevOut.Address)
+		}
+		outPoint := msgTx.TxIn[i].PreviousOutPoint
+		utxo := UnspentOutput{
+			TransactionHash:         hex.EncodeToString(outPoint.Hash[:]),
+			TransactionOutputNumber: outPoint.Index,
+			Amount:                  int64(input.PrevOut.Value),
+		}
+		if !tx.account.reserved.replace(utxo, tx) {
+			return 0, NewErrUTXOsReserved(utxo.Amount)
+		}
+		txIn := wire.NewTxIn(&outPoint, []byte{}, [][]byte{})
+		txIn.Sequence = rbfSequence
+		tx.msgTx.AddTxIn(txIn)
+		tx.receiveValues = append(tx.receiveValues, int64(input.PrevOut.Value))
+		tx.prevScripts = append(tx.prevScripts, script)
+		in = in + int64(input.PrevOut.Value)
+	}
+	return in, nil
+}
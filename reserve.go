@@ -0,0 +1,169 @@
+package libbtc
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// outpoint identifies an unspent output by the little-endian hex encoded hash
+// of its transaction, and its index, as returned by a Client.
+type outpoint struct {
+	hash string
+	vout uint32
+}
+
+// reservationPruneInterval is the minimum interval between checks of whether
+// published transactions have confirmed, so that funding a transaction does
+// not fetch every transaction in flight. Published transactions that cannot
+// be found are only dropped once they are older than this, since APIs take a
+// while to index new transactions.
+const reservationPruneInterval = time.Minute
+
+// reservation is held on an unspent output while it funds a transaction. Once
+// the transaction is published, its hash is recorded so that the reservation
+// can be dropped when the transaction confirms.
+type reservation struct {
+	owner     *tx
+	txid      string
+	published time.Time
+}
+
+// reservations are the unspent outputs of an account that fund transactions
+// which are being built, or have been published but are not confirmed yet.
+// They are shared by all the goroutines using the account, so that concurrent
+// transactions do not spend the same outputs.
+type reservations struct {
+	mu        *sync.Mutex
+	outpoints map[outpoint]*reservation
+	pruned    time.Time
+}
+
+func newReservations() *reservations {
+	return &reservations{
+		mu:        new(sync.Mutex),
+		outpoints: map[outpoint]*reservation{},
+	}
+}
+
+// reserved returns true if the unspent output is reserved by a transaction
+// other than the owner.
+func (res *reservations) reserved(utxo UnspentOutput, owner *tx) bool {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	r, ok := res.outpoints[outpoint{utxo.TransactionHash, utxo.TransactionOutputNumber}]
+	return ok && r.owner != owner
+}
+
+// reserve reserves the unspent output for the owner, and returns false if it
+// is already reserved by another transaction.
+func (res *reservations) reserve(utxo UnspentOutput, owner *tx) bool {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	key := outpoint{utxo.TransactionHash, utxo.TransactionOutputNumber}
+	if r, ok := res.outpoints[key]; ok {
+		return r.owner == owner
+	}
+	res.outpoints[key] = &reservation{owner: owner}
+	return true
+}
+
+// replace reserves the unspent output for the owner, which replaces the
+// published transaction spending it, and returns false if it is reserved by
+// another transaction that has not been published. Reservations of published
+// transactions are kept until the replacement is published.
+func (res *reservations) replace(utxo UnspentOutput, owner *tx) bool {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	key := outpoint{utxo.TransactionHash, utxo.TransactionOutputNumber}
+	if r, ok := res.outpoints[key]; ok {
+		return r.owner == owner || r.txid != ""
+	}
+	res.outpoints[key] = &reservation{owner: owner}
+	return true
+}
+
+// publish records that the transaction of the owner has been published, so
+// that the outputs it spends stay reserved until it confirms. This includes
+// outputs reserved by transactions that it replaces.
+func (res *reservations) publish(owner *tx, msgTx *wire.MsgTx) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	txid := msgTx.TxHash().String()
+	now := time.Now()
+	for _, txIn := range msgTx.TxIn {
+		key := outpoint{hex.EncodeToString(txIn.PreviousOutPoint.Hash[:]), txIn.PreviousOutPoint.Index}
+		res.outpoints[key] = &reservation{owner: owner, txid: txid, published: now}
+	}
+}
+
+// release drops the reservations of the owner that fund a transaction which
+// has not been published.
+func (res *reservations) release(owner *tx) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	for key, r := range res.outpoints {
+		if r.owner == owner && r.txid == "" {
+			delete(res.outpoints, key)
+		}
+	}
+}
+
+// releaseAll drops every reservation.
+func (res *reservations) releaseAll() {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	res.outpoints = map[outpoint]*reservation{}
+}
+
+// prune drops the reservations of published transactions that have been
+// confirmed, since the outputs they spend cannot be returned as unspent
+// again, and of transactions that can no longer be found, such as those that
+// have been replaced. Transactions are checked at most once every
+// reservationPruneInterval, and those whose confirmations cannot be fetched
+// stay reserved.
+func (res *reservations) prune(ctx context.Context, client Client) {
+	res.mu.Lock()
+	if time.Since(res.pruned) < reservationPruneInterval {
+		res.mu.Unlock()
+		return
+	}
+	res.pruned = time.Now()
+	published := map[string]time.Time{}
+	for _, r := range res.outpoints {
+		if r.txid != "" {
+			published[r.txid] = r.published
+		}
+	}
+	res.mu.Unlock()
+	drop := map[string]bool{}
+	for txid, at := range published {
+		confirmations, err := client.Confirmations(ctx, txid)
+		if errors.Is(err, ErrNotFound) {
+			drop[txid] = time.Since(at) > reservationPruneInterval
+			continue
+		}
+		drop[txid] = err == nil && confirmations > 0
+	}
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	for key, r := range res.outpoints {
+		if drop[r.txid] {
+			delete(res.outpoints, key)
+		}
+	}
+}
+
+// ReleaseReservations releases the unspent outputs reserved by the
+// transactions of the account, so that they can fund new transactions. The
+// outputs funding a transaction are reserved while it is built, and once it
+// is published, until it confirms. Transactions that are built but not
+// published by the account, such as those returned by BuildAndSign, keep
+// their outputs reserved until they are released.
+func (account *account) ReleaseReservations() {
+	account.reserved.releaseAll()
+}
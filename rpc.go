@@ -0,0 +1,593 @@
+package libbtc
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcutil"
+)
+
+// rpcPageSize is the number of wallet transactions requested at a time from
+// the node, while searching for the transactions spending from an address.
+const rpcPageSize = 1000
+
+type rpcClient struct {
+	// base is used for its HTTP client, rate limit and retry policy.
+	base   client
+	host   string
+	user   string
+	pass   string
+	params *chaincfg.Params
+}
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcTransaction struct {
+	TxID          string `json:"txid"`
+	Version       int32  `json:"version"`
+	Size          int64  `json:"size"`
+	BlockHash     string `json:"blockhash"`
+	Confirmations int64  `json:"confirmations"`
+	Vin           []struct {
+		TxID      string `json:"txid"`
+		Vout      uint32 `json:"vout"`
+		Coinbase  string `json:"coinbase"`
+		Sequence  uint32 `json:"sequence"`
+		ScriptSig struct {
+			Hex string `json:"hex"`
+		} `json:"scriptSig"`
+	} `json:"vin"`
+	Vout []struct {
+		Value        float64 `json:"value"`
+		N            uint32  `json:"n"`
+		ScriptPubKey struct {
+			Hex string `json:"hex"`
+		} `json:"scriptPubKey"`
+	} `json:"vout"`
+}
+
+type rpcBlockHeader struct {
+	Hash              string `json:"hash"`
+	Confirmations     int64  `json:"confirmations"`
+	Height            int64  `json:"height"`
+	Version           int32  `json:"version"`
+	MerkleRoot        string `json:"merkleroot"`
+	Time              int64  `json:"time"`
+	Nonce             int64  `json:"nonce"`
+	Bits              string `json:"bits"`
+	PreviousBlockHash string `json:"previousblockhash"`
+}
+
+type rpcUnspent struct {
+	TxID          string  `json:"txid"`
+	Vout          uint32  `json:"vout"`
+	ScriptPubKey  string  `json:"scriptPubKey"`
+	Amount        float64 `json:"amount"`
+	Confirmations int64   `json:"confirmations"`
+}
+
+// rpcMaxConfirmations is the maximum number of confirmations of the unspent
+// outputs returned by listunspent, which is high enough to include them all.
+const rpcMaxConfirmations = 9999999
+
+// NewRPCClient returns a Client that talks to a Bitcoin Core node over
+// JSON-RPC, instead of a third-party API. Transactions are found using
+// getrawtransaction, which requires the node to run with -txindex. Unspent
+// outputs, including those in the mempool, and the history of an address are
+// read from the wallet of the node, so addresses must be watched by the
+// wallet (for example, using importaddress) for the client to see them.
+// Calls that fail without a response from the node are retried using the
+// retry policy of the client.
+func NewRPCClient(host, user, pass string, params *chaincfg.Params, opts ...ClientOption) Client {
+	registerParams(params)
+	c := &rpcClient{
+		base:   client{height: new(heightCache), httpClient: &http.Client{Timeout: DefaultHTTPTimeout}},
+		host:   host,
+		user:   user,
+		pass:   pass,
+		params: params,
+	}
+	for _, opt := range opts {
+		opt(&c.base)
+	}
+	return c
+}
+
+func (client *rpcClient) NetworkParams() *chaincfg.Params {
+	return client.params
+}
+
+func (client *rpcClient) GetUnspentOutputs(ctx context.Context, address string, limit, confirmations int64) (Unspent, error) {
+	return client.GetUnspentOutputsPage(ctx, address, 0, limit, confirmations)
+}
+
+func (client *rpcClient) GetUnspentOutputsPage(ctx context.Context, address string, offset, limit, confirmations int64) (Unspent, error) {
+	utxos := Unspent{}
+	// Unlike scantxoutset, listunspent sees unconfirmed outputs, and reads
+	// the wallet instead of scanning the whole UTXO set for every page.
+	unspents := []rpcUnspent{}
+	if err := client.call(ctx, "listunspent", []interface{}{confirmations, rpcMaxConfirmations, []string{address}, true}, &unspents); err != nil {
+		return utxos, err
+	}
+	for _, unspent := range unspents {
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if limit > 0 && int64(len(utxos.Outputs)) >= limit {
+			break
+		}
+		// Unspent outputs identify their transaction by its hash in
+		// little-endian byte order.
+		hash, err := chainhash.NewHashFromStr(unspent.TxID)
+		if err != nil {
+			return utxos, err
+		}
+		amount, err := btcutil.NewAmount(unspent.Amount)
+		if err != nil {
+			return utxos, err
+		}
+		utxos.Outputs = append(utxos.Outputs, UnspentOutput{
+			TransactionHash:         hex.EncodeToString(hash[:]),
+			TransactionOutputNumber: unspent.Vout,
+			ScriptPubKey:            unspent.ScriptPubKey,
+			Amount:                  int64(amount),
+			Confirmations:           unspent.Confirmations,
+		})
+	}
+	return utxos, nil
+}
+
+func (client *rpcClient) GetSerializedTransaction(ctx context.Context, txhash string) ([]byte, error) {
+	var stx string
+	if err := client.call(ctx, "getrawtransaction", []interface{}{txhash, false}, &stx); err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(stx)
+}
+
+func (client *rpcClient) GetRawTransaction(ctx context.Context, txhash string) (Transaction, error) {
+	rawTx, err := client.rawTransaction(ctx, txhash)
+	if err != nil {
+		return Transaction{}, err
+	}
+	transaction := Transaction{
+		TransactionHash: rawTx.TxID,
+		Version:         uint8(rawTx.Version),
+		VinSize:         uint32(len(rawTx.Vin)),
+		VoutSize:        uint32(len(rawTx.Vout)),
+		Size:            rawTx.Size,
+	}
+	if rawTx.BlockHash != "" {
+		header := rpcBlockHeader{}
+		if err := client.call(ctx, "getblockheader", []interface{}{rawTx.BlockHash, true}, &header); err != nil {
+			return transaction, err
+		}
+		transaction.BlockHeight = header.Height
+	}
+
+	prevTxs := map[string]rpcTransaction{}
+	for _, vin := range rawTx.Vin {
+		input := Input{
+			Script:   vin.ScriptSig.Hex,
+			Sequence: vin.Sequence,
+		}
+		if vin.Coinbase == "" {
+			prevTx, ok := prevTxs[vin.TxID]
+			if !ok {
+				if prevTx, err = client.rawTransaction(ctx, vin.TxID); err != nil {
+					return transaction, err
+				}
+				prevTxs[vin.TxID] = prevTx
+			}
+			if int(vin.Vout) >= len(prevTx.Vout) {
+				return transaction, NewErrBitcoinRPC("getrawtransaction", 0, fmt.Sprintf("output %d of %s not found", vin.Vout, vin.TxID))
+			}
+			prevOut := prevTx.Vout[vin.Vout]
+			value, err := btcutil.NewAmount(prevOut.Value)
+			if err != nil {
+				return transaction, err
+			}
+			input.PrevOut = PreviousOut{
+				TransactionHash: vin.TxID,
+				Value:           uint64(value),
+				VoutNumber:      vin.Vout,
+			}
+			addrs, err := Output{Script: prevOut.ScriptPubKey.Hex}.Addresses(client.params)
+			if err != nil {
+				return transaction, err
+			}
+			if len(addrs) > 0 {
+				input.PrevOut.Address = addrs[0]
+			}
+		}
+		transaction.Inputs = append(transaction.Inputs, input)
+	}
+	for _, vout := range rawTx.Vout {
+		value, err := btcutil.NewAmount(vout.Value)
+		if err != nil {
+			return transaction, err
+		}
+		transaction.Outputs = append(transaction.Outputs, Output{
+			Value:           uint64(value),
+			TransactionHash: rawTx.TxID,
+			Script:          vout.ScriptPubKey.Hex,
+		})
+	}
+	return transaction, nil
+}
+
+func (client *rpcClient) rawTransaction(ctx context.Context, txhash string) (rpcTransaction, error) {
+	rawTx := rpcTransaction{}
+	err := client.call(ctx, "getrawtransaction", []interface{}{txhash, true}, &rawTx)
+	return rawTx, err
+}
+
+// GetAddressTransactions returns up to limit transactions of the address,
+// newest first, skipping the first offset transactions.
+func (client *rpcClient) GetAddressTransactions(ctx context.Context, addr string, offset, limit int) ([]Transaction, error) {
+	addressInfo, err := client.GetRawAddressInformation(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return pageTransactions(addressInfo.Transactions, offset, limit), nil
+}
+
+// GetRawAddressInformation returns the information of an address watched by
+// the wallet of the node, with its transactions newest first. Only the
+// transactions that pay to the address are found using listreceivedbyaddress,
+// and its outputs are checked using gettxout, so that the rest of the wallet
+// is only searched for the transactions spending them once they are spent.
+func (client *rpcClient) GetRawAddressInformation(ctx context.Context, addr string) (SingleAddress, error) {
+	addressInfo := SingleAddress{Address: addr}
+	received := []struct {
+		Address string   `json:"address"`
+		TxIDs   []string `json:"txids"`
+	}{}
+	if err := client.call(ctx, "listreceivedbyaddress", []interface{}{0, false, true, addr}, &received); err != nil {
+		return addressInfo, err
+	}
+	seen := map[string]bool{}
+	spent := map[string]bool{}
+	for _, entry := range received {
+		if entry.Address != addr {
+			continue
+		}
+		for _, txid := range entry.TxIDs {
+			if seen[txid] {
+				continue
+			}
+			seen[txid] = true
+			tx, err := client.GetRawTransaction(ctx, txid)
+			if err != nil {
+				return addressInfo, err
+			}
+			for i, output := range tx.Outputs {
+				addrs, err := output.Addresses(client.params)
+				if err != nil {
+					return addressInfo, err
+				}
+				pays := false
+				for _, outputAddr := range addrs {
+					pays = pays || outputAddr == addr
+				}
+				if !pays {
+					continue
+				}
+				addressInfo.Received = addressInfo.Received + int64(output.Value)
+				// The node returns no output for outputs that are spent,
+				// including by transactions in the mempool.
+				var txOut *struct{}
+				if err := client.call(ctx, "gettxout", []interface{}{txid, i, true}, &txOut); err != nil {
+					return addressInfo, err
+				}
+				if txOut == nil {
+					addressInfo.Sent = addressInfo.Sent + int64(output.Value)
+					spent[fmt.Sprintf("%s:%d", txid, i)] = true
+				}
+			}
+			addressInfo.Transactions = append(addressInfo.Transactions, tx)
+		}
+	}
+	for _, tx := range addressInfo.Transactions {
+		for _, input := range tx.Inputs {
+			delete(spent, fmt.Sprintf("%s:%d", input.PrevOut.TransactionHash, input.PrevOut.VoutNumber))
+		}
+	}
+	spenders, err := client.spendingTransactions(ctx, spent, seen)
+	if err != nil {
+		return addressInfo, err
+	}
+	addressInfo.Transactions = append(addressInfo.Transactions, spenders...)
+	sort.SliceStable(addressInfo.Transactions, func(i, j int) bool {
+		// Unconfirmed transactions have no block height, and are newer than
+		// every confirmed transaction.
+		hi, hj := addressInfo.Transactions[i].BlockHeight, addressInfo.Transactions[j].BlockHeight
+		if hi == 0 || hj == 0 {
+			return hi == 0 && hj != 0
+		}
+		return hi > hj
+	})
+	addressInfo.TransactionCount = int64(len(addressInfo.Transactions))
+	addressInfo.Balance = addressInfo.Received - addressInfo.Sent
+	return addressInfo, nil
+}
+
+// spendingTransactions searches the transactions sent by the wallet of the
+// node, newest first, for those spending the given outpoints, skipping the
+// transactions that have already been seen. It stops once every outpoint has
+// been found.
+func (client *rpcClient) spendingTransactions(ctx context.Context, outpoints map[string]bool, seen map[string]bool) ([]Transaction, error) {
+	txs := []Transaction{}
+	for skip := 0; len(outpoints) > 0; skip += rpcPageSize {
+		entries := []struct {
+			TxID     string `json:"txid"`
+			Category string `json:"category"`
+		}{}
+		if err := client.call(ctx, "listtransactions", []interface{}{"*", rpcPageSize, skip, true}, &entries); err != nil {
+			return nil, err
+		}
+		// Each page lists its transactions oldest first.
+		for i := len(entries) - 1; i >= 0 && len(outpoints) > 0; i-- {
+			entry := entries[i]
+			if entry.Category != "send" || seen[entry.TxID] {
+				continue
+			}
+			seen[entry.TxID] = true
+			rawTx, err := client.rawTransaction(ctx, entry.TxID)
+			if err != nil {
+				return nil, err
+			}
+			spends := false
+			for _, vin := range rawTx.Vin {
+				outpoint := fmt.Sprintf("%s:%d", vin.TxID, vin.Vout)
+				if outpoints[outpoint] {
+					delete(outpoints, outpoint)
+					spends = true
+				}
+			}
+			if !spends {
+				continue
+			}
+			tx, err := client.GetRawTransaction(ctx, entry.TxID)
+			if err != nil {
+				return nil, err
+			}
+			txs = append(txs, tx)
+		}
+		if len(entries) < rpcPageSize {
+			break
+		}
+	}
+	return txs, nil
+}
+
+func (client *rpcClient) PublishTransaction(ctx context.Context, signedTransaction []byte) error {
+	var txid string
+	err := client.call(ctx, "sendrawtransaction", []interface{}{hex.EncodeToString(signedTransaction)}, &txid)
+	if rpcErr, ok := err.(*rpcError); ok {
+		return NewErrBitcoinSubmitTx(rpcErr.Message)
+	}
+	return err
+}
+
+func (client *rpcClient) Balance(ctx context.Context, address string, confirmations int64) (int64, error) {
+	unspent, err := client.GetUnspentOutputs(ctx, address, 0, confirmations)
+	if err != nil {
+		return 0, err
+	}
+	balance := int64(0)
+	for _, utxo := range unspent.Outputs {
+		balance = balance + utxo.Amount
+	}
+	return balance, nil
+}
+
+func (client *rpcClient) BalanceDetails(ctx context.Context, address string) (int64, int64, error) {
+	return balanceDetails(ctx, client, address)
+}
+
+func (client *rpcClient) ScriptSpent(ctx context.Context, address string) (bool, error) {
+	rawAddress, err := client.GetRawAddressInformation(ctx, address)
+	if err != nil {
+		return false, err
+	}
+	return rawAddress.Sent > 0, nil
+}
+
+func (client *rpcClient) HasBeenUsed(ctx context.Context, address string) (bool, error) {
+	rawAddress, err := client.GetRawAddressInformation(ctx, address)
+	if err != nil {
+		return false, err
+	}
+	return rawAddress.TransactionCount > 0, nil
+}
+
+func (client *rpcClient) BalanceDelta(ctx context.Context, address string, fromHeight, toHeight int64) (int64, error) {
+	rawAddress, err := client.GetRawAddressInformation(ctx, address)
+	if err != nil {
+		return 0, err
+	}
+	delta := int64(0)
+	for _, tx := range rawAddress.Transactions {
+		// Unconfirmed transactions have no block height.
+		if tx.BlockHeight == 0 || tx.BlockHeight < fromHeight || tx.BlockHeight > toHeight {
+			continue
+		}
+		txDelta, err := transactionDelta(tx, address, client.params)
+		if err != nil {
+			return 0, err
+		}
+		delta = delta + txDelta
+	}
+	return delta, nil
+}
+
+func (client *rpcClient) ScriptFunded(ctx context.Context, address string, value int64) (bool, int64, error) {
+	rawAddress, err := client.GetRawAddressInformation(ctx, address)
+	if err != nil {
+		return false, 0, err
+	}
+	return rawAddress.Received >= value, rawAddress.Received, nil
+}
+
+func (client *rpcClient) ScriptRedeemed(ctx context.Context, address string, value int64) (bool, int64, error) {
+	rawAddress, err := client.GetRawAddressInformation(ctx, address)
+	if err != nil {
+		return false, 0, err
+	}
+	return rawAddress.Received >= value && rawAddress.Balance == 0, rawAddress.Balance, nil
+}
+
+// GetScriptFromSpentP2SH waits for the address to be spent from, and returns
+// the signature script of the first input spending from it.
+func (client *rpcClient) GetScriptFromSpentP2SH(ctx context.Context, address string) ([]byte, error) {
+	if err := waitForSpend(ctx, client, address); err != nil {
+		return nil, err
+	}
+	return spendingScript(ctx, client, address)
+}
+
+func (client *rpcClient) Confirmations(ctx context.Context, txHash string) (int64, error) {
+	rawTx, err := client.rawTransaction(ctx, txHash)
+	if err != nil {
+		return 0, err
+	}
+	return rawTx.Confirmations, nil
+}
+
+func (client *rpcClient) GetTransactionStatus(ctx context.Context, txHash string) (TxStatus, error) {
+	return transactionStatus(ctx, client, txHash)
+}
+
+func (client *rpcClient) WaitForConfirmations(ctx context.Context, txHash string, n int64) error {
+	return waitForConfirmations(ctx, client, txHash, n)
+}
+
+func (client *rpcClient) GetBlockHeight(ctx context.Context) (int64, error) {
+	return client.base.height.get(func() (int64, error) {
+		var height int64
+		err := client.call(ctx, "getblockcount", nil, &height)
+		return height, err
+	})
+}
+
+func (client *rpcClient) GetBlockHeader(ctx context.Context, hash string) (BlockHeader, error) {
+	rpcHeader := rpcBlockHeader{}
+	if err := client.call(ctx, "getblockheader", []interface{}{hash, true}, &rpcHeader); err != nil {
+		return BlockHeader{}, err
+	}
+	bits, err := strconv.ParseInt(rpcHeader.Bits, 16, 64)
+	if err != nil {
+		return BlockHeader{}, err
+	}
+	return BlockHeader{
+		BlockHash:         rpcHeader.Hash,
+		Version:           uint8(rpcHeader.Version),
+		PreviousBlockHash: rpcHeader.PreviousBlockHash,
+		MerkleRoot:        rpcHeader.MerkleRoot,
+		Time:              rpcHeader.Time,
+		Bits:              bits,
+		Nonce:             rpcHeader.Nonce,
+		Height:            rpcHeader.Height,
+		// Blocks that are not on the main chain have -1 confirmations.
+		MainChain: rpcHeader.Confirmations >= 0,
+	}, nil
+}
+
+func (client *rpcClient) GetBlockHeaderByHeight(ctx context.Context, height int64) (BlockHeader, error) {
+	var hash string
+	if err := client.call(ctx, "getblockhash", []interface{}{height}, &hash); err != nil {
+		return BlockHeader{}, err
+	}
+	return client.GetBlockHeader(ctx, hash)
+}
+
+func (client *rpcClient) FormatTransactionView(msg, txhash string) string {
+	return formatTransactionView(client.params, msg, txhash)
+}
+
+// call calls the JSON-RPC method of the node, and decodes its result into
+// result. Errors returned by the node are returned as an *rpcError.
+func (client *rpcClient) call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	reqBytes, err := json.Marshal(rpcRequest{
+		JSONRPC: "1.0",
+		ID:      1,
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return err
+	}
+	response := rpcResponse{}
+	err = client.base.backoff(ctx, func() (*http.Response, error) {
+		req, err := http.NewRequest("POST", client.host, bytes.NewReader(reqBytes))
+		if err != nil {
+			return nil, err
+		}
+		req.SetBasicAuth(client.user, client.pass)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.base.httpClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		respBytes, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return resp, err
+		}
+		// The node responds to failed calls with an error status, but still
+		// describes the error in the body. Those calls would fail again, so
+		// they are not retried.
+		if err := json.Unmarshal(respBytes, &response); err != nil {
+			return resp, NewErrBitcoinRPC(method, resp.StatusCode, string(respBytes))
+		}
+		return resp, nil
+	})
+	if err != nil {
+		return err
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+	return json.Unmarshal(response.Result, result)
+}
+
+func (err *rpcError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", err.Code, err.Message)
+}
+
+// rpcErrInvalidAddressOrKey is the code of the errors returned by the node
+// for unknown transactions and blocks.
+const rpcErrInvalidAddressOrKey = -5
+
+func (err *rpcError) Is(target error) bool {
+	return target == ErrNotFound && err.Code == rpcErrInvalidAddressOrKey
+}
@@ -0,0 +1,161 @@
+package libbtc
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// AddressType is the type of address an Account receives to, and spends from.
+type AddressType int
+
+const (
+	// AddressTypeLegacy is a P2PKH address.
+	AddressTypeLegacy AddressType = iota
+
+	// AddressTypeP2SHSegWit is a P2WPKH address nested in a P2SH address.
+	AddressTypeP2SHSegWit
+
+	// AddressTypeNativeSegWit is a bech32 encoded P2WPKH address.
+	AddressTypeNativeSegWit
+)
+
+// WithAddressType sets the type of address the Account receives to, and
+// spends from. By default, legacy P2PKH addresses are used.
+func WithAddressType(addressType AddressType) AccountOption {
+	return func(account *account) {
+		account.addressType = addressType
+	}
+}
+
+// SegWitAddress returns the native SegWit (P2WPKH) address of the account.
+// SegWit addresses always commit to the compressed public key.
+func (account *account) SegWitAddress() (*btcutil.AddressWitnessPubKeyHash, error) {
+	pubKey, err := account.compressedPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	return btcutil.NewAddressWitnessPubKeyHash(btcutil.Hash160(pubKey), account.NetworkParams())
+}
+
+// nestedSegWitScript returns the P2WPKH script that is nested in the P2SH
+// SegWit address of the account.
+func (account *account) nestedSegWitScript() ([]byte, error) {
+	segWitAddress, err := account.SegWitAddress()
+	if err != nil {
+		return nil, err
+	}
+	return txscript.PayToAddrScript(segWitAddress)
+}
+
+func (account *account) compressedPublicKey() ([]byte, error) {
+	pubKey, err := btcec.ParsePubKey(account.signer.PublicKey(), btcec.S256())
+	if err != nil {
+		return nil, err
+	}
+	return pubKey.SerializeCompressed(), nil
+}
+
+// scriptClass returns the class of the scripts the account receives to.
+func (account *account) scriptClass() txscript.ScriptClass {
+	switch account.addressType {
+	case AddressTypeNativeSegWit:
+		return txscript.WitnessV0PubKeyHashTy
+	case AddressTypeP2SHSegWit:
+		return txscript.ScriptHashTy
+	default:
+		return txscript.PubKeyHashTy
+	}
+}
+
+// witnessSignature returns the witness of an input spending a P2WPKH output
+// (or a P2WPKH script nested in a P2SH output) of the account.
+func (account *account) witnessSignature(msgTx *wire.MsgTx, sigHashes *txscript.TxSigHashes, idx int, amount int64, witnessScript []byte, hashType txscript.SigHashType) (wire.TxWitness, error) {
+	hash, err := txscript.CalcWitnessSigHash(witnessScript, sigHashes, hashType, msgTx, idx, amount)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := account.signer.Sign(hash)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := account.compressedPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	return wire.TxWitness{append(sig, byte(hashType)), pubKey}, nil
+}
+
+// WitnessScriptAddress returns the P2WSH address that pays to the witness
+// script.
+func WitnessScriptAddress(witnessScript []byte, params *chaincfg.Params) (*btcutil.AddressWitnessScriptHash, error) {
+	hash := sha256.Sum256(witnessScript)
+	return btcutil.NewAddressWitnessScriptHash(hash[:], params)
+}
+
+// ContractAddress returns the P2SH address of the contract on the network of
+// the account, which funds the contract when it is spent by SendTransaction.
+func (account *account) ContractAddress(contract []byte) (btcutil.Address, error) {
+	return contractAddress(contract, false, account.NetworkParams())
+}
+
+// ContractP2WSHAddress returns the P2WSH address of the contract on the
+// network of the account, which funds the contract when it is spent by
+// SendTransaction using WitnessContract.
+func (account *account) ContractP2WSHAddress(contract []byte) (btcutil.Address, error) {
+	return contractAddress(contract, true, account.NetworkParams())
+}
+
+// contractAddress returns the P2WSH address of the contract if witness is
+// true, and its P2SH address otherwise.
+func contractAddress(contract []byte, witness bool, params *chaincfg.Params) (btcutil.Address, error) {
+	if witness {
+		return WitnessScriptAddress(contract, params)
+	}
+	return btcutil.NewAddressScriptHash(contract, params)
+}
+
+// pushedWitness returns the items pushed by a push only script, such as the
+// signature script built for a contract, so that they can be used as a
+// witness. Unlike txscript.PushedData, small integers are included.
+func pushedWitness(script []byte) (wire.TxWitness, error) {
+	if !txscript.IsPushOnlyScript(script) {
+		return nil, ErrNotPushOnly
+	}
+	witness := wire.TxWitness{}
+	for len(script) > 0 {
+		op := script[0]
+		script = script[1:]
+		switch {
+		case op >= txscript.OP_1 && op <= txscript.OP_16:
+			witness = append(witness, []byte{op - txscript.OP_1 + 1})
+		case op == txscript.OP_1NEGATE:
+			witness = append(witness, []byte{0x81})
+		default:
+			n := int(op)
+			switch op {
+			case txscript.OP_PUSHDATA1:
+				n, script = int(script[0]), script[1:]
+			case txscript.OP_PUSHDATA2:
+				n, script = int(binary.LittleEndian.Uint16(script)), script[2:]
+			case txscript.OP_PUSHDATA4:
+				n, script = int(binary.LittleEndian.Uint32(script)), script[4:]
+			}
+			witness = append(witness, script[:n])
+			script = script[n:]
+		}
+	}
+	return witness, nil
+}
+
+// virtualSize returns the virtual size (in bytes) of the transaction, which
+// discounts its witness data.
+func virtualSize(msgTx *wire.MsgTx) int64 {
+	weight := msgTx.SerializeSizeStripped()*3 + msgTx.SerializeSize()
+	return int64((weight + 3) / 4)
+}
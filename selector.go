@@ -0,0 +1,189 @@
+package libbtc
+
+import (
+	"sort"
+)
+
+// CoinSelector selects the unspent outputs used to fund a transaction.
+type CoinSelector interface {
+	// Select should return a subset of the unspent outputs with a total
+	// value of at least the target value, or an error if there is no such
+	// subset.
+	Select(utxos []UnspentOutput, target int64) ([]UnspentOutput, error)
+}
+
+type largestFirstSelector struct{}
+
+// LargestFirstSelector returns a CoinSelector that spends the largest unspent
+// outputs first, which keeps transactions small.
+func LargestFirstSelector() CoinSelector {
+	return largestFirstSelector{}
+}
+
+func (largestFirstSelector) Select(utxos []UnspentOutput, target int64) ([]UnspentOutput, error) {
+	return selectLargestFirst(utxos, target)
+}
+
+type smallestFirstSelector struct{}
+
+// SmallestFirstSelector returns a CoinSelector that spends the smallest
+// unspent outputs first, which consolidates them at the cost of larger
+// transactions.
+func SmallestFirstSelector() CoinSelector {
+	return smallestFirstSelector{}
+}
+
+func (smallestFirstSelector) Select(utxos []UnspentOutput, target int64) ([]UnspentOutput, error) {
+	sorted := append([]UnspentOutput{}, utxos...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Amount < sorted[j].Amount
+	})
+	selected := []UnspentOutput{}
+	var total int64
+	for _, utxo := range sorted {
+		if total >= target {
+			break
+		}
+		selected = append(selected, utxo)
+		total = total + utxo.Amount
+	}
+	if total < target {
+		return nil, ErrInsufficientUnspentOutputs
+	}
+	return selected, nil
+}
+
+// branchAndBoundTries is the maximum number of branches explored by the
+// branch and bound selector before it gives up on avoiding change.
+const branchAndBoundTries = 100000
+
+type branchAndBoundSelector struct {
+	costOfChange int64
+}
+
+// BranchAndBoundSelector returns a CoinSelector that searches for a set of
+// unspent outputs covering the target without exceeding it by more than
+// costOfChange, so that no change output is needed. The excess is left to the
+// miners. If there is no such set, the largest unspent outputs are spent
+// first.
+func BranchAndBoundSelector(costOfChange int64) CoinSelector {
+	return branchAndBoundSelector{costOfChange: costOfChange}
+}
+
+func (selector branchAndBoundSelector) Select(utxos []UnspentOutput, target int64) ([]UnspentOutput, error) {
+	sorted := append([]UnspentOutput{}, utxos...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Amount > sorted[j].Amount
+	})
+	// remaining[i] is the total value of the unspent outputs from i onwards,
+	// which bounds what a branch can still add.
+	remaining := make([]int64, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].Amount
+	}
+
+	var best []UnspentOutput
+	var bestTotal int64
+	tries := 0
+	var search func(i int, selected []UnspentOutput, total int64)
+	search = func(i int, selected []UnspentOutput, total int64) {
+		if tries >= branchAndBoundTries || (best != nil && bestTotal == target) {
+			return
+		}
+		tries++
+		if total > target+selector.costOfChange {
+			return
+		}
+		if total >= target {
+			if best == nil || total < bestTotal {
+				best = append([]UnspentOutput{}, selected...)
+				bestTotal = total
+			}
+			return
+		}
+		if i == len(sorted) || total+remaining[i] < target {
+			return
+		}
+		search(i+1, append(selected, sorted[i]), total+sorted[i].Amount)
+		search(i+1, selected, total)
+	}
+	search(0, []UnspentOutput{}, 0)
+
+	if best == nil {
+		return selectLargestFirst(utxos, target)
+	}
+	return best, nil
+}
+
+type privacyAwareSelector struct{}
+
+// PrivacyAwareSelector returns a CoinSelector that avoids partial spends of
+// an address. Unspent outputs are grouped by the script they pay to, and
+// every output of a group is spent together, so that outputs left behind on
+// an address do not link later transactions to this one. If a single group
+// can cover the target, only the smallest such group is spent. Otherwise the
+// largest groups are combined, so that as few addresses as possible are
+// revealed to be owned by the same wallet. Accounts fund transactions from a
+// single address, so when funding from an Account, every unspent output of
+// the account is spent, at the cost of a larger transaction.
+func PrivacyAwareSelector() CoinSelector {
+	return privacyAwareSelector{}
+}
+
+func (privacyAwareSelector) Select(utxos []UnspentOutput, target int64) ([]UnspentOutput, error) {
+	groups := map[string][]UnspentOutput{}
+	totals := map[string]int64{}
+	scripts := []string{}
+	for _, utxo := range utxos {
+		if _, ok := groups[utxo.ScriptPubKey]; !ok {
+			scripts = append(scripts, utxo.ScriptPubKey)
+		}
+		groups[utxo.ScriptPubKey] = append(groups[utxo.ScriptPubKey], utxo)
+		totals[utxo.ScriptPubKey] = totals[utxo.ScriptPubKey] + utxo.Amount
+	}
+
+	// Prefer the smallest single group that covers the target, which keeps
+	// the larger groups intact for later transactions.
+	sort.SliceStable(scripts, func(i, j int) bool {
+		return totals[scripts[i]] < totals[scripts[j]]
+	})
+	for _, script := range scripts {
+		if totals[script] >= target {
+			return groups[script], nil
+		}
+	}
+
+	// Otherwise combine the largest groups until the target is covered.
+	selected := []UnspentOutput{}
+	var total int64
+	for i := len(scripts) - 1; i >= 0 && total < target; i-- {
+		selected = append(selected, groups[scripts[i]]...)
+		total = total + totals[scripts[i]]
+	}
+	if total < target {
+		return nil, ErrInsufficientUnspentOutputs
+	}
+	return selected, nil
+}
+
+// selectLargestFirst selects unspent outputs in descending order of value
+// until the target is covered.
+func selectLargestFirst(utxos []UnspentOutput, target int64) ([]UnspentOutput, error) {
+	sorted := append([]UnspentOutput{}, utxos...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Amount > sorted[j].Amount
+	})
+	selected := []UnspentOutput{}
+	var total int64
+	for _, utxo := range sorted {
+		if total >= target {
+			break
+		}
+		selected = append(selected, utxo)
+		total = total + utxo.Amount
+	}
+	if total < target {
+		return nil, ErrInsufficientUnspentOutputs
+	}
+	return selected, nil
+}
@@ -0,0 +1,81 @@
+package libbtc
+
+import (
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Signer produces signatures for an Account without exposing its private key,
+// which allows the key to be held by an HSM or KMS.
+type Signer interface {
+	// Sign should return the DER encoded signature of the hash.
+	Sign(hash []byte) ([]byte, error)
+
+	// PublicKey should return the serialized public key of the Signer, in
+	// either its compressed or uncompressed format.
+	PublicKey() []byte
+}
+
+type keySigner struct {
+	privKey *btcec.PrivateKey
+}
+
+// newKeySigner returns a Signer that signs using a private key held in
+// memory.
+func newKeySigner(privKey *btcec.PrivateKey) Signer {
+	return &keySigner{privKey}
+}
+
+func (signer *keySigner) Sign(hash []byte) ([]byte, error) {
+	sig, err := signer.privKey.Sign(hash)
+	if err != nil {
+		return nil, err
+	}
+	return sig.Serialize(), nil
+}
+
+func (signer *keySigner) PublicKey() []byte {
+	return signer.privKey.PubKey().SerializeCompressed()
+}
+
+type funcSigner struct {
+	pubKey []byte
+	sign   func(hash []byte) ([]byte, error)
+}
+
+// NewFuncSigner returns a Signer that hands the sighash of every input to the
+// sign function, such as a call to a remote HSM or KMS, which should return
+// the DER encoded signature of the hash. pubKey is the serialized public key
+// of the remote key. The signature scripts and witnesses are still built by
+// the Account, so that
+//
+//	NewAccountWithSigner(client, NewFuncSigner(pubKey, sign))
+//
+// signs exactly like an Account holding the private key.
+func NewFuncSigner(pubKey []byte, sign func(hash []byte) ([]byte, error)) Signer {
+	return &funcSigner{pubKey: pubKey, sign: sign}
+}
+
+func (signer *funcSigner) Sign(hash []byte) ([]byte, error) {
+	return signer.sign(hash)
+}
+
+func (signer *funcSigner) PublicKey() []byte {
+	return signer.pubKey
+}
+
+// rawTxInSignature returns the signature of the input of the transaction
+// against the subscript, using the Signer of the account. The hash type is
+// appended to the signature.
+func (account *account) rawTxInSignature(msgTx *wire.MsgTx, idx int, subScript []byte, hashType txscript.SigHashType) ([]byte, error) {
+	hash, err := txscript.CalcSignatureHash(subScript, hashType, msgTx, idx)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := account.signer.Sign(hash)
+	if err != nil {
+		return nil, err
+	}
+	return append(sig, byte(hashType)), nil
+}
@@ -0,0 +1,65 @@
+package libbtc
+
+// ScriptType is the type of script spent by a transaction input.
+type ScriptType int
+
+const (
+	// ScriptTypeP2PKH is a pay-to-public-key-hash script.
+	ScriptTypeP2PKH ScriptType = iota
+
+	// ScriptTypeP2SHP2WPKH is a P2WPKH script nested in a P2SH script.
+	ScriptTypeP2SHP2WPKH
+
+	// ScriptTypeP2WPKH is a native pay-to-witness-public-key-hash script.
+	ScriptTypeP2WPKH
+)
+
+const (
+	// Version (4), input and output counts (1 each) and locktime (4).
+	txOverheadSize = 10
+
+	// The SegWit marker and flag, which are witness data.
+	segWitOverheadWeight = 2
+
+	// Outpoint (36), sequence (4) and script length (1).
+	txInBaseSize = 36 + 4 + 1
+
+	// A push of a signature of up to 72 bytes plus the sighash type, and a
+	// push of a compressed public key.
+	sigAndPubKeySize = (1 + 73) + (1 + 33)
+
+	// Value (8), script length (1) and a P2PKH script (25).
+	p2pkhOutputSize = 8 + 1 + 25
+)
+
+// EstimateTxSize returns the expected virtual size (in bytes) of a signed
+// transaction spending numInputs inputs of the given script type, with
+// compressed public keys, to numOutputs P2PKH outputs. Witness data is
+// discounted, so SegWit inputs are estimated smaller than P2PKH inputs.
+// Outputs are estimated as P2PKH, which is at least as large as other
+// standard single key outputs.
+func EstimateTxSize(numInputs, numOutputs int, inputType ScriptType) int {
+	weight := 4 * (txOverheadSize + numOutputs*p2pkhOutputSize)
+	switch inputType {
+	case ScriptTypeP2WPKH:
+		weight += segWitOverheadWeight + numInputs*(4*txInBaseSize+1+sigAndPubKeySize)
+	case ScriptTypeP2SHP2WPKH:
+		// The signature script pushes the 22 byte witness program.
+		weight += segWitOverheadWeight + numInputs*(4*(txInBaseSize+1+22)+1+sigAndPubKeySize)
+	default:
+		weight += numInputs * 4 * (txInBaseSize + sigAndPubKeySize)
+	}
+	return (weight + 3) / 4
+}
+
+// scriptType returns the type of script the account spends from.
+func (account *account) scriptType() ScriptType {
+	switch account.addressType {
+	case AddressTypeNativeSegWit:
+		return ScriptTypeP2WPKH
+	case AddressTypeP2SHSegWit:
+		return ScriptTypeP2SHP2WPKH
+	default:
+		return ScriptTypeP2PKH
+	}
+}
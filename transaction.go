@@ -4,26 +4,116 @@ import (
 	"bytes"
 	"context"
 	"encoding/hex"
+	"errors"
+	"sort"
+	"time"
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/txsort"
 )
 
+// TxResult describes a transaction built by an Account.
+type TxResult struct {
+	TxHash string
+	TxSummary
+}
+
+// TxSummary breaks down the value spent by a transaction built by an Account.
+type TxSummary struct {
+	// Inputs are the outputs spent by the transaction, in the same order as
+	// its inputs.
+	Inputs []wire.OutPoint
+
+	// InputValues are the values of the outputs spent by each input, in
+	// the same order as the inputs of the transaction.
+	InputValues []int64
+
+	// OutputValues are the values of the outputs of the transaction, in
+	// the same order as its outputs.
+	OutputValues []int64
+
+	// TotalInput is the sum of the InputValues.
+	TotalInput int64
+
+	// TotalOutput is the sum of the OutputValues, including change.
+	TotalOutput int64
+
+	// Fee is the TotalInput minus the TotalOutput, which is the fee actually
+	// paid by the transaction.
+	Fee int64
+
+	// Change is the total value of the change outputs of the transaction.
+	Change int64
+}
+
 type tx struct {
-	receiveValues   []int64
-	scriptPublicKey []byte
-	account         *account
-	msgTx           *wire.MsgTx
-	ctx             context.Context
+	receiveValues []int64
+	prevScripts   [][]byte
+	account       *account
+	msgTx         *wire.MsgTx
+	ctx           context.Context
+	opts          sendOptions
+	changeIndex   int
+	changeOutputs []*wire.TxOut
+	change        int64
+	feeRate       int64
+	verified      map[wire.OutPoint]bool
+}
+
+// changeOutputSize is the size (in bytes) of a P2PKH change output.
+const changeOutputSize = 34
+
+// DustThreshold returns the smallest value (in SAT) of an output paying to
+// the script that is relayed by Bitcoin nodes. An output is dust if spending
+// it would cost more than a third of its value at the minimum relay fee. The
+// threshold is the same on every network, and is 546 SAT for P2PKH outputs
+// and 294 SAT for P2WPKH outputs. Data outputs are never dust.
+func DustThreshold(pkScript []byte) int64 {
+	if txscript.GetScriptClass(pkScript) == txscript.NullDataTy {
+		return 0
+	}
+	size := wire.NewTxOut(0, pkScript).SerializeSize()
+	if txscript.IsWitnessProgram(pkScript) {
+		// Outpoint (36), script length (1), sequence (4), and the witness
+		// (107) which is discounted by the witness scale factor.
+		size = size + 36 + 1 + 4 + 107/4
+	} else {
+		// Outpoint (36), script length (1), sequence (4), and the signature
+		// script (107).
+		size = size + 36 + 1 + 4 + 107
+	}
+	return 3 * int64(size)
+}
+
+// checkDust returns an error if any output of the transaction is dust.
+func (tx *tx) checkDust() error {
+	for _, txOut := range tx.msgTx.TxOut {
+		if threshold := DustThreshold(txOut.PkScript); txOut.Value < threshold {
+			return NewErrDustOutput(txOut.Value, threshold)
+		}
+	}
+	return nil
 }
 
-func (account *account) newTx(ctx context.Context, msgtx *wire.MsgTx) *tx {
+// release releases the unspent outputs reserved to fund the transaction, unless
+// it has been published.
+func (tx *tx) release() {
+	tx.account.reserved.release(tx)
+}
+
+func (account *account) newTx(ctx context.Context, msgtx *wire.MsgTx, opts sendOptions) *tx {
+	if opts.lockTime != 0 {
+		msgtx.LockTime = opts.lockTime
+	}
 	return &tx{
-		msgTx:   msgtx,
-		account: account,
-		ctx:     ctx,
+		msgTx:       msgtx,
+		account:     account,
+		ctx:         ctx,
+		opts:        opts,
+		changeIndex: -1,
 	}
 }
 
@@ -36,94 +126,555 @@ func (tx *tx) fund(addr btcutil.Address, fee int64) error {
 		}
 	}
 
+	if err := tx.checkDust(); err != nil {
+		return err
+	}
+
 	var value int64
 	for _, j := range tx.msgTx.TxOut {
 		value = value + j.Value
 	}
 	value = value + fee
 
-	balance, err := tx.account.Balance(tx.ctx, addr.EncodeAddress(), 0)
+	outputs, balance, reserved, err := tx.unspentOutputs(addr, value)
 	if err != nil {
 		return err
 	}
-
 	if value > balance {
+		if value <= balance+reserved {
+			return NewErrUTXOsReserved(value - balance)
+		}
 		return NewErrInsufficientBalance(addr.EncodeAddress(), value, balance)
 	}
-
-	utxos, err := tx.account.GetUnspentOutputs(tx.ctx, addr.EncodeAddress(), 1000, 0)
+	addrScript, err := txscript.PayToAddrScript(addr)
 	if err != nil {
 		return err
 	}
-	for _, j := range utxos.Outputs {
+	// Each input is signed against its own script, so outputs only need to
+	// pay to the address being funded, using the same type of script. Other
+	// outputs are filtered out before the coin selector ranks them.
+	spendable := make([]UnspentOutput, 0, len(outputs))
+	var spendableBalance int64
+	for _, j := range outputs {
 		ScriptPubKey, err := hex.DecodeString(j.ScriptPubKey)
 		if err != nil {
 			return err
 		}
-		if len(tx.scriptPublicKey) == 0 {
-			tx.scriptPublicKey = ScriptPubKey
-		} else {
-			if bytes.Compare(tx.scriptPublicKey, ScriptPubKey) != 0 {
-				continue
-			}
-		}
-		if value <= 0 {
-			break
+		if tx.paysTo(ScriptPubKey, addr, addrScript) {
+			spendable = append(spendable, j)
+			spendableBalance = spendableBalance + j.Amount
 		}
-		tx.receiveValues = append(tx.receiveValues, j.Amount)
-		hashBytes, err := hex.DecodeString(j.TransactionHash)
+	}
+	if tx.opts.selector != nil && spendableBalance >= value {
+		spendable, err = tx.opts.selector.Select(spendable, value)
 		if err != nil {
 			return err
 		}
-		hash, err := chainhash.NewHash(hashBytes)
+	}
+	for _, j := range spendable {
+		ScriptPubKey, err := hex.DecodeString(j.ScriptPubKey)
 		if err != nil {
 			return err
 		}
-		tx.msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(hash, j.TransactionOutputNumber), []byte{}, [][]byte{}))
+		// The outputs chosen by a coin selector are all spent.
+		if value <= 0 && tx.opts.selector == nil {
+			break
+		}
+		// Another transaction can reserve the output after it was
+		// fetched.
+		if !tx.account.reserved.reserve(j, tx) {
+			reserved = reserved + j.Amount
+			continue
+		}
+		if err := tx.addInput(j, ScriptPubKey); err != nil {
+			return err
+		}
 		value = value - j.Amount
 	}
 
 	if value > 0 {
-		return ErrMismatchedPubKeys
+		if value <= reserved {
+			return NewErrUTXOsReserved(value)
+		}
+		return NewErrMismatchedPubKeys(value)
 	}
 
 	if value < 0 {
-		P2PKHScript, err := txscript.PayToAddrScript(addr)
+		changeAddr, err := tx.changeAddress()
+		if err != nil {
+			return err
+		}
+		changeScript, err := txscript.PayToAddrScript(changeAddr)
 		if err != nil {
 			return err
 		}
-		tx.msgTx.AddTxOut(wire.NewTxOut(int64(-value), P2PKHScript))
+		// Change that would be dust, or when funding at a fee rate, that is
+		// not worth the fee of its own output, is left to the miners.
+		threshold := DustThreshold(changeScript)
+		if -value < threshold || (tx.feeRate > 0 && -value <= threshold+tx.feeRate*changeOutputSize) {
+			return nil
+		}
+		return tx.addChange(changeAddr, -value)
 	}
 
 	return nil
 }
 
-func (tx *tx) sign(f func(*txscript.ScriptBuilder), updateTxIn func(*wire.TxIn), contract []byte) error {
-	var subScript []byte
+// changeAddress returns the address that receives the change of the
+// transaction when no ChangeAddressSelector is used. This is the change
+// address of the send options, or otherwise the account's address, even when
+// the transaction spends from a contract.
+func (tx *tx) changeAddress() (btcutil.Address, error) {
+	if tx.opts.changeAddress == "" {
+		return tx.account.Address()
+	}
+	addr, err := btcutil.DecodeAddress(tx.opts.changeAddress, tx.account.NetworkParams())
+	if err != nil {
+		return nil, err
+	}
+	if !addr.IsForNet(tx.account.NetworkParams()) {
+		return nil, NewErrInvalidAddresses([]string{tx.opts.changeAddress})
+	}
+	return addr, nil
+}
+
+// addChange adds the change outputs of the transaction. The change is sent to
+// the addresses chosen by the ChangeAddressSelector of the send options, or
+// otherwise to the given address. Change that would be dust at the address it
+// is sent to is added to the first change output that would not be, so that
+// the transaction stays standard.
+func (tx *tx) addChange(addr btcutil.Address, change int64) error {
+	changeOutputs := []ChangeOutput{{Address: addr.EncodeAddress(), Value: change}}
+	if tx.opts.change != nil {
+		var err error
+		changeOutputs, err = tx.opts.change.SelectChange(change)
+		if err != nil {
+			return err
+		}
+	}
+	var first *wire.TxOut
+	txOuts := []*wire.TxOut{}
+	dust := int64(0)
+	for _, output := range changeOutputs {
+		changeAddr, err := btcutil.DecodeAddress(output.Address, tx.account.NetworkParams())
+		if err != nil {
+			return err
+		}
+		P2PKHScript, err := txscript.PayToAddrScript(changeAddr)
+		if err != nil {
+			return err
+		}
+		txOut := wire.NewTxOut(output.Value, P2PKHScript)
+		if first == nil {
+			first = txOut
+		}
+		if txOut.Value < DustThreshold(txOut.PkScript) {
+			dust = dust + txOut.Value
+			continue
+		}
+		txOuts = append(txOuts, txOut)
+	}
+	if first == nil {
+		return nil
+	}
+	if len(txOuts) == 0 {
+		// Every output would be dust, so all of the change is sent to the
+		// first address.
+		first.Value = 0
+		txOuts = append(txOuts, first)
+	}
+	txOuts[0].Value = txOuts[0].Value + dust
+	for _, txOut := range txOuts {
+		tx.msgTx.AddTxOut(txOut)
+		tx.changeIndex = len(tx.msgTx.TxOut) - 1
+		tx.changeOutputs = append(tx.changeOutputs, txOut)
+		tx.change = tx.change + txOut.Value
+	}
+	return nil
+}
+
+// result describes the signed transaction, and copies the description into
+// the TxResult and TxSummary of the send options if there are any.
+func (tx *tx) result() TxResult {
+	summary := TxSummary{
+		InputValues: append([]int64{}, tx.receiveValues...),
+		Change:      tx.change,
+	}
+	for _, txIn := range tx.msgTx.TxIn {
+		summary.Inputs = append(summary.Inputs, txIn.PreviousOutPoint)
+	}
+	for _, value := range tx.receiveValues {
+		summary.TotalInput = summary.TotalInput + value
+	}
+	for _, txOut := range tx.msgTx.TxOut {
+		summary.OutputValues = append(summary.OutputValues, txOut.Value)
+		summary.TotalOutput = summary.TotalOutput + txOut.Value
+	}
+	summary.Fee = summary.TotalInput - summary.TotalOutput
+	result := TxResult{
+		TxHash:    tx.msgTx.TxHash().String(),
+		TxSummary: summary,
+	}
+	if tx.opts.result != nil {
+		*tx.opts.result = result
+	}
+	if tx.opts.summary != nil {
+		*tx.opts.summary = summary
+	}
+	return result
+}
+
+// checkFee returns ErrFeeExceedsPercentOfAmount if the fee of the funded
+// transaction exceeds the maximum percentage of the amount sent (excluding
+// change) that is allowed by the send options.
+func (tx *tx) checkFee() error {
+	if tx.opts.maxFeePercent <= 0 {
+		return nil
+	}
+	var in, amount, change int64
+	for _, value := range tx.receiveValues {
+		in = in + value
+	}
+	for _, txOut := range tx.msgTx.TxOut {
+		if tx.isChange(txOut) {
+			change = change + txOut.Value
+		} else {
+			amount = amount + txOut.Value
+		}
+	}
+	fee := in - amount - change
+	if float64(fee) > float64(amount)*tx.opts.maxFeePercent/100 {
+		return ErrFeeExceedsPercentOfAmount
+	}
+	return nil
+}
+
+// checkMaxFee returns an error wrapping ErrFeeTooHigh if the fee of the signed
+// transaction exceeds the maximum fee rate, or the maximum fee, allowed by
+// the send options. Transactions spending outputs of unknown value are not
+// checked.
+func (tx *tx) checkMaxFee() error {
+	if len(tx.receiveValues) != len(tx.msgTx.TxIn) {
+		return nil
+	}
+	fee := int64(0)
+	for _, value := range tx.receiveValues {
+		fee = fee + value
+	}
+	for _, txOut := range tx.msgTx.TxOut {
+		fee = fee - txOut.Value
+	}
+	max := tx.opts.maxFee
+	feeRate := tx.opts.maxFeeRate
+	if feeRate == 0 {
+		feeRate = DefaultMaxFeeRate
+	}
+	if feeRate > 0 {
+		if maxForRate := feeRate * virtualSize(tx.msgTx); max <= 0 || maxForRate < max {
+			max = maxForRate
+		}
+	}
+	if max > 0 && fee > max {
+		return NewErrFeeTooHigh(fee, max)
+	}
+	return nil
+}
+
+// addOutputs adds an output paying each address (in lexicographic order)
+// its value. If any of the addresses are invalid on the network of the
+// account, no outputs are added and all invalid addresses are reported.
+func (tx *tx) addOutputs(outputs map[string]int64) error {
+	addresses := make([]string, 0, len(outputs))
+	for address := range outputs {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	scripts := make([][]byte, 0, len(addresses))
+	invalid := []string{}
+	for _, to := range addresses {
+		address, err := btcutil.DecodeAddress(to, tx.account.NetworkParams())
+		if err != nil || !address.IsForNet(tx.account.NetworkParams()) {
+			invalid = append(invalid, to)
+			continue
+		}
+		script, err := txscript.PayToAddrScript(address)
+		if err != nil {
+			return err
+		}
+		scripts = append(scripts, script)
+	}
+	if len(invalid) > 0 {
+		return NewErrInvalidAddresses(invalid)
+	}
+	for i, to := range addresses {
+		tx.msgTx.AddTxOut(wire.NewTxOut(outputs[to], scripts[i]))
+	}
+	return nil
+}
+
+// unspentOutputs fetches pages of the unspent outputs of the address until
+// their total value covers the target value, or there are no more pages. It
+// returns the unspent outputs and their total value, and the total value of
+// the outputs that were skipped because other transactions reserve them.
+func (tx *tx) unspentOutputs(addr btcutil.Address, target int64) ([]UnspentOutput, int64, int64, error) {
+	tx.account.reserved.prune(tx.ctx, tx.account)
+	outputs := []UnspentOutput{}
+	var total, reserved int64
+	err := iterateUnspent(tx.ctx, tx.account, addr.EncodeAddress(), tx.opts.confirmations(), func(page []UnspentOutput) bool {
+		for _, utxo := range page {
+			// Outputs funding other transactions of the account are
+			// skipped, so that concurrent transactions do not conflict.
+			if tx.account.reserved.reserved(utxo, tx) {
+				reserved = reserved + utxo.Amount
+				continue
+			}
+			outputs = append(outputs, utxo)
+			total = total + utxo.Amount
+		}
+		return total < target
+	})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return outputs, total, reserved, nil
+}
+
+// paysTo returns true if the script pays to the address, using the same type
+// of script as addrScript, the script of the address.
+func (tx *tx) paysTo(script []byte, addr btcutil.Address, addrScript []byte) bool {
+	if bytes.Equal(script, addrScript) {
+		return true
+	}
+	if txscript.GetScriptClass(script) != txscript.GetScriptClass(addrScript) {
+		return false
+	}
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(script, tx.account.NetworkParams())
+	return err == nil && len(addrs) == 1 && addrs[0].EncodeAddress() == addr.EncodeAddress()
+}
+
+// addInput adds an input spending the unspent output, which pays to the
+// given script.
+func (tx *tx) addInput(utxo UnspentOutput, script []byte) error {
+	hashBytes, err := hex.DecodeString(utxo.TransactionHash)
+	if err != nil {
+		return err
+	}
+	hash, err := chainhash.NewHash(hashBytes)
+	if err != nil {
+		return err
+	}
+	txIn := wire.NewTxIn(wire.NewOutPoint(hash, utxo.TransactionOutputNumber), []byte{}, [][]byte{})
+	if tx.opts.rbf {
+		txIn.Sequence = rbfSequence
+	} else if tx.msgTx.LockTime != 0 {
+		// The locktime, and so OP_CHECKLOCKTIMEVERIFY, is ignored if every
+		// input is final.
+		txIn.Sequence = wire.MaxTxInSequenceNum - 1
+	}
+	tx.msgTx.AddTxIn(txIn)
+	tx.receiveValues = append(tx.receiveValues, utxo.Amount)
+	tx.prevScripts = append(tx.prevScripts, script)
+	return nil
+}
+
+// fundWithFeeRate funds the transaction such that the fee covers feeRate SAT
+// per byte of the signed transaction. Adding inputs increases the size of the
+// transaction, so funding is repeated until the fee converges, starting from
+// the estimated fee of a single input. Change is only added if it exceeds the
+// dust threshold plus the fee of the change output.
+func (tx *tx) fundWithFeeRate(addr btcutil.Address, feeRate int64, f func(*txscript.ScriptBuilder), contract []byte) error {
+	txOuts := tx.msgTx.TxOut
+	tx.feeRate = feeRate
+	var fee int64
 	if contract == nil {
-		subScript = tx.scriptPublicKey
-	} else {
-		subScript = contract
+		fee = feeRate * int64(EstimateTxSize(1, len(txOuts), tx.account.scriptType()))
 	}
-	serializedPublicKey, err := tx.account.SerializedPublicKey()
+	for {
+		tx.release()
+		tx.msgTx.TxIn = nil
+		tx.msgTx.TxOut = append([]*wire.TxOut{}, txOuts...)
+		tx.receiveValues = nil
+		tx.prevScripts = nil
+		tx.changeIndex = -1
+		tx.changeOutputs = nil
+		tx.change = 0
+		if err := tx.fund(addr, fee); err != nil {
+			return err
+		}
+		tx.runPostFund()
+		if err := tx.sign(f, nil, contract); err != nil {
+			return err
+		}
+		required := feeRate * virtualSize(tx.msgTx)
+		if fee >= required {
+			return nil
+		}
+		fee = required
+	}
+}
+
+// estimateSize returns the expected virtual size (in bytes) of a signed
+// transaction spending the given number of the account's outputs to the
+// given number of P2PKH outputs.
+func (account *account) estimateSize(numInputs, numOutputs int) (int64, error) {
+	serializedPublicKey, err := account.SerializedPublicKey()
 	if err != nil {
+		return 0, err
+	}
+	size := EstimateTxSize(numInputs, numOutputs, account.scriptType())
+	if account.addressType == AddressTypeLegacy {
+		size += numInputs * (len(serializedPublicKey) - 33)
+	}
+	return int64(size), nil
+}
+
+// deductFee signs the transaction to learn its size, deducts a fee of feeRate
+// SAT per byte (but at least minFee) from the output at the given index, and
+// signs the transaction again.
+func (tx *tx) deductFee(index int, feeRate, minFee int64, f func(*txscript.ScriptBuilder), contract []byte) error {
+	if err := tx.sign(f, nil, contract); err != nil {
 		return err
 	}
+	fee := feeRate * virtualSize(tx.msgTx)
+	if fee < minFee {
+		fee = minFee
+	}
+	value := tx.msgTx.TxOut[index].Value
+	if fee >= value {
+		return NewErrFeeExceedsValue(fee, value)
+	}
+	tx.msgTx.TxOut[index].Value = value - fee
+	return tx.sign(f, nil, contract)
+}
+
+// runPostFund calls the WithPostFund hook of the send options, if any, and
+// finds the change outputs again in case the hook moved or removed them.
+func (tx *tx) runPostFund() {
+	if tx.opts.postFund == nil {
+		return
+	}
+	var change *wire.TxOut
+	if tx.changeIndex >= 0 {
+		change = tx.msgTx.TxOut[tx.changeIndex]
+	}
+	tx.opts.postFund(tx.msgTx)
+	changeOutputs := tx.changeOutputs
+	tx.changeIndex = -1
+	tx.changeOutputs = nil
+	for i, txOut := range tx.msgTx.TxOut {
+		if change != nil && txOut == change {
+			tx.changeIndex = i
+		}
+		for _, changeOutput := range changeOutputs {
+			if txOut == changeOutput {
+				tx.changeOutputs = append(tx.changeOutputs, txOut)
+			}
+		}
+	}
+}
+
+// isChange returns true if the output is one of the change outputs of the
+// transaction.
+func (tx *tx) isChange(txOut *wire.TxOut) bool {
+	for _, changeOutput := range tx.changeOutputs {
+		if txOut == changeOutput {
+			return true
+		}
+	}
+	return false
+}
+
+// sort orders the inputs and outputs of the transaction according to BIP69,
+// keeping the received values aligned with their inputs. It must be called
+// before the transaction is signed.
+func (tx *tx) sort() {
+	values := map[wire.OutPoint]int64{}
+	scripts := map[wire.OutPoint][]byte{}
 	for i, txin := range tx.msgTx.TxIn {
-		if updateTxIn != nil {
+		values[txin.PreviousOutPoint] = tx.receiveValues[i]
+		scripts[txin.PreviousOutPoint] = tx.prevScripts[i]
+	}
+	txsort.InPlaceSort(tx.msgTx)
+	for i, txin := range tx.msgTx.TxIn {
+		tx.receiveValues[i] = values[txin.PreviousOutPoint]
+		tx.prevScripts[i] = scripts[txin.PreviousOutPoint]
+	}
+}
+
+// sign signs every input of the transaction. If contract is provided, inputs
+// spending from the contract are signed against the contract, f is used to
+// add data to their signature scripts (unless the send options build their
+// signature scripts instead), and the contract is pushed last. All
+// other inputs are signed as inputs of the account, according to its
+// AddressType, and ErrMixedInputTypes is returned if they are of another
+// type.
+func (tx *tx) sign(f func(*txscript.ScriptBuilder), updateTxIn func(*wire.TxIn), contract []byte) error {
+	serializedPublicKey, err := tx.account.SerializedPublicKey()
+	if err != nil {
+		return err
+	}
+	var contractScript []byte
+	if contract != nil {
+		address, err := contractAddress(contract, tx.opts.witness, tx.account.NetworkParams())
+		if err != nil {
+			return err
+		}
+		if contractScript, err = txscript.PayToAddrScript(address); err != nil {
+			return err
+		}
+	}
+	if updateTxIn != nil {
+		for _, txin := range tx.msgTx.TxIn {
 			updateTxIn(txin)
 		}
-		sig, err := txscript.RawTxInSignature(tx.msgTx, i, subScript, txscript.SigHashAll, tx.account.PrivKey)
+	}
+	if tx.opts.verifyPrevOut {
+		if err := tx.verifyPrevOuts(); err != nil {
+			return err
+		}
+	}
+	if err := checkSigHashType(tx.opts.hashType(), len(tx.msgTx.TxIn), len(tx.msgTx.TxOut)); err != nil {
+		return err
+	}
+	sigHashes := txscript.NewTxSigHashes(tx.msgTx)
+	for i, txin := range tx.msgTx.TxIn {
+		subScript := tx.prevScripts[i]
+		spendsContract := contract != nil && bytes.Equal(subScript, contractScript)
+		if !spendsContract {
+			if txscript.GetScriptClass(subScript) != tx.account.scriptClass() {
+				return ErrMixedInputTypes
+			}
+			if tx.account.addressType != AddressTypeLegacy {
+				if err := tx.signWitness(i, sigHashes); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if spendsContract && tx.opts.witness {
+			if err := tx.signWitnessContract(i, sigHashes, contract, f); err != nil {
+				return err
+			}
+			continue
+		}
+		if spendsContract {
+			subScript = contract
+		}
+		sig, err := tx.account.rawTxInSignature(tx.msgTx, i, subScript, tx.opts.hashType())
 		if err != nil {
 			return err
 		}
 		builder := txscript.NewScriptBuilder()
-		builder.AddData(sig)
-		builder.AddData(serializedPublicKey)
-		if f != nil {
-			f(builder)
+		if spendsContract && tx.opts.scriptSig != nil {
+			tx.opts.scriptSig(sig, serializedPublicKey, builder)
+		} else {
+			builder.AddData(sig)
+			builder.AddData(serializedPublicKey)
+			if f != nil && (contract == nil || spendsContract) {
+				f(builder)
+			}
 		}
-		if contract != nil {
+		if spendsContract {
 			builder.AddData(contract)
 		}
 		sigScript, err := builder.Script()
@@ -135,9 +686,92 @@ func (tx *tx) sign(f func(*txscript.ScriptBuilder), updateTxIn func(*wire.TxIn),
 	return nil
 }
 
+// checkSigHashType returns an error if the sighash type is not a standard
+// sighash type, or if it is SigHashSingle and some input has no output at the
+// same index. Such inputs would sign a constant hash, which anyone can reuse.
+func checkSigHashType(hashType txscript.SigHashType, numInputs, numOutputs int) error {
+	switch hashType &^ txscript.SigHashAnyOneCanPay {
+	case txscript.SigHashAll, txscript.SigHashNone:
+		return nil
+	case txscript.SigHashSingle:
+		if numInputs > numOutputs {
+			return NewErrSigHashSingleOutputs(numInputs, numOutputs)
+		}
+		return nil
+	default:
+		return NewErrInvalidSigHashType(hashType)
+	}
+}
+
+// signWitness signs an input spending a SegWit output of the account. Nested
+// SegWit inputs also push the P2WPKH script in their signature scripts.
+func (tx *tx) signWitness(i int, sigHashes *txscript.TxSigHashes) error {
+	witnessScript := tx.prevScripts[i]
+	txin := tx.msgTx.TxIn[i]
+	if tx.account.addressType == AddressTypeP2SHSegWit {
+		var err error
+		if witnessScript, err = tx.account.nestedSegWitScript(); err != nil {
+			return err
+		}
+		sigScript, err := txscript.NewScriptBuilder().AddData(witnessScript).Script()
+		if err != nil {
+			return err
+		}
+		txin.SignatureScript = sigScript
+	}
+	witness, err := tx.account.witnessSignature(tx.msgTx, sigHashes, i, tx.receiveValues[i], witnessScript, tx.opts.hashType())
+	if err != nil {
+		return err
+	}
+	txin.Witness = witness
+	return nil
+}
+
+// signWitnessContract signs an input spending a P2WSH contract. The data that
+// would be pushed by its signature script is put in its witness instead,
+// followed by the contract.
+func (tx *tx) signWitnessContract(i int, sigHashes *txscript.TxSigHashes, contract []byte, f func(*txscript.ScriptBuilder)) error {
+	hashType := tx.opts.hashType()
+	hash, err := txscript.CalcWitnessSigHash(contract, sigHashes, hashType, tx.msgTx, i, tx.receiveValues[i])
+	if err != nil {
+		return err
+	}
+	sig, err := tx.account.signer.Sign(hash)
+	if err != nil {
+		return err
+	}
+	sig = append(sig, byte(hashType))
+	pubKey, err := tx.account.compressedPublicKey()
+	if err != nil {
+		return err
+	}
+	builder := txscript.NewScriptBuilder()
+	if tx.opts.scriptSig != nil {
+		tx.opts.scriptSig(sig, pubKey, builder)
+	} else {
+		builder.AddData(sig)
+		builder.AddData(pubKey)
+		if f != nil {
+			f(builder)
+		}
+	}
+	script, err := builder.Script()
+	if err != nil {
+		return err
+	}
+	witness, err := pushedWitness(script)
+	if err != nil {
+		return err
+	}
+	txin := tx.msgTx.TxIn[i]
+	txin.SignatureScript = nil
+	txin.Witness = append(witness, contract)
+	return nil
+}
+
 func (tx *tx) verify() error {
 	for i, receiveValue := range tx.receiveValues {
-		engine, err := txscript.NewEngine(tx.scriptPublicKey, tx.msgTx, i,
+		engine, err := txscript.NewEngine(tx.prevScripts[i], tx.msgTx, i,
 			txscript.StandardVerifyFlags, txscript.NewSigCache(10),
 			txscript.NewTxSigHashes(tx.msgTx), receiveValue)
 		if err != nil {
@@ -151,10 +785,252 @@ func (tx *tx) verify() error {
 }
 
 func (tx *tx) submit() error {
-	var stxBuffer bytes.Buffer
-	stxBuffer.Grow(tx.msgTx.SerializeSize())
-	if err := tx.msgTx.Serialize(&stxBuffer); err != nil {
+	if err := tx.checkMaxFee(); err != nil {
+		return err
+	}
+	stx, err := tx.serialize()
+	if err != nil {
+		return err
+	}
+	if tx.opts.verifyTxid && tx.msgTx.TxHash().String() != tx.expectedTxid() {
+		return ErrTxidMismatch
+	}
+	if err := tx.account.PublishTransaction(tx.ctx, stx); err != nil {
+		return err
+	}
+	tx.account.reserved.publish(tx, tx.msgTx)
+	if tx.opts.verifyTxid {
+		return tx.verifyTxid()
+	}
+	return nil
+}
+
+const (
+	// DefaultPollInterval is the interval at which SendTransaction checks
+	// its post-condition, unless WithPollInterval is used.
+	DefaultPollInterval = 5 * time.Second
+
+	// DefaultRebroadcastInterval is the interval at which SendTransaction
+	// publishes its transaction again while its post-condition does not
+	// hold, unless WithRebroadcastInterval is used.
+	DefaultRebroadcastInterval = 5 * time.Minute
+
+	// DefaultMaxRebroadcasts is the number of times SendTransaction
+	// publishes its transaction again before giving up, unless
+	// WithMaxRebroadcasts is used.
+	DefaultMaxRebroadcasts = 12
+)
+
+// submitUntil publishes the transaction, and publishes it again at the
+// rebroadcast interval of the send options until the post-condition holds. It
+// returns the hash of the transaction, or an error wrapping
+// ErrConfirmationTimeout if the context is done, or the confirmation timeout
+// of the send options passes, first. Once the transaction has been published,
+// failing to publish it again is expected (nodes reject transactions they
+// already have), so those errors are only returned, wrapped by
+// ErrRebroadcastLimit, once the transaction has been published again the
+// maximum number of times.
+func (tx *tx) submitUntil(postCond func(*wire.MsgTx) bool) (string, error) {
+	ctx := tx.ctx
+	if tx.opts.confirmationTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tx.opts.confirmationTimeout)
+		defer cancel()
+	}
+	select {
+	case <-ctx.Done():
+		return "", NewErrConfirmationTimeout(ctx.Err())
+	default:
+	}
+	if err := tx.submit(); err != nil {
+		return "", err
+	}
+
+	txHash := tx.msgTx.TxHash().String()
+	rebroadcastInterval, maxRebroadcasts := tx.opts.rebroadcasts()
+	var lastErr error
+	for rebroadcasts := 0; ; rebroadcasts++ {
+		submitted := time.Now()
+		for time.Since(submitted) < rebroadcastInterval {
+			if postCond == nil || postCond(tx.msgTx) {
+				return txHash, nil
+			}
+			select {
+			case <-ctx.Done():
+				return "", NewErrConfirmationTimeout(ctx.Err())
+			case <-time.After(tx.opts.interval()):
+			}
+		}
+		if rebroadcasts >= maxRebroadcasts {
+			return "", NewErrRebroadcastLimit(maxRebroadcasts, lastErr)
+		}
+		stx, err := tx.serialize()
+		if err != nil {
+			return "", err
+		}
+		if err := tx.account.PublishTransaction(ctx, stx); err != nil {
+			lastErr = err
+		}
+	}
+}
+
+// verifyPrevOuts fetches the transactions spent by the inputs, and checks that
+// the outputs they spend have the values and scripts that the inputs are
+// signed against. Transactions are checked against their hash, so that the
+// client cannot change them. Outputs that have been checked are skipped when
+// the transaction is signed again.
+func (tx *tx) verifyPrevOuts() error {
+	if tx.verified == nil {
+		tx.verified = map[wire.OutPoint]bool{}
+	}
+	for i, txIn := range tx.msgTx.TxIn {
+		outpoint := txIn.PreviousOutPoint
+		if tx.verified[outpoint] {
+			continue
+		}
+		stx, err := tx.account.GetSerializedTransaction(tx.ctx, outpoint.Hash.String())
+		if err != nil {
+			return err
+		}
+		prevTx, err := DecodeTransaction(stx)
+		if err != nil {
+			return err
+		}
+		if prevTx.TxHash() != outpoint.Hash {
+			return NewErrPrevOutMismatch(outpoint)
+		}
+		if int(outpoint.Index) >= len(prevTx.TxOut) {
+			return NewErrOutputNotFound(outpoint.Hash.String(), outpoint.Index)
+		}
+		prevOut := prevTx.TxOut[outpoint.Index]
+		if prevOut.Value != tx.receiveValues[i] || !bytes.Equal(prevOut.PkScript, tx.prevScripts[i]) {
+			return NewErrPrevOutMismatch(outpoint)
+		}
+		tx.verified[outpoint] = true
+	}
+	return nil
+}
+
+// verifyTxidAttempts is the number of times that the transaction spending
+// the first input of a broadcast transaction is looked for before giving up.
+const verifyTxidAttempts = 5
+
+// expectedTxid returns the txid expected by the send options, or otherwise
+// the txid of the transaction.
+func (tx *tx) expectedTxid() string {
+	if tx.opts.expectedTxid != "" {
+		return tx.opts.expectedTxid
+	}
+	return tx.msgTx.TxHash().String()
+}
+
+// verifyTxid finds the transaction that spends the first input of the
+// broadcast transaction, among the latest transactions of the address that
+// the input spends from, and checks that its txid is the expected txid. A
+// transaction that has just been broadcast may not be indexed yet, so the
+// address is polled a bounded number of times.
+func (tx *tx) verifyTxid() error {
+	if len(tx.prevScripts) == 0 {
+		return ErrTxidUnverified
+	}
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(tx.prevScripts[0], tx.account.NetworkParams())
+	if err != nil {
 		return err
 	}
-	return tx.account.PublishTransaction(tx.ctx, stxBuffer.Bytes())
+	if len(addrs) == 0 {
+		return ErrTxidUnverified
+	}
+	outpoint := tx.msgTx.TxIn[0].PreviousOutPoint
+	for attempt := 1; ; attempt++ {
+		txs, err := tx.account.GetAddressTransactions(tx.ctx, addrs[0].EncodeAddress(), 0, addressTxPageSize)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		for _, transaction := range txs {
+			spends, err := tx.spends(transaction, outpoint)
+			if err != nil {
+				return err
+			}
+			if !spends {
+				continue
+			}
+			if transaction.TransactionHash != tx.expectedTxid() {
+				return ErrTxidMismatch
+			}
+			return nil
+		}
+		if attempt >= verifyTxidAttempts {
+			return ErrTxidUnverified
+		}
+		select {
+		case <-tx.ctx.Done():
+			return ErrTxidUnverified
+		case <-time.After(tx.opts.interval()):
+		}
+	}
+}
+
+// spends returns true if the transaction spends the outpoint. Some APIs do not
+// report the hashes of the outputs spent by a transaction, and the serialized
+// transaction is fetched instead.
+func (tx *tx) spends(transaction Transaction, outpoint wire.OutPoint) (bool, error) {
+	for _, input := range transaction.Inputs {
+		if input.PrevOut.TransactionHash == "" {
+			return tx.spendsSerialized(transaction.TransactionHash, outpoint)
+		}
+		if input.PrevOut.TransactionHash == outpoint.Hash.String() && input.PrevOut.VoutNumber == outpoint.Index {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (tx *tx) spendsSerialized(txhash string, outpoint wire.OutPoint) (bool, error) {
+	stx, err := tx.account.GetSerializedTransaction(tx.ctx, txhash)
+	if err != nil {
+		return false, err
+	}
+	msgTx, err := DecodeTransaction(stx)
+	if err != nil {
+		return false, err
+	}
+	for _, txIn := range msgTx.TxIn {
+		if txIn.PreviousOutPoint == outpoint {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// serialize serializes the transaction using the encoding forced by the send
+// options, or otherwise the encoding matching whether it has witness data.
+func (tx *tx) serialize() ([]byte, error) {
+	encoding := defaultEncoding(tx.msgTx)
+	if tx.opts.encodingSet {
+		encoding = tx.opts.encoding
+	}
+	return serializeTransaction(tx.msgTx, encoding)
+}
+
+// defaultEncoding returns the encoding matching whether the transaction has
+// witness data.
+func defaultEncoding(msgTx *wire.MsgTx) wire.MessageEncoding {
+	if msgTx.HasWitness() {
+		return wire.WitnessEncoding
+	}
+	return wire.BaseEncoding
+}
+
+// serializeTransaction serializes the transaction using the encoding. The
+// zero encoding is wire.BaseEncoding, which cannot serialize witness data.
+func serializeTransaction(msgTx *wire.MsgTx, encoding wire.MessageEncoding) ([]byte, error) {
+	if encoding == wire.BaseEncoding && msgTx.HasWitness() {
+		return nil, ErrWitnessEncodingRequired
+	}
+	var stxBuffer bytes.Buffer
+	stxBuffer.Grow(msgTx.SerializeSize())
+	if err := msgTx.BtcEncode(&stxBuffer, wire.ProtocolVersion, encoding); err != nil {
+		return nil, err
+	}
+	return stxBuffer.Bytes(), nil
 }